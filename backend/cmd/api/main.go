@@ -1,7 +1,94 @@
 package main
 
-import "fmt"
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/migrate"
+	"github.com/stacklevest/backend/internal/storage"
+)
+
+const usage = `Usage: api <command> [flags]
+
+Commands:
+  migrate   Copy db.json into the SQLite store
+`
 
 func main() {
-	fmt.Println("StackleVest Backend Running...")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "migrate":
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func runMigrate(args []string) error {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", cfg.DBPath, "path to the source db.json")
+	to := fs.String("to", cfg.SQLitePath, "path to the target SQLite database")
+	dryRun := fs.Bool("dry-run", false, "report what would be migrated without writing any rows")
+	fs.Parse(args)
+
+	src, err := storage.ReadDB(*from)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", *from, err)
+	}
+
+	dst, err := migrate.OpenTarget(*to, *dryRun)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	report, err := migrate.Run(src, dst, *dryRun)
+	printReport(report)
+	return err
+}
+
+func printReport(report *migrate.Report) {
+	if report == nil {
+		return
+	}
+	if report.DryRun {
+		fmt.Println("Dry run: the target was not opened and no rows were written; records were checked against an empty database.")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tSOURCE\tINSERTED\tEXISTING\tSKIPPED")
+	for _, c := range report.Collections {
+		skipped := strconv.Itoa(len(c.Skipped))
+		if c.NotMigrated != "" {
+			skipped = "all"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", c.Name, c.Source, c.Inserted, c.Existing, skipped)
+	}
+	w.Flush()
+
+	for _, c := range report.Collections {
+		if c.NotMigrated != "" {
+			fmt.Printf("%s: skipped the whole collection: %s\n", c.Name, c.NotMigrated)
+		}
+		for _, note := range c.Notes {
+			fmt.Printf("%s: %s\n", c.Name, note)
+		}
+		for _, skipped := range c.Skipped {
+			fmt.Printf("%s: skipped %s\n", c.Name, skipped)
+		}
+	}
 }
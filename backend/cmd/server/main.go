@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/stacklevest/backend/internal/auth"
 	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/keyring"
+	"github.com/stacklevest/backend/internal/mail"
 	"github.com/stacklevest/backend/internal/middleware"
+	"github.com/stacklevest/backend/internal/password"
 	"github.com/stacklevest/backend/internal/storage"
 	"github.com/stacklevest/backend/internal/user"
 )
@@ -19,35 +27,105 @@ func main() {
 	// 1. Load Config
 	cfg := config.Load()
 
-	// 2. Initialize Storage (files)
-	store := storage.NewJSONStore(cfg.DBPath)
+	// 2. Initialize Storage
+	var store storage.Store
+	switch cfg.DBDriver {
+	case "json":
+		store = storage.NewJSONStore(cfg.DBPath)
+	case "sqlite":
+		sqliteStore, err := storage.NewSQLiteStore(cfg.SQLitePath)
+		if err != nil {
+			log.Fatalf("Failed to open SQLite database: %v", err)
+		}
+		store = sqliteStore
+	default:
+		log.Fatalf("Unknown DB_DRIVER %q (expected \"sqlite\" or \"json\")", cfg.DBDriver)
+	}
+
+	mailer, err := mail.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+
+	keys, err := keyring.Load(cfg.JWTKeysDir, cfg.JWTActiveKID, cfg.JWTKeyAlg)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	// SIGHUP re-reads the key directory, for rotation without a restart
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := keys.Reload(); err != nil {
+				log.Printf("Failed to reload JWT signing keys: %v", err)
+				continue
+			}
+			log.Println("Reloaded JWT signing keys")
+		}
+	}()
+
+	breached, err := password.LoadBreachedList(cfg.BreachedPasswordsFile)
+	if err != nil {
+		log.Fatalf("Failed to load breached password list: %v", err)
+	}
+	policy := &password.Policy{
+		MinLength:  cfg.PasswordMinLength,
+		MinEntropy: cfg.PasswordMinEntropy,
+		History:    cfg.PasswordHistory,
+		Breached:   breached,
+	}
+
+	relyingParty, err := auth.NewRelyingParty(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure passkeys: %v", err)
+	}
+
+	sso, err := auth.NewOIDCProvider(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure single sign-on: %v", err)
+	}
 
 	// 3. Initialize Services
-	authService := auth.NewAuthService(store, cfg)
-	userService := user.NewUserService(store)
+	userService := user.NewUserService(store, store, policy, cfg)
+	authService := auth.NewAuthService(store, store, store, store, store, store, userService, mailer, keys, policy, relyingParty, sso, cfg)
 
 	// 4. Initialize Handlers
 	authHandler := auth.NewAuthHandler(authService)
-	userHandler := user.NewUserHandler(userService)
+	userHandler := user.NewUserHandler(userService, authService)
 
 	// 5. Setup Fiber
 	app := fiber.New(fiber.Config{
-		AppName: "StackleVest Backend",
+		AppName:      "StackleVest Backend",
+		ErrorHandler: middleware.ErrorHandler,
+
+		// c.IP() is the browser's address for requests the Next.js server
+		// forwards, so per-IP login throttling does not lump them together
+		ProxyHeader:             fiber.HeaderXForwardedFor,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.TrustedProxies,
+		EnableIPValidation:      true,
 	})
 
 	// Middleware
-	app.Use(logger.New())
+	app.Use(middleware.RequestID())
+	app.Use(logger.New(logger.Config{
+		Format: "${time} ${locals:request_id} ${status} - ${latency} ${method} ${path}\n",
+	}))
 	app.Use(helmet.New())
 	app.Use(limiter.New(limiter.Config{
 		Max: 100, // Limit to 100 requests per minute
+		LimitReached: func(c *fiber.Ctx) error {
+			return domain.RateLimited("rate_limited", "Too many requests; try again in a minute")
+		},
 	}))
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "http://localhost:3000,http://192.168.0.114:3000", // Allow network IP
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
+		AllowOrigins:  "http://localhost:3000,http://192.168.0.114:3000", // Allow network IP
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, X-Request-ID",
+		ExposeHeaders: "X-Request-ID, Retry-After",
 	}))
 
 	// Auth Middleware
-	authMiddleware := middleware.AuthMiddleware(cfg)
+	authMiddleware := middleware.AuthMiddleware(authService)
 
 	// Health Check
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -58,7 +136,7 @@ func main() {
 	})
 
 	// 6. Routes
-	authHandler.RegisterRoutes(app)
+	authHandler.RegisterRoutes(app, authMiddleware)
 	userHandler.RegisterRoutes(app, authMiddleware)
 
 	log.Printf("Server starting on port %s", cfg.Port)
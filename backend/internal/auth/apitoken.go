@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+const (
+	apiTokenDefaultTTL  = 90 * 24 * time.Hour
+	apiTokenMaxTTL      = 365 * 24 * time.Hour
+	apiTokenNameMaxLen  = 64
+	apiTokenUseInterval = time.Minute // How stale LastUsedAt may get before it is written again
+)
+
+// CreateAPIToken issues a token that acts as ownerID within scopes until
+// ttl has passed (0 for the default of 90 days). creatorID is the owner, or
+// the admin issuing a service key. A bad name, scope or lifetime is a
+// *domain.ValidationError. The secret is returned once and only its hash is
+// kept.
+func (s *AuthService) CreateAPIToken(ownerID, creatorID, name string, scopes []string, ttl time.Duration) (*domain.APIToken, string, error) {
+	owner, err := s.findUser(ownerID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var v domain.Validator
+	name = strings.TrimSpace(name)
+	if v.Required("name", "Name", name) {
+		v.MaxLength("name", "Name", name, apiTokenNameMaxLen)
+	}
+	if len(scopes) == 0 {
+		v.Add("scopes", domain.CodeRequired, "At least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(domain.APITokenScopes, scope) {
+			v.Add("scopes", domain.CodeNotAllowed, fmt.Sprintf("Unknown scope %q; scopes are %s", scope, strings.Join(domain.APITokenScopes, ", ")))
+		}
+	}
+	if ttl < 0 || ttl > apiTokenMaxTTL {
+		v.Add("expiresInDays", domain.CodeNotAllowed, "Tokens expire after 1 to 365 days")
+	}
+	if err := v.Err(); err != nil {
+		return nil, "", err
+	}
+	if slices.Contains(scopes, domain.ScopeAdmin) && !strings.EqualFold(owner.Role, domain.RoleAdmin) {
+		return nil, "", domain.ErrScopeNotAllowed
+	}
+	if ttl == 0 {
+		ttl = apiTokenDefaultTTL
+	}
+
+	selector, err := randomToken(selectorBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	verifier, err := randomToken(verifierBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	token := &domain.APIToken{
+		ID:        domain.GenerateID("tok"),
+		UserID:    owner.ID,
+		Name:      name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		Selector:  selector,
+		TokenHash: hashVerifier(verifier),
+		CreatedBy: creatorID,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := s.tokens.CreateAPIToken(token); err != nil {
+		return nil, "", err
+	}
+
+	s.recordEvent(owner.ID, domain.AuthEventAPITokenCreated, "", fmt.Sprintf("%s (%s) by %s", token.Name, token.ID, creatorID))
+	return token, domain.APITokenPrefix + selector + "." + verifier, nil
+}
+
+// ListAPITokens returns the user's tokens, expired ones included, without
+// their secrets
+func (s *AuthService) ListAPITokens(userID string) ([]domain.APIToken, error) {
+	tokens, err := s.tokens.FindUserAPITokens(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		tokens[i].Sanitize()
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes one of the user's tokens. Tokens belonging to
+// someone else are reported as not found.
+func (s *AuthService) RevokeAPIToken(userID, tokenID string) error {
+	tokens, err := s.tokens.FindUserAPITokens(userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.ID == tokenID {
+			if err := s.tokens.DeleteAPIToken(token.ID); err != nil {
+				return err
+			}
+			s.recordEvent(userID, domain.AuthEventAPITokenRevoked, "", fmt.Sprintf("%s (%s)", token.Name, token.ID))
+			return nil
+		}
+	}
+	return domain.ErrAPITokenNotFound
+}
+
+// ValidateAPIToken resolves an "slv_" bearer token to the token and its
+// owner, noting when and from where it was used
+func (s *AuthService) ValidateAPIToken(tokenString, ip string) (*domain.APIToken, *domain.User, error) {
+	secret, ok := strings.CutPrefix(tokenString, domain.APITokenPrefix)
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+	selector, verifier, ok := splitRefreshToken(secret)
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+
+	token, err := s.tokens.FindAPITokenBySelector(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil || !verifierMatches(token.TokenHash, verifier) {
+		return nil, nil, ErrInvalidToken
+	}
+	now := time.Now()
+	if now.After(token.ExpiresAt) {
+		return nil, nil, ErrInvalidToken
+	}
+	owner, err := s.repo.FindByID(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if owner == nil {
+		return nil, nil, domain.ErrTokenRevoked
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenUseInterval || token.LastUsedIP != ip {
+		token.LastUsedAt = &now
+		token.LastUsedIP = ip
+		if err := s.tokens.UpdateAPIToken(token); err != nil {
+			log.Printf("Failed to record use of API token %s: %v", token.ID, err)
+		}
+	}
+	return token, owner, nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/middleware"
+)
+
+// TestEveryScopeOpensARoute checks that each resource scope a token can be
+// given lets it through the auth middleware to its routes, and no further
+func TestEveryScopeOpensARoute(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	u := &domain.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", Role: domain.RoleStaff, Status: domain.StatusActive}
+	if err := store.Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Get("/api/users", middleware.AuthMiddleware(service), ok)
+	app.Post("/api/users", middleware.AuthMiddleware(service), ok)
+	app.Get("/api/org", middleware.AuthMiddleware(service), ok)
+	app.Get("/api/auth/sessions", middleware.AuthMiddleware(service), ok)
+
+	for _, tc := range []struct {
+		scope string
+		allow map[string]bool // "METHOD path" -> let through
+	}{
+		{domain.ScopeUsersRead, map[string]bool{"GET /api/users": true}},
+		{domain.ScopeUsersWrite, map[string]bool{"GET /api/users": true, "POST /api/users": true}},
+		{domain.ScopeOrgRead, map[string]bool{"GET /api/org": true}},
+	} {
+		_, secret, err := service.CreateAPIToken(u.ID, u.ID, tc.scope, []string{tc.scope}, time.Hour)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.scope, err)
+		}
+		for _, route := range []string{"GET /api/users", "POST /api/users", "GET /api/org", "GET /api/auth/sessions"} {
+			method, path, _ := strings.Cut(route, " ")
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Authorization", "Bearer "+secret)
+			res, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := fiber.StatusForbidden
+			if tc.allow[route] {
+				want = fiber.StatusOK
+			}
+			if res.StatusCode != want {
+				t.Errorf("%s with %s: status %d, want %d", route, tc.scope, res.StatusCode, want)
+			}
+		}
+	}
+}
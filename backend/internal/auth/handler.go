@@ -1,8 +1,19 @@
 package auth
 
 import (
+	"errors"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/dto"
+	"github.com/stacklevest/backend/internal/middleware"
+	"github.com/stacklevest/backend/internal/password"
 )
 
 type AuthHandler struct {
@@ -16,26 +27,28 @@ func NewAuthHandler(service *AuthService) *AuthHandler {
 }
 
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
-	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	var req dto.LoginRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
 	}
 
-	resp, err := h.service.Login(req.Email, req.Password)
+	resp, err := h.service.Login(req.Email, req.Password, req.OTP, clientInfo(c))
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": err.Error(),
-		})
-	}
-
-	if resp.User != nil {
-		resp.User.Sanitize()
+		var locked *AccountLockedError
+		switch {
+		case errors.As(err, &locked):
+			retryAfter := int(math.Ceil(time.Until(locked.Until).Seconds()))
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+			return &domain.Error{
+				Kind:    domain.ErrLocked,
+				Code:    "account_locked",
+				Message: locked.Error(),
+				Details: fiber.Map{"lockedUntil": locked.Until, "retryAfter": retryAfter},
+			}
+		case errors.Is(err, ErrTooManyAttempts):
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(ipWindow.Seconds())))
+		}
+		return err
 	}
 
 	if resp.RefreshToken != "" {
@@ -43,35 +56,45 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		// We keep it in the body so NextAuth can capture it for its own JWT
 	}
 
-	return c.JSON(resp)
+	return c.JSON(loginResponse(resp))
+}
+
+func (h *AuthHandler) RequestOTP(c *fiber.Ctx) error {
+	var req dto.EmailRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	if err := h.service.RequestOTP(req.Email); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "OTP sent",
+	})
 }
 
 func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
 	refreshToken := c.Cookies("refreshToken")
 	if refreshToken == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Missing refresh token",
-		})
+		return domain.Unauthorized("missing_refresh_token", "Missing refresh token")
 	}
 
-	resp, err := h.service.Refresh(refreshToken)
+	resp, err := h.service.Refresh(refreshToken, clientInfo(c))
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return err
 	}
 
 	h.setRefreshTokenCookie(c, resp.RefreshToken)
 	resp.RefreshToken = ""
 
-	return c.JSON(resp)
+	return c.JSON(loginResponse(resp))
 }
 
 func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 	refreshToken := c.Cookies("refreshToken")
-	if refreshToken != "" {
-		h.service.Logout(refreshToken)
-	}
+	accessToken := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	h.service.Logout(refreshToken, accessToken)
 
 	// Clear cookie
 	c.Cookie(&fiber.Cookie{
@@ -88,6 +111,500 @@ func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 	})
 }
 
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	var req dto.ChangePasswordRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	sessionID, _ := c.Locals("session_id").(string)
+	if err := h.service.ChangePassword(userID, sessionID, req.CurrentPassword, req.NewPassword); err != nil {
+		return passwordPolicyError(err, "newPassword")
+	}
+
+	return c.JSON(fiber.Map{"message": "Password updated"})
+}
+
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req dto.EmailRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	if err := h.service.RequestPasswordReset(req.Email); err != nil {
+		return err
+	}
+
+	// Same answer whether or not the account exists
+	return c.JSON(fiber.Map{"message": "If an account exists for that email, a reset link has been sent"})
+}
+
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req dto.ResetPasswordRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	if err := h.service.ResetPassword(req.Token, req.NewPassword); err != nil {
+		return passwordPolicyError(err, "newPassword")
+	}
+
+	return c.JSON(fiber.Map{"message": "Password has been reset, please log in"})
+}
+
+// VerifySecondFactor completes a login left pending by Login with a TOTP
+// or recovery code
+func (h *AuthHandler) VerifySecondFactor(c *fiber.Ctx) error {
+	var req dto.ChallengeCodeRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	resp, err := h.service.VerifySecondFactor(req.Token, req.Code)
+	if err != nil {
+		return err
+	}
+
+	h.setRefreshTokenCookie(c, resp.RefreshToken)
+	return c.JSON(loginResponse(resp))
+}
+
+// EnrollChallengeTOTP starts enrolment for a login whose role requires 2FA
+func (h *AuthHandler) EnrollChallengeTOTP(c *fiber.Ctx) error {
+	var req dto.ChallengeRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	enrollment, err := h.service.EnrollChallengeTOTP(req.Token)
+	if err != nil {
+		return err
+	}
+	return c.JSON(enrollment)
+}
+
+func (h *AuthHandler) ConfirmChallengeTOTP(c *fiber.Ctx) error {
+	var req dto.ChallengeCodeRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	codes, err := h.service.ConfirmChallengeTOTP(req.Token, req.Code)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+func (h *AuthHandler) EnrollTOTP(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	enrollment, err := h.service.EnrollTOTP(userID)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	return c.JSON(enrollment)
+}
+
+func (h *AuthHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	var req dto.CodeRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	codes, err := h.service.ConfirmTOTP(userID, req.Code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+func (h *AuthHandler) DisableTOTP(c *fiber.Ctx) error {
+	var req dto.CodeRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	if err := h.service.DisableTOTP(userID, req.Code); err != nil {
+		return twoFactorError(err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var req dto.CodeRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	codes, err := h.service.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+func (h *AuthHandler) BeginPasskeyLogin(c *fiber.Ctx) error {
+	ceremony, err := h.service.BeginPasskeyLogin()
+	if err != nil {
+		return err
+	}
+	return c.JSON(ceremony)
+}
+
+// FinishPasskeyLogin takes the credential from navigator.credentials.get()
+// and answers like Login
+func (h *AuthHandler) FinishPasskeyLogin(c *fiber.Ctx) error {
+	var req dto.PasskeyRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	resp, err := h.service.FinishPasskeyLogin(req.Token, req.Credential, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	if resp.RefreshToken != "" {
+		h.setRefreshTokenCookie(c, resp.RefreshToken)
+	}
+	return c.JSON(loginResponse(resp))
+}
+
+func (h *AuthHandler) ListPasskeys(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	passkeys, err := h.service.ListPasskeys(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewPasskeyResponses(passkeys))
+}
+
+func (h *AuthHandler) BeginPasskeyRegistration(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	ceremony, err := h.service.BeginPasskeyRegistration(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(ceremony)
+}
+
+// FinishPasskeyRegistration takes the credential from
+// navigator.credentials.create() and a name for it
+func (h *AuthHandler) FinishPasskeyRegistration(c *fiber.Ctx) error {
+	var req dto.PasskeyRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	passkey, err := h.service.FinishPasskeyRegistration(userID, req.Token, req.Name, req.Credential)
+	if err != nil {
+		// The caller is signed in; a credential that does not verify is a
+		// bad request, not a failed login
+		return domain.WithKind(err, domain.ErrValidation)
+	}
+	return c.Status(fiber.StatusCreated).JSON(dto.NewPasskeyResponse(passkey))
+}
+
+func (h *AuthHandler) DeletePasskey(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	if err := h.service.DeletePasskey(userID, c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) ListAPITokens(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	tokens, err := h.service.ListAPITokens(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewAPITokenResponses(tokens))
+}
+
+// CreateAPIToken issues a personal access token for the caller. The secret
+// is in the response only; it cannot be shown again.
+func (h *AuthHandler) CreateAPIToken(c *fiber.Ctx) error {
+	var req dto.APITokenRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	token, secret, err := h.service.CreateAPIToken(userID, userID, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(dto.CreatedAPIToken{Token: secret, APIToken: dto.NewAPITokenResponse(token)})
+}
+
+func (h *AuthHandler) RevokeAPIToken(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	if err := h.service.RevokeAPIToken(userID, c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// CreateInvitation creates a pending account from the profile in the body
+// and emails the new hire a link to finish it
+func (h *AuthHandler) CreateInvitation(c *fiber.Ctx) error {
+	var req dto.InvitationRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	adminID, _ := c.Locals("user_id").(string)
+	invitation, err := h.service.InviteUser(adminID, req.ToDomain())
+	if err != nil && !errors.Is(err, ErrInvitationNotSent) {
+		return err
+	}
+	resp := dto.NewInvitationResponse(invitation)
+	if err != nil {
+		return &domain.Error{
+			Kind:    ErrInvitationNotSent.Kind,
+			Code:    ErrInvitationNotSent.Code,
+			Message: ErrInvitationNotSent.Message,
+			Details: fiber.Map{"invitation": resp},
+		}
+	}
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+func (h *AuthHandler) ListInvitations(c *fiber.Ctx) error {
+	invitations, err := h.service.ListInvitations()
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewInvitationResponses(invitations))
+}
+
+func (h *AuthHandler) ResendInvitation(c *fiber.Ctx) error {
+	adminID, _ := c.Locals("user_id").(string)
+	invitation, err := h.service.ResendInvitation(c.Params("id"), adminID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewInvitationResponse(invitation))
+}
+
+func (h *AuthHandler) RevokeInvitation(c *fiber.Ctx) error {
+	if err := h.service.RevokeInvitation(c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// LookupInvitation returns the profile behind an invitation link for the
+// acceptance form to prefill
+func (h *AuthHandler) LookupInvitation(c *fiber.Ctx) error {
+	user, err := h.service.LookupInvitation(c.Query("token"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewInvitedUser(user))
+}
+
+func (h *AuthHandler) AcceptInvitation(c *fiber.Ctx) error {
+	var req dto.AcceptInvitationRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	acceptance := InvitationAcceptance{Password: req.Password, Name: req.Name, JobTitle: req.JobTitle, Avatar: req.Avatar}
+	if err := h.service.AcceptInvitation(req.Token, acceptance); err != nil {
+		return passwordPolicyError(err, "password")
+	}
+	return c.JSON(fiber.Map{"message": "Your account is ready, please log in"})
+}
+
+// OIDCLogin redirects the browser to the identity provider. The OAuth state
+// is also set in a cookie so the callback only completes in the browser
+// that started it.
+func (h *AuthHandler) OIDCLogin(c *fiber.Ctx) error {
+	state, authURL, err := h.service.BeginOIDCLogin()
+	if err != nil {
+		return err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		Expires:  time.Now().Add(ssoFlowTTL),
+		HTTPOnly: true,
+		Secure:   false, // Should be true in production
+		SameSite: "Lax",
+	})
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallback is where the identity provider sends the browser back to. It
+// always redirects to the frontend login page: with ?sso=<token> (and
+// &secondFactor=... when a TOTP code is still owed) to finish through
+// POST /api/auth/2fa/verify, or with ?ssoError=<code>. A link flow goes
+// back to the settings page instead, with ?ssoLink=<token> to confirm
+// through POST /api/auth/oidc/link/confirm.
+func (h *AuthHandler) OIDCCallback(c *fiber.Ctx) error {
+	state := c.Query("state")
+	cookieState := c.Cookies(oidcStateCookie)
+	c.ClearCookie(oidcStateCookie)
+
+	// A link flow is started by an API call, so it has no cookie; the
+	// confirmation binds it to the signed-in user instead
+	page := h.service.LoginPageURL
+	linking := IsSSOLinkState(state)
+	if linking {
+		page = h.service.SettingsPageURL
+	}
+	fail := func(code string) error {
+		return c.Redirect(page(url.Values{"ssoError": {code}}), fiber.StatusFound)
+	}
+	if providerErr := c.Query("error"); providerErr != "" {
+		log.Printf("Identity provider refused single sign-on: %s %s", providerErr, c.Query("error_description"))
+		return fail("sso_failed")
+	}
+	if state == "" || (state != cookieState && !linking) {
+		return fail("expired")
+	}
+
+	handoff, err := h.service.FinishOIDCLogin(c.UserContext(), state, c.Query("code"), clientInfo(c))
+	switch {
+	case errors.Is(err, ErrInvalidSSOState):
+		return fail("expired")
+	case errors.Is(err, ErrSSOEmailUnverified):
+		return fail("email_unverified")
+	case errors.Is(err, ErrSSOAccountConflict):
+		return fail("account_conflict")
+	case errors.Is(err, ErrSSOLinkRequired):
+		return fail("link_required")
+	case err != nil:
+		log.Printf("Single sign-on failed: %v", err)
+		return fail("sso_failed")
+	}
+
+	if handoff.Link {
+		return c.Redirect(page(url.Values{"ssoLink": {handoff.Token}}), fiber.StatusFound)
+	}
+	query := url.Values{"sso": {handoff.Token}}
+	if handoff.SecondFactor != "" {
+		query.Set("secondFactor", handoff.SecondFactor)
+	}
+	return c.Redirect(page(query), fiber.StatusFound)
+}
+
+// BeginOIDCLink returns the identity provider URL the signed-in user's
+// browser must visit to link their account to it
+func (h *AuthHandler) BeginOIDCLink(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	authURL, err := h.service.BeginOIDCLink(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"url": authURL})
+}
+
+// ConfirmOIDCLink completes a link with the token the callback put in the
+// settings page URL
+func (h *AuthHandler) ConfirmOIDCLink(c *fiber.Ctx) error {
+	var req dto.ChallengeRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+	userID, _ := c.Locals("user_id").(string)
+	if err := h.service.ConfirmOIDCLink(userID, req.Token); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListSessions returns the caller's active sessions, flagging the one the
+// current access token belongs to
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	sessions, err := h.service.ListSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	currentID, _ := c.Locals("session_id").(string)
+	return c.JSON(dto.NewSessionResponses(sessions, currentID))
+}
+
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	if err := h.service.RevokeSession(userID, c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) RevokeAllSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	if err := h.service.RevokeAllSessions(userID); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// JWKS publishes the token verification keys so other services can check
+// access tokens without holding anything that can sign them
+func (h *AuthHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(h.service.JWKS())
+}
+
+// loginResponse maps a login outcome to its response body
+func loginResponse(resp *LoginResponse) dto.LoginResponse {
+	body := dto.LoginResponse{
+		AccessToken:           resp.AccessToken,
+		RefreshToken:          resp.RefreshToken,
+		RequiresOTP:           resp.RequiresOTP,
+		RequiresPasswordReset: resp.RequiresPasswordReset,
+		ResetToken:            resp.ResetToken,
+		RequiresSecondFactor:  resp.RequiresSecondFactor,
+		SecondFactor:          resp.SecondFactor,
+		SecondFactorToken:     resp.SecondFactorToken,
+	}
+	if resp.User != nil {
+		body.User = dto.NewUserResponse(resp.User)
+	}
+	return body
+}
+
+// twoFactorError answers a wrong code from a signed-in user with 403 rather
+// than the 401 of a failed login, as their session is still good
+func twoFactorError(err error) error {
+	if errors.Is(err, ErrTOTPInvalid) {
+		return domain.WithKind(err, domain.ErrForbidden)
+	}
+	return err
+}
+
+// passwordPolicyError reports a password the policy rejected as field
+// errors against the named request field; other errors pass through
+func passwordPolicyError(err error, field string) error {
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return &domain.ValidationError{Fields: policyErr.Fields(field)}
+	}
+	return err
+}
+
+const oidcStateCookie = "oidcState"
+
+func clientInfo(c *fiber.Ctx) ClientInfo {
+	return ClientInfo{
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
+
 func (h *AuthHandler) setRefreshTokenCookie(c *fiber.Ctx, token string) {
 	c.Cookie(&fiber.Cookie{
 		Name:     "refreshToken",
@@ -99,8 +616,63 @@ func (h *AuthHandler) setRefreshTokenCookie(c *fiber.Ctx, token string) {
 	})
 }
 
-func (h *AuthHandler) RegisterRoutes(app *fiber.App) {
+func (h *AuthHandler) RegisterRoutes(app *fiber.App, authMiddleware fiber.Handler) {
 	app.Post("/api/login", h.Login)
+	app.Post("/api/auth/otp/request", h.RequestOTP)
 	app.Post("/api/auth/refresh", h.Refresh)
 	app.Post("/api/auth/logout", h.Logout)
+	app.Post("/api/auth/change-password", authMiddleware, h.ChangePassword)
+	app.Post("/api/auth/forgot-password", h.ForgotPassword)
+	app.Post("/api/auth/reset-password", h.ResetPassword)
+	app.Get("/.well-known/jwks.json", h.JWKS)
+
+	// Second step of a login that needs TOTP
+	app.Post("/api/auth/2fa/verify", h.VerifySecondFactor)
+	app.Post("/api/auth/2fa/challenge/enroll", h.EnrollChallengeTOTP)
+	app.Post("/api/auth/2fa/challenge/confirm", h.ConfirmChallengeTOTP)
+
+	// TOTP settings for the signed-in user
+	twoFactor := app.Group("/api/auth/2fa", authMiddleware)
+	twoFactor.Post("/totp/enroll", h.EnrollTOTP)
+	twoFactor.Post("/totp/confirm", h.ConfirmTOTP)
+	twoFactor.Delete("/totp", h.DisableTOTP)
+	twoFactor.Post("/recovery-codes", h.RegenerateRecoveryCodes)
+
+	// Single sign-on; the browser navigates to both
+	app.Get("/api/auth/oidc/login", h.OIDCLogin)
+	app.Get("/api/auth/oidc/callback", h.OIDCCallback)
+
+	// Linking the signed-in user's account to the identity provider
+	app.Post("/api/auth/oidc/link", authMiddleware, h.BeginOIDCLink)
+	app.Post("/api/auth/oidc/link/confirm", authMiddleware, h.ConfirmOIDCLink)
+
+	// Passkeys. The login routes are public; the rest manage the caller's
+	// own passkeys.
+	app.Post("/api/auth/passkeys/login/begin", h.BeginPasskeyLogin)
+	app.Post("/api/auth/passkeys/login/finish", h.FinishPasskeyLogin)
+	app.Get("/api/auth/passkeys", authMiddleware, h.ListPasskeys)
+	app.Post("/api/auth/passkeys/register/begin", authMiddleware, h.BeginPasskeyRegistration)
+	app.Post("/api/auth/passkeys/register/finish", authMiddleware, h.FinishPasskeyRegistration)
+	app.Delete("/api/auth/passkeys/:id", authMiddleware, h.DeletePasskey)
+
+	// The caller's personal access tokens. API tokens cannot reach these
+	// routes, so a token cannot mint another.
+	app.Get("/api/auth/tokens", authMiddleware, h.ListAPITokens)
+	app.Post("/api/auth/tokens", authMiddleware, h.CreateAPIToken)
+	app.Delete("/api/auth/tokens/:id", authMiddleware, h.RevokeAPIToken)
+
+	// Invitations. Accepting is public, with the emailed token; the rest is
+	// for admins.
+	app.Get("/api/invitations/accept", h.LookupInvitation)
+	app.Post("/api/invitations/accept", h.AcceptInvitation)
+	app.Get("/api/invitations", authMiddleware, middleware.AdminGuard, h.ListInvitations)
+	app.Post("/api/invitations", authMiddleware, middleware.AdminGuard, h.CreateInvitation)
+	app.Post("/api/invitations/:id/resend", authMiddleware, middleware.AdminGuard, h.ResendInvitation)
+	app.Delete("/api/invitations/:id", authMiddleware, middleware.AdminGuard, h.RevokeInvitation)
+
+	// Session management for the signed-in user
+	sessions := app.Group("/api/auth/sessions", authMiddleware)
+	sessions.Get("/", h.ListSessions)
+	sessions.Delete("/", h.RevokeAllSessions)
+	sessions.Delete("/:id", h.RevokeSession)
 }
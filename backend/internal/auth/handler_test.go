@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/dto"
+	"github.com/stacklevest/backend/internal/mail"
+	"github.com/stacklevest/backend/internal/middleware"
+	"github.com/stacklevest/backend/internal/password"
+	"github.com/stacklevest/backend/internal/storage"
+)
+
+// recordingMailer keeps every message instead of sending it
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// storedSecrets returns every secret the store holds that a response must
+// never echo: password, refresh, API and invitation token hashes, TOTP
+// secrets, recovery code hashes and passkey public keys
+func storedSecrets(t *testing.T, store *storage.SQLiteStore) []string {
+	t.Helper()
+	users, err := store.FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secrets []string
+	for _, u := range users {
+		secrets = append(secrets, u.Password, u.TOTPSecret)
+		secrets = append(secrets, u.PasswordHistory...)
+		secrets = append(secrets, u.RecoveryCodes...)
+
+		tokens, err := store.FindUserAPITokens(u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range tokens {
+			secrets = append(secrets, token.TokenHash)
+		}
+		passkeys, err := store.FindUserPasskeys(u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range passkeys {
+			secrets = append(secrets, base64.StdEncoding.EncodeToString(p.PublicKey), b64.EncodeToString(p.PublicKey))
+		}
+	}
+	sessions, err := store.FindAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sess := range sessions {
+		secrets = append(secrets, sess.RefreshToken)
+	}
+	invitations, err := store.FindAllInvitations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inv := range invitations {
+		secrets = append(secrets, inv.TokenHash)
+	}
+	return secrets
+}
+
+// TestAuthResponsesNeverIncludeSecrets goes through every way of signing
+// in (password, refresh, TOTP and passkey), the account routes and the
+// invitation lifecycle, and fails if a response carries stored secret
+// material
+func TestAuthResponsesNeverIncludeSecrets(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	mailer := &recordingMailer{}
+	service.mailer = mailer
+
+	const passphrase = "correct horse battery staple"
+	hash, err := password.Hash(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totpSecret := []byte("12345678901234567890")
+	users := []*domain.User{
+		{ID: "user-admin", Name: "Ada", Email: "ada@example.com", Role: domain.RoleAdmin},
+		{ID: "user-totp", Name: "Grace", Email: "grace@example.com", Role: domain.RoleStaff,
+			TOTPSecret: base32NoPad.EncodeToString(totpSecret), TOTPEnabled: true, RecoveryCodes: []string{hashVerifier("abcde-fghij")}},
+	}
+	for _, u := range users {
+		u.Password, u.PasswordHistory = hash, []string{hash}
+		u.Status, u.CreatedAt = domain.StatusActive, time.Now()
+		if err := store.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	authenticator := newSoftAuthenticator(t)
+	ceremony, err := service.BeginPasskeyRegistration("user-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishPasskeyRegistration("user-admin", ceremony.Token, "Laptop", authenticator.Create(t, ceremony.Options)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests act as the user named in X-Test-User
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	NewAuthHandler(service).RegisterRoutes(app, func(c *fiber.Ctx) error {
+		user, err := store.FindByID(c.Get("X-Test-User"))
+		if err != nil || user == nil {
+			return domain.Unauthorized("unauthenticated", "no test user")
+		}
+		c.Locals("user_id", user.ID)
+		c.Locals("role", user.Role)
+		return c.Next()
+	})
+
+	var bodies []string
+	call := func(method, path, userID string, body any, cookies ...*http.Cookie) (*http.Response, []byte) {
+		t.Helper()
+		var reader io.Reader
+		if body != nil {
+			reader = strings.NewReader(string(mustJSON(t, body)))
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set("X-Test-User", userID)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode >= 400 {
+			t.Fatalf("%s %s: status %d: %s", method, path, res.StatusCode, data)
+		}
+		bodies = append(bodies, method+" "+path+": "+string(data))
+		return res, data
+	}
+	decode := func(data []byte, v any) {
+		t.Helper()
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+	}
+
+	// Password login, then refresh with the cookie it set
+	res, _ := call(fiber.MethodPost, "/api/login", "", fiber.Map{"email": "ada@example.com", "password": passphrase})
+	var refreshCookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == "refreshToken" {
+			refreshCookie = c
+		}
+	}
+	if refreshCookie == nil {
+		t.Fatal("login set no refresh token cookie")
+	}
+	call(fiber.MethodPost, "/api/auth/refresh", "", nil, refreshCookie)
+
+	// Password and TOTP
+	var challenge dto.LoginResponse
+	_, data := call(fiber.MethodPost, "/api/login", "", fiber.Map{"email": "grace@example.com", "password": passphrase})
+	decode(data, &challenge)
+	code := totpCode(totpSecret, time.Now().Unix()/totpPeriod)
+	call(fiber.MethodPost, "/api/auth/2fa/verify", "", fiber.Map{"token": challenge.SecondFactorToken, "code": code})
+
+	// Passkey
+	var passkeyLogin struct {
+		Token   string                       `json:"token"`
+		Options protocol.CredentialAssertion `json:"options"`
+	}
+	_, data = call(fiber.MethodPost, "/api/auth/passkeys/login/begin", "", nil)
+	decode(data, &passkeyLogin)
+	authenticator.counter++
+	call(fiber.MethodPost, "/api/auth/passkeys/login/finish", "", fiber.Map{
+		"token":      passkeyLogin.Token,
+		"credential": json.RawMessage(authenticator.Get(t, &passkeyLogin.Options)),
+	})
+
+	// The caller's account
+	call(fiber.MethodPost, "/api/auth/tokens", "user-admin", fiber.Map{"name": "CI", "scopes": []string{"users:read", "org:read"}})
+	for _, path := range []string{"/api/auth/sessions", "/api/auth/passkeys", "/api/auth/tokens"} {
+		call(fiber.MethodGet, path, "user-admin", nil)
+	}
+
+	// Invitations, up to the last step, which burns the token
+	var invitation dto.InvitationResponse
+	_, data = call(fiber.MethodPost, "/api/invitations", "user-admin", fiber.Map{"name": "Cy", "email": "cy@example.com"})
+	decode(data, &invitation)
+	call(fiber.MethodGet, "/api/invitations", "user-admin", nil)
+	call(fiber.MethodPost, "/api/invitations/"+invitation.ID+"/resend", "user-admin", nil)
+	link := mailer.sent[len(mailer.sent)-1].Text
+	token := link[strings.Index(link, "token=")+len("token="):]
+	token, err = url.QueryUnescape(strings.Fields(token)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	call(fiber.MethodGet, "/api/invitations/accept?token="+url.QueryEscape(token), "", nil)
+	secrets := storedSecrets(t, store)
+	call(fiber.MethodPost, "/api/invitations/accept", "", fiber.Map{"token": token, "password": "a passphrase of my own"})
+	secrets = append(secrets, storedSecrets(t, store)...)
+
+	markers := []string{"$2a$", `"passwordHistory"`, `"totpSecret"`, `"totpLastStep"`, `"recoveryCodes"`, `"tokenHash"`, `"selector"`}
+	for _, body := range bodies {
+		for _, secret := range append(markers, secrets...) {
+			if secret != "" && strings.Contains(body, secret) {
+				t.Errorf("response contains %s: %s", secret, body)
+			}
+		}
+	}
+}
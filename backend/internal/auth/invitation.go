@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"html"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/mail"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidInvitation = domain.Invalid("invalid_invitation", "invalid or expired invitation")
+	ErrInvitationNotSent = domain.Upstream("invitation_not_sent", "the invitation was saved but its email could not be sent; try resending it")
+)
+
+// InvitationAcceptance is what the new hire fills in to accept: their
+// password and the parts of the profile that are theirs to complete
+type InvitationAcceptance struct {
+	Password string
+	Name     string
+	JobTitle string
+	Avatar   string
+}
+
+// InviteUser creates invitee as a pending account with no password and
+// emails them a link to set one up. The profile is checked as for any new
+// user, bad fields being reported as a *domain.ValidationError, and the
+// staff number is assigned. The invitation is kept even if the email
+// fails, so it can be resent.
+func (s *AuthService) InviteUser(inviterID string, invitee *domain.User) (*domain.Invitation, error) {
+	invitee.NeedsOnboarding = true
+	invitee.Status = domain.StatusPending
+	if invitee.Role == "" {
+		invitee.Role = domain.RoleStaff
+	}
+	if err := s.users.Provision(invitee); err != nil {
+		return nil, err
+	}
+
+	invitation := &domain.Invitation{
+		ID:        domain.GenerateID("inv"),
+		UserID:    invitee.ID,
+		Email:     invitee.Email,
+		CreatedAt: invitee.CreatedAt,
+	}
+	token, err := s.issueInvitationToken(invitation, inviterID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.invites.CreateInvitation(invitation); err != nil {
+		return nil, err
+	}
+
+	s.recordEvent(invitee.ID, domain.AuthEventInvitationSent, "", "by "+inviterID)
+	return invitation, s.sendInvitation(invitee, token)
+}
+
+// ListInvitations returns the invitations not yet accepted, oldest first,
+// expired ones included
+func (s *AuthService) ListInvitations() ([]domain.Invitation, error) {
+	invitations, err := s.invites.FindAllInvitations()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].CreatedAt.Before(invitations[j].CreatedAt)
+	})
+	for i := range invitations {
+		invitations[i].Sanitize()
+	}
+	return invitations, nil
+}
+
+// ResendInvitation emails a fresh link, which invalidates the previous one
+// and restarts the expiry
+func (s *AuthService) ResendInvitation(invitationID, inviterID string) (*domain.Invitation, error) {
+	invitation, err := s.invites.FindInvitationByID(invitationID)
+	if err != nil {
+		return nil, err
+	}
+	if invitation == nil {
+		return nil, domain.ErrInvitationNotFound
+	}
+	user, err := s.findUser(invitation.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.issueInvitationToken(invitation, inviterID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.invites.UpdateInvitation(invitation); err != nil {
+		return nil, err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventInvitationSent, "", "resent by "+inviterID)
+	return invitation, s.sendInvitation(user, token)
+}
+
+// RevokeInvitation withdraws an invitation and deletes the pending account
+// it was for
+func (s *AuthService) RevokeInvitation(invitationID string) error {
+	invitation, err := s.invites.FindInvitationByID(invitationID)
+	if err != nil {
+		return err
+	}
+	if invitation == nil {
+		return domain.ErrInvitationNotFound
+	}
+
+	if err := s.invites.DeleteInvitation(invitation.ID); err != nil {
+		return err
+	}
+	user, err := s.repo.FindByID(invitation.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil || !user.NeedsOnboarding {
+		return nil
+	}
+	return s.repo.Delete(user.ID)
+}
+
+// LookupInvitation returns the account an invitation link is for, so the
+// acceptance form can show what the admin already filled in
+func (s *AuthService) LookupInvitation(token string) (*domain.User, error) {
+	_, user, err := s.findInvitation(token)
+	return user, err
+}
+
+// AcceptInvitation sets the new hire's password and profile and completes
+// their onboarding. The link works once; a password the policy rejects
+// leaves it usable for another try.
+func (s *AuthService) AcceptInvitation(token string, acceptance InvitationAcceptance) error {
+	invitation, user, err := s.findInvitation(token)
+	if err != nil {
+		return err
+	}
+
+	if name := strings.TrimSpace(acceptance.Name); name != "" {
+		user.Name = name
+	}
+	if user.Name == "" {
+		var v domain.Validator
+		v.Required("name", "Name", user.Name)
+		return v.Err()
+	}
+	if jobTitle := strings.TrimSpace(acceptance.JobTitle); jobTitle != "" {
+		user.JobTitle = jobTitle
+	}
+	if acceptance.Avatar != "" {
+		user.Avatar = acceptance.Avatar
+	}
+	if err := s.policy.Set(user, acceptance.Password); err != nil {
+		return err
+	}
+	user.NeedsOnboarding = false
+	user.Status = domain.StatusActive
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	// Once onboarded the link no longer resolves, so a failure here only
+	// leaves a stale entry in the pending list
+	if err := s.invites.DeleteInvitation(invitation.ID); err != nil {
+		log.Printf("Failed to delete accepted invitation %s: %v", invitation.ID, err)
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventInvitationAccepted, "", "")
+	return nil
+}
+
+// findInvitation resolves an invitation link's token to the invitation and
+// the pending user it is for
+func (s *AuthService) findInvitation(token string) (*domain.Invitation, *domain.User, error) {
+	selector, verifier, ok := splitRefreshToken(token)
+	if !ok {
+		return nil, nil, ErrInvalidInvitation
+	}
+	invitation, err := s.invites.FindInvitationBySelector(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	if invitation == nil || !verifierMatches(invitation.TokenHash, verifier) || time.Now().After(invitation.ExpiresAt) {
+		return nil, nil, ErrInvalidInvitation
+	}
+	user, err := s.repo.FindByID(invitation.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || !user.NeedsOnboarding {
+		return nil, nil, ErrInvalidInvitation
+	}
+	return invitation, user, nil
+}
+
+// issueInvitationToken gives invitation a new token and expiry, returning
+// the "<selector>.<verifier>" token for the link
+func (s *AuthService) issueInvitationToken(invitation *domain.Invitation, inviterID string) (string, error) {
+	selector, err := randomToken(selectorBytes)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomToken(verifierBytes)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	invitation.Selector = selector
+	invitation.TokenHash = hashVerifier(verifier)
+	invitation.InvitedBy = inviterID
+	invitation.SentAt = now
+	invitation.ExpiresAt = now.Add(invitationTTL)
+	return selector + "." + verifier, nil
+}
+
+func (s *AuthService) sendInvitation(user *domain.User, token string) error {
+	link := strings.TrimRight(s.config.AppURL, "/") + "/accept-invitation?token=" + url.QueryEscape(token)
+	greeting := "Hi,"
+	if user.Name != "" {
+		greeting = "Hi " + user.Name + ","
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "You're invited to StackleVest",
+		Text: greeting + "\n\nAn account has been created for you on StackleVest.\n\n" +
+			"Open this link to choose your password and finish setting it up:\n" + link + "\n\n" +
+			"The link expires in 7 days and can only be used once.",
+		HTML: `<div style="font-family: sans-serif; max-width: 600px; margin: 0 auto;">
+	<h2>Welcome to StackleVest</h2>
+	<p>` + html.EscapeString(greeting) + `</p>
+	<p>An account has been created for you on StackleVest.</p>
+	<p><a href="` + link + `" style="display: inline-block; background: #1d4ed8; color: #fff; padding: 12px 20px; border-radius: 8px; text-decoration: none;">Set up your account</a></p>
+	<p>The link expires in 7 days and can only be used once.</p>
+</div>`,
+	}
+
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("Failed to send invitation email to %s: %v", user.Email, err)
+		return ErrInvitationNotSent
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// TestInviteUserProvisionsLikeCreate checks that an invited user gets the
+// same checks and staff number as one an admin creates
+func TestInviteUserProvisionsLikeCreate(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+
+	invitation, err := service.InviteUser("admin-1", &domain.User{Name: "Grace", Email: " grace@example.com ", Department: "Engineering"})
+	if err != nil {
+		t.Fatalf("InviteUser: %v", err)
+	}
+	user, err := store.FindByID(invitation.UserID)
+	if err != nil || user == nil {
+		t.Fatalf("invited user not stored: %v", err)
+	}
+	if user.Email != "grace@example.com" || user.Status != domain.StatusPending || user.Role != domain.RoleStaff ||
+		!user.NeedsOnboarding || user.StaffNumber != "SLV-0001" || user.Password != "" {
+		t.Fatalf("unexpected invited user %+v", user)
+	}
+
+	if _, err := service.InviteUser("admin-1", &domain.User{Name: "Grace", Email: "GRACE@example.com"}); !errors.Is(err, domain.ErrEmailTaken) {
+		t.Fatalf("inviting a taken email: got %v, want ErrEmailTaken", err)
+	}
+
+	_, err = service.InviteUser("admin-1", &domain.User{Email: "not-an-email", Role: "owner", ReportingManager: "user-missing"})
+	var verr *domain.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("bad invitee: got %v, want a ValidationError", err)
+	}
+	rejected := map[string]bool{}
+	for _, f := range verr.Fields {
+		rejected[f.Field] = true
+	}
+	for _, field := range []string{"name", "email", "role", "reportingManager"} {
+		if !rejected[field] {
+			t.Errorf("no error for %s in %v", field, verr)
+		}
+	}
+}
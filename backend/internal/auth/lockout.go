@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+const (
+	// Per-account: the first freeLoginFailures wrong passwords cost nothing;
+	// each one after that locks the account for twice as long as the last,
+	// starting at lockoutBase and capped at lockoutMax.
+	freeLoginFailures = 5
+	lockoutBase       = 30 * time.Second
+	lockoutMax        = 30 * time.Minute
+
+	// Per-IP: at most ipMaxFailures failed logins per ipWindow, across all
+	// accounts, before the address is refused outright
+	ipMaxFailures = 30
+	ipWindow      = 15 * time.Minute
+)
+
+var ErrTooManyAttempts = domain.RateLimited("too_many_attempts", "too many login attempts, try again later")
+
+// AccountLockedError is returned while an account is in lockout
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("account temporarily locked until %s", e.Until.UTC().Format(time.RFC3339))
+}
+
+// lockoutDuration is the lock applied after the given number of
+// consecutive failures
+func lockoutDuration(failures int) time.Duration {
+	if failures <= freeLoginFailures {
+		return 0
+	}
+	d := lockoutBase
+	for i := freeLoginFailures + 1; i < failures && d < lockoutMax; i++ {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
+
+// recordLoginFailure bumps the user's failure counter and, past the free
+// allowance, locks the account. It returns the lock error if one was applied.
+func (s *AuthService) recordLoginFailure(user *domain.User) error {
+	now := time.Now()
+	user.FailedLoginAttempts++
+
+	var lockErr error
+	if d := lockoutDuration(user.FailedLoginAttempts); d > 0 {
+		until := now.Add(d)
+		user.LockedUntil = &until
+		lockErr = &AccountLockedError{Until: until}
+
+		s.recordEvent(user.ID, domain.AuthEventAccountLocked, "",
+			fmt.Sprintf("%d consecutive failed logins; locked for %s", user.FailedLoginAttempts, d))
+	}
+
+	if err := s.repo.Update(user); err != nil {
+		log.Printf("Failed to record login failure for user %s: %v", user.ID, err)
+	}
+	return lockErr
+}
+
+// ipThrottle counts failed logins per client address in fixed windows
+type ipThrottle struct {
+	mu       sync.Mutex
+	failures map[string]*ipWindowCount
+}
+
+type ipWindowCount struct {
+	count int
+	start time.Time
+}
+
+func newIPThrottle() *ipThrottle {
+	return &ipThrottle{failures: map[string]*ipWindowCount{}}
+}
+
+// Blocked reports whether ip has used up its failures for the current window
+func (t *ipThrottle) Blocked(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.failures[ip]
+	if !ok {
+		return false
+	}
+	if time.Since(w.start) > ipWindow {
+		delete(t.failures, ip)
+		return false
+	}
+	return w.count >= ipMaxFailures
+}
+
+func (t *ipThrottle) Fail(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for key, w := range t.failures {
+		if now.Sub(w.start) > ipWindow {
+			delete(t.failures, key)
+		}
+	}
+
+	w, ok := t.failures[ip]
+	if !ok {
+		w = &ipWindowCount{start: now}
+		t.failures[ip] = w
+	}
+	w.count++
+}
+
+// UnlockAccount clears the user's lockout and failure counter
+func (s *AuthService) UnlockAccount(userID string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return domain.ErrUserNotFound
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventAccountUnlocked, "", "")
+	return nil
+}
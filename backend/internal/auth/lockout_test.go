@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/middleware"
+	"github.com/stacklevest/backend/internal/password"
+	"github.com/stacklevest/backend/internal/user"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:   0,
+		5:   0,
+		6:   30 * time.Second,
+		7:   time.Minute,
+		8:   2 * time.Minute,
+		11:  16 * time.Minute,
+		12:  30 * time.Minute, // 32 minutes, capped
+		100: 30 * time.Minute,
+	} {
+		if got := lockoutDuration(failures); got != want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+// TestLoginLocksAccount fails a login through the API until the account
+// locks, checks the locked response, and has an admin unlock it
+func TestLoginLocksAccount(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	const passphrase = "correct horse battery staple"
+	hash, err := password.Hash(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*domain.User{
+		{ID: "user-1", Name: "Ada", Email: "ada@example.com", Password: hash, Role: domain.RoleStaff},
+		{ID: "user-admin", Name: "Grace", Email: "grace@example.com", Password: hash, Role: domain.RoleAdmin},
+	} {
+		u.Status, u.CreatedAt = domain.StatusActive, time.Now()
+		if err := store.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Requests act as the user named in X-Test-User
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	authenticate := func(c *fiber.Ctx) error {
+		u, err := store.FindByID(c.Get("X-Test-User"))
+		if err != nil || u == nil {
+			return domain.Unauthorized("unauthenticated", "no test user")
+		}
+		c.Locals("user_id", u.ID)
+		c.Locals("role", u.Role)
+		return c.Next()
+	}
+	NewAuthHandler(service).RegisterRoutes(app, authenticate)
+	users := user.NewUserService(store, store, &password.Policy{}, testConfig())
+	user.NewUserHandler(users, service).RegisterRoutes(app, authenticate)
+
+	login := func(pw string) (int, map[string]any, string) {
+		t.Helper()
+		body := fmt.Sprintf(`{"email": "ada@example.com", "password": %q}`, pw)
+		req := httptest.NewRequest(fiber.MethodPost, "/api/login", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]any
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		return res.StatusCode, decoded, res.Header.Get(fiber.HeaderRetryAfter)
+	}
+
+	for i := range freeLoginFailures {
+		if status, body, _ := login("wrong"); status != fiber.StatusUnauthorized || body["code"] != "invalid_credentials" {
+			t.Fatalf("wrong password %d: status %d, code %v; want 401 invalid_credentials", i+1, status, body["code"])
+		}
+	}
+	stored, err := store.FindByID("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LockedUntil != nil || stored.FailedLoginAttempts != freeLoginFailures {
+		t.Fatalf("after the free failures: locked until %v with %d failures, want unlocked with %d",
+			stored.LockedUntil, stored.FailedLoginAttempts, freeLoginFailures)
+	}
+
+	status, body, retryHeader := login("wrong")
+	if status != fiber.StatusLocked || body["code"] != "account_locked" {
+		t.Fatalf("one failure too many: status %d, code %v; want 423 account_locked", status, body["code"])
+	}
+	retryAfter, _ := body["retryAfter"].(float64)
+	if retryAfter < 1 || retryAfter > lockoutBase.Seconds() || retryHeader != strconv.Itoa(int(retryAfter)) {
+		t.Errorf("retryAfter %v, Retry-After %q; want the same, at most %s", body["retryAfter"], retryHeader, lockoutBase)
+	}
+	if status, body, _ := login(passphrase); status != fiber.StatusLocked || body["code"] != "account_locked" {
+		t.Errorf("right password while locked: status %d, code %v; want 423 account_locked", status, body["code"])
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/users/user-1/unlock", nil)
+	req.Header.Set("X-Test-User", "user-admin")
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("unlock: status %d, want 204", res.StatusCode)
+	}
+	if status, body, _ := login(passphrase); status != fiber.StatusOK {
+		t.Fatalf("login after unlock: status %d, code %v; want 200", status, body["code"])
+	}
+	if stored, err = store.FindByID("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if stored.LockedUntil != nil || stored.FailedLoginAttempts != 0 {
+		t.Errorf("after unlock and login: locked until %v with %d failures, want both cleared",
+			stored.LockedUntil, stored.FailedLoginAttempts)
+	}
+}
+
+// TestIPThrottle fails logins from one address until it is refused, even
+// with the right password, while other addresses are unaffected, and lets
+// it in again once the window has passed
+func TestIPThrottle(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	const passphrase = "correct horse battery staple"
+	hash, err := password.Hash(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &domain.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", Password: hash, Role: domain.RoleStaff,
+		Status: domain.StatusActive, CreatedAt: time.Now()}
+	if err := store.Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	attacker, other := ClientInfo{IP: "203.0.113.7"}, ClientInfo{IP: "198.51.100.9"}
+	for i := range ipMaxFailures {
+		email := fmt.Sprintf("nobody%d@example.com", i)
+		if _, err := service.Login(email, "wrong", "", attacker); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failure %d: got %v, want %v", i+1, err, ErrInvalidCredentials)
+		}
+	}
+	if _, err := service.Login(u.Email, passphrase, "", attacker); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("right password from the throttled address: got %v, want %v", err, ErrTooManyAttempts)
+	}
+	if _, err := service.Login(u.Email, passphrase, "", other); err != nil {
+		t.Fatalf("another address: %v", err)
+	}
+
+	service.ipFailures.mu.Lock()
+	service.ipFailures.failures[attacker.IP].start = time.Now().Add(-ipWindow - time.Second)
+	service.ipFailures.mu.Unlock()
+	if _, err := service.Login(u.Email, passphrase, "", attacker); err != nil {
+		t.Fatalf("the throttled address after the window: %v", err)
+	}
+}
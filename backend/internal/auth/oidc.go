@@ -0,0 +1,449 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+	"golang.org/x/oauth2"
+)
+
+const ssoFlowTTL = 10 * time.Minute
+
+// ssoLinkStatePrefix marks the OAuth state of a flow that links the
+// identity to a signed-in account rather than logging in
+const ssoLinkStatePrefix = "link."
+
+var (
+	ErrSSODisabled        = domain.NotFound("sso_disabled", "single sign-on is not configured")
+	ErrInvalidSSOState    = errors.New("invalid or expired single sign-on request")
+	ErrSSOFailed          = errors.New("single sign-on failed")
+	ErrSSOEmailUnverified = errors.New("the identity provider has not verified this email address")
+	ErrSSOAccountConflict = errors.New("this email belongs to an account linked to a different identity")
+	ErrSSOLinkRequired    = errors.New("this email belongs to an account that must link single sign-on from its settings")
+	ErrInvalidSSOLink     = domain.Invalid("invalid_sso_link", "the single sign-on link is invalid or has expired")
+	ErrSSOIdentityTaken   = domain.Conflict("sso_identity_taken", "this identity is already linked to another account")
+)
+
+// OIDCProvider is the company identity provider users can sign in with,
+// using the authorization code flow with PKCE
+type OIDCProvider struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+
+	defaultRole     string
+	departmentClaim string
+	jobTitleClaim   string
+}
+
+// NewOIDCProvider fetches the discovery document of cfg.OIDCIssuerURL. It
+// returns nil when single sign-on is not configured.
+func NewOIDCProvider(ctx context.Context, cfg *config.Config) (*OIDCProvider, error) {
+	if cfg.OIDCIssuerURL == "" {
+		return nil, nil
+	}
+	if cfg.OIDCClientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set")
+	}
+
+	defaultRole, ok := domain.NormalizeRole(cfg.OIDCDefaultRole)
+	if !ok {
+		return nil, fmt.Errorf("OIDC_DEFAULT_ROLE %q is not one of %v", cfg.OIDCDefaultRole, domain.Roles)
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCProvider{
+		oauth: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier:        provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}),
+		defaultRole:     defaultRole,
+		departmentClaim: cfg.OIDCDepartmentClaim,
+		jobTitleClaim:   cfg.OIDCJobTitleClaim,
+	}, nil
+}
+
+// SSOHandoff is what a finished single sign-on passes to the frontend: a
+// login challenge token to redeem with VerifySecondFactor, and the second
+// factor still owed on it, if any. For a link flow, Token is instead to be
+// confirmed with ConfirmOIDCLink.
+type SSOHandoff struct {
+	Token        string
+	SecondFactor string // Empty, SecondFactorTOTP or SecondFactorTOTPEnrollment
+	Link         bool
+}
+
+// BeginOIDCLogin starts a single sign-on login. The browser is sent to
+// authURL; state comes back with the callback and must be presented to
+// FinishOIDCLogin by the same browser.
+func (s *AuthService) BeginOIDCLogin() (state, authURL string, err error) {
+	return s.beginOIDCFlow("")
+}
+
+// BeginOIDCLink starts linking the provider identity to the signed-in
+// user's account, the only way an account with a password, TOTP or a
+// passkey gets one. The browser is sent to authURL; the link is made once
+// the same user confirms the callback's token with ConfirmOIDCLink.
+func (s *AuthService) BeginOIDCLink(userID string) (authURL string, err error) {
+	_, authURL, err = s.beginOIDCFlow(userID)
+	return authURL, err
+}
+
+func (s *AuthService) beginOIDCFlow(linkUserID string) (state, authURL string, err error) {
+	if s.oidc == nil {
+		return "", "", ErrSSODisabled
+	}
+
+	nonce, err := randomToken(selectorBytes)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+	state, err = s.ssoFlows.Put(nonce, verifier, linkUserID)
+	if err != nil {
+		return "", "", err
+	}
+	authURL = s.oidc.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return state, authURL, nil
+}
+
+// IsSSOLinkState reports whether state belongs to a flow started by
+// BeginOIDCLink
+func IsSSOLinkState(state string) bool {
+	return strings.HasPrefix(state, ssoLinkStatePrefix)
+}
+
+// FinishOIDCLogin handles the provider's callback: it redeems code, checks
+// the ID token and finds, links or creates the user it names. Accounts are
+// matched on the provider's subject first, then on a verified email; a new
+// account gets the default role. SSO stands in for the password only, so
+// users with TOTP still owe a code.
+func (s *AuthService) FinishOIDCLogin(ctx context.Context, state, code string, client ClientInfo) (*SSOHandoff, error) {
+	if s.oidc == nil {
+		return nil, ErrSSODisabled
+	}
+	flow, err := s.ssoFlows.Take(state)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.oidc.oauth.Exchange(ctx, code, oauth2.VerifierOption(flow.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSOFailed, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: no id_token in the token response", ErrSSOFailed)
+	}
+	idToken, err := s.oidc.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSOFailed, err)
+	}
+	if idToken.Nonce != flow.nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrSSOFailed)
+	}
+
+	if flow.linkUserID != "" {
+		if idToken.Subject == "" {
+			return nil, fmt.Errorf("%w: the ID token has no subject", ErrSSOFailed)
+		}
+		token, err := s.ssoFlows.PutLink(flow.linkUserID, idToken.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return &SSOHandoff{Token: token, Link: true}, nil
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSOFailed, err)
+	}
+	user, err := s.ssoUser(idToken.Subject, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	factor := ""
+	if user.TOTPEnabled {
+		factor = SecondFactorTOTP
+	} else if s.secondFactorRequired(user) {
+		factor = SecondFactorTOTPEnrollment
+	}
+	challenge, err := s.challenges.Issue(user.ID, factor, client)
+	if err != nil {
+		return nil, err
+	}
+	if factor == "" {
+		s.challenges.Satisfy(challenge)
+	}
+	return &SSOHandoff{Token: challenge, SecondFactor: factor}, nil
+}
+
+// LoginPageURL is the frontend login page with query attached, where the
+// single sign-on callback sends the browser back to
+func (s *AuthService) LoginPageURL(query url.Values) string {
+	return strings.TrimRight(s.config.AppURL, "/") + "/login?" + query.Encode()
+}
+
+// SettingsPageURL is the frontend settings page with query attached, where
+// the callback of a link flow sends the browser back to
+func (s *AuthService) SettingsPageURL(query url.Values) string {
+	return strings.TrimRight(s.config.AppURL, "/") + "/settings?" + query.Encode()
+}
+
+// ConfirmOIDCLink links the identity behind a link flow's token to userID,
+// who must be the user that started the flow. Confirming with the
+// signed-in user's credentials stops a link started by someone else from
+// being completed in a victim's browser.
+func (s *AuthService) ConfirmOIDCLink(userID, token string) error {
+	link := s.ssoFlows.TakeLink(token)
+	if link == nil || link.userID != userID {
+		return ErrInvalidSSOLink
+	}
+
+	linked, err := s.repo.FindByOIDCSubject(link.subject)
+	if err != nil {
+		return err
+	}
+	if linked != nil && linked.ID != userID {
+		return ErrSSOIdentityTaken
+	}
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidSSOLink
+	}
+
+	user.OIDCSubject = link.subject
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	s.recordEvent(user.ID, domain.AuthEventSSOAccountLinked, "", link.subject)
+	return nil
+}
+
+// ssoUser returns the account for an identity provider subject, linking or
+// provisioning one as needed. Only an email the provider says it verified
+// is trusted, and only accounts nobody can sign in to yet, such as pending
+// invitations, are linked by it; others link from their settings. The
+// directory owns the profile, so name, department and job title are
+// refreshed from the claims on every login.
+func (s *AuthService) ssoUser(subject string, claims map[string]interface{}) (*domain.User, error) {
+	email := claimString(claims, "email")
+	if subject == "" || email == "" {
+		return nil, fmt.Errorf("%w: the ID token has no subject or email", ErrSSOFailed)
+	}
+
+	user, err := s.repo.FindByOIDCSubject(subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return nil, ErrSSOEmailUnverified
+		}
+		user, err = s.repo.FindByEmail(email)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return s.provisionSSOUser(subject, email, claims)
+		}
+		if user.OIDCSubject != "" {
+			return nil, ErrSSOAccountConflict
+		}
+		hasCredentials, err := s.hasCredentials(user)
+		if err != nil {
+			return nil, err
+		}
+		if hasCredentials {
+			return nil, ErrSSOLinkRequired
+		}
+		user.OIDCSubject = subject
+		s.recordEvent(user.ID, domain.AuthEventSSOAccountLinked, "", subject)
+	}
+
+	applySSOProfile(user, claims, s.oidc)
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *AuthService) provisionSSOUser(subject, email string, claims map[string]interface{}) (*domain.User, error) {
+	user := &domain.User{
+		Email:       email,
+		Role:        s.oidc.defaultRole,
+		Department:  "General",
+		Status:      domain.StatusActive,
+		OIDCSubject: subject,
+	}
+	applySSOProfile(user, claims, s.oidc)
+	if user.Name == "" {
+		user.Name = email
+	}
+	if err := s.users.Provision(user); err != nil {
+		return nil, err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventSSOUserProvisioned, "", subject)
+	return user, nil
+}
+
+// hasCredentials reports whether user can already sign in by themselves,
+// with a password, TOTP or a passkey
+func (s *AuthService) hasCredentials(user *domain.User) (bool, error) {
+	if user.Password != "" || user.TOTPEnabled {
+		return true, nil
+	}
+	passkeys, err := s.passkeys.FindUserPasskeys(user.ID)
+	if err != nil {
+		return false, err
+	}
+	return len(passkeys) > 0, nil
+}
+
+// applySSOProfile copies the claims the provider sent onto user, leaving
+// fields it did not send alone
+func applySSOProfile(user *domain.User, claims map[string]interface{}, p *OIDCProvider) {
+	if name := claimString(claims, "name"); name != "" {
+		user.Name = name
+	}
+	if department := claimString(claims, p.departmentClaim); department != "" {
+		user.Department = department
+	}
+	if jobTitle := claimString(claims, p.jobTitleClaim); jobTitle != "" {
+		user.JobTitle = jobTitle
+	}
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	if name == "" {
+		return ""
+	}
+	value, _ := claims[name].(string)
+	return strings.TrimSpace(value)
+}
+
+type ssoFlow struct {
+	nonce      string
+	verifier   string // PKCE code verifier
+	linkUserID string // Set for a link flow
+	expiresAt  time.Time
+}
+
+// ssoLink is an identity waiting for its link to be confirmed
+type ssoLink struct {
+	userID    string
+	subject   string
+	expiresAt time.Time
+}
+
+// ssoFlowStore holds each single sign-on between the redirect to the
+// provider and its callback, keyed by the OAuth state, and each link
+// between the callback and its confirmation. Entries are single-use.
+type ssoFlowStore struct {
+	mu      sync.Mutex
+	pending map[string]*ssoFlow
+	links   map[string]*ssoLink
+}
+
+func newSSOFlowStore() *ssoFlowStore {
+	return &ssoFlowStore{pending: map[string]*ssoFlow{}, links: map[string]*ssoLink{}}
+}
+
+// Put stores a flow and returns its state; a link flow's state is marked
+// with ssoLinkStatePrefix
+func (f *ssoFlowStore) Put(nonce, verifier, linkUserID string) (string, error) {
+	state, err := randomToken(verifierBytes)
+	if err != nil {
+		return "", err
+	}
+	if linkUserID != "" {
+		state = ssoLinkStatePrefix + state
+	}
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expire(now)
+	f.pending[state] = &ssoFlow{nonce: nonce, verifier: verifier, linkUserID: linkUserID, expiresAt: now.Add(ssoFlowTTL)}
+	return state, nil
+}
+
+// PutLink stores an identity to link to userID and returns the token that
+// confirms it
+func (f *ssoFlowStore) PutLink(userID, subject string) (string, error) {
+	token, err := randomToken(verifierBytes)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expire(now)
+	f.links[token] = &ssoLink{userID: userID, subject: subject, expiresAt: now.Add(ssoFlowTTL)}
+	return token, nil
+}
+
+// TakeLink removes and returns the link for token, or nil
+func (f *ssoFlowStore) TakeLink(token string) *ssoLink {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	link := f.links[token]
+	delete(f.links, token)
+	if link == nil || time.Now().After(link.expiresAt) {
+		return nil
+	}
+	return link
+}
+
+// expire drops what has expired; the caller holds f.mu
+func (f *ssoFlowStore) expire(now time.Time) {
+	for state, flow := range f.pending {
+		if now.After(flow.expiresAt) {
+			delete(f.pending, state)
+		}
+	}
+	for token, link := range f.links {
+		if now.After(link.expiresAt) {
+			delete(f.links, token)
+		}
+	}
+}
+
+// Take removes and returns the flow for state
+func (f *ssoFlowStore) Take(state string) (*ssoFlow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	flow, found := f.pending[state]
+	if !found {
+		return nil, ErrInvalidSSOState
+	}
+	delete(f.pending, state)
+	if time.Now().After(flow.expiresAt) {
+		return nil, ErrInvalidSSOState
+	}
+	return flow, nil
+}
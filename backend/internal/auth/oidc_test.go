@@ -0,0 +1,463 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+const (
+	testClientID     = "stacklevest"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "http://localhost:8080/api/auth/oidc/callback"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS, and a token
+// endpoint that checks the client secret, redirect URI and PKCE verifier
+// before handing out an RS256 ID token
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string // PKCE S256 code challenge
+	claims    jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]any{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// Authorize plays the user signing in at the provider: it takes the URL the
+// app redirected to and returns the code the provider would send back.
+// claims are added to the ID token; nonce is echoed unless claims sets it.
+func (idp *mockIdP) Authorize(t *testing.T, authURL string, claims jwt.MapClaims) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+
+	full := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	code = domain.GenerateID("code")
+	idp.mu.Lock()
+	idp.codes[code] = mockGrant{challenge: q.Get("code_challenge"), claims: full}
+	idp.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != testClientID || secret != testClientSecret {
+		oauthError(w, "invalid_client")
+		return
+	}
+
+	idp.mu.Lock()
+	grant, found := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !found || r.PostForm.Get("redirect_uri") != testRedirectURL {
+		oauthError(w, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		oauthError(w, "invalid_grant")
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func oauthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func newOIDCTestService(t *testing.T) (*AuthService, *mockIdP, *config.Config) {
+	t.Helper()
+	idp := newMockIdP(t)
+	cfg := testConfig()
+	cfg.OIDCIssuerURL = idp.server.URL
+	cfg.OIDCClientID = testClientID
+	cfg.OIDCClientSecret = testClientSecret
+	cfg.OIDCRedirectURL = testRedirectURL
+	cfg.OIDCDefaultRole = domain.RoleStaff
+	cfg.OIDCDepartmentClaim = "department"
+	cfg.OIDCJobTitleClaim = "job_title"
+	cfg.TOTPRequiredRoles = []string{domain.RoleAdmin}
+	service, _ := newTestService(t, cfg)
+	return service, idp, cfg
+}
+
+// ssoLogin runs one sign-in through the mock provider
+func ssoLogin(t *testing.T, service *AuthService, idp *mockIdP, claims jwt.MapClaims) (*SSOHandoff, error) {
+	t.Helper()
+	_, authURL, err := service.BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Authorize(t, authURL, claims)
+	return service.FinishOIDCLogin(context.Background(), state, code, ClientInfo{IP: "203.0.113.7"})
+}
+
+func TestOIDCProvisionsUserJustInTime(t *testing.T) {
+	service, idp, _ := newOIDCTestService(t)
+
+	handoff, err := ssoLogin(t, service, idp, jwt.MapClaims{
+		"sub":            "idp-123",
+		"email":          "grace@example.com",
+		"email_verified": true,
+		"name":           "Grace Hopper",
+		"department":     "Engineering",
+		"job_title":      "Rear Admiral",
+	})
+	if err != nil {
+		t.Fatalf("FinishOIDCLogin: %v", err)
+	}
+	if handoff.SecondFactor != "" {
+		t.Fatalf("SecondFactor = %q, want none", handoff.SecondFactor)
+	}
+
+	resp, err := service.VerifySecondFactor(handoff.Token, "")
+	if err != nil {
+		t.Fatalf("VerifySecondFactor: %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		t.Fatalf("no session issued: %+v", resp)
+	}
+	user := resp.User
+	if user.Email != "grace@example.com" || user.Name != "Grace Hopper" || user.Department != "Engineering" ||
+		user.JobTitle != "Rear Admiral" || user.Role != domain.RoleStaff || user.OIDCSubject != "idp-123" ||
+		user.StaffNumber != "SLV-0001" {
+		t.Fatalf("unexpected provisioned user %+v", user)
+	}
+	sessions, err := service.ListSessions(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].IP != "203.0.113.7" {
+		t.Fatalf("sessions = %+v, want one from the callback's client", sessions)
+	}
+
+	// The handoff token is single-use
+	if _, err := service.VerifySecondFactor(handoff.Token, ""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("reused handoff: got %v, want ErrInvalidChallenge", err)
+	}
+
+	// The next login finds the account by subject, even under a new email,
+	// and refreshes the profile from the directory
+	handoff, err = ssoLogin(t, service, idp, jwt.MapClaims{
+		"sub":        "idp-123",
+		"email":      "grace.hopper@example.com",
+		"name":       "Grace Hopper",
+		"department": "Research",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = service.VerifySecondFactor(handoff.Token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.User.ID != user.ID || resp.User.Department != "Research" || resp.User.JobTitle != "Rear Admiral" {
+		t.Fatalf("unexpected user after second login %+v", resp.User)
+	}
+}
+
+func TestOIDCLinksExistingAccount(t *testing.T) {
+	service, idp, _ := newOIDCTestService(t)
+	existing := &domain.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", Role: domain.RoleManager, CreatedAt: time.Now()}
+	if err := service.repo.Create(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only an email the provider verified is matched
+	for _, claims := range []jwt.MapClaims{
+		{"sub": "idp-ada", "email": "ada@example.com"},
+		{"sub": "idp-ada", "email": "ada@example.com", "email_verified": "true"},
+	} {
+		if _, err := ssoLogin(t, service, idp, claims); !errors.Is(err, ErrSSOEmailUnverified) {
+			t.Fatalf("%v: got %v, want ErrSSOEmailUnverified", claims, err)
+		}
+	}
+
+	handoff, err := ssoLogin(t, service, idp, jwt.MapClaims{"sub": "idp-ada", "email": "ADA@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := service.VerifySecondFactor(handoff.Token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.User.ID != existing.ID || resp.User.OIDCSubject != "idp-ada" || resp.User.Role != domain.RoleManager {
+		t.Fatalf("account not linked: %+v", resp.User)
+	}
+
+	// Another identity claiming the same email is refused
+	if _, err := ssoLogin(t, service, idp, jwt.MapClaims{"sub": "idp-other", "email": "ada@example.com", "email_verified": true}); !errors.Is(err, ErrSSOAccountConflict) {
+		t.Fatalf("second identity: got %v, want ErrSSOAccountConflict", err)
+	}
+}
+
+// TestOIDCLinksOnlyFromSettings checks that an account someone can already
+// sign in to is never linked by email, only by its user confirming a link
+// flow they started
+func TestOIDCLinksOnlyFromSettings(t *testing.T) {
+	service, idp, _ := newOIDCTestService(t)
+	for _, u := range []*domain.User{
+		{ID: "user-1", Name: "Ada", Email: "ada@example.com", Password: "hash", Role: domain.RoleStaff, CreatedAt: time.Now()},
+		{ID: "user-2", Name: "Bob", Email: "bob@example.com", Password: "hash", Role: domain.RoleStaff, CreatedAt: time.Now()},
+	} {
+		if err := service.repo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	adaClaims := jwt.MapClaims{"sub": "idp-ada", "email": "ada@example.com", "email_verified": true}
+
+	if _, err := ssoLogin(t, service, idp, adaClaims); !errors.Is(err, ErrSSOLinkRequired) {
+		t.Fatalf("login to an account with a password: got %v, want ErrSSOLinkRequired", err)
+	}
+
+	link := func(userID string, claims jwt.MapClaims) string {
+		t.Helper()
+		authURL, err := service.BeginOIDCLink(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := idp.Authorize(t, authURL, claims)
+		if !IsSSOLinkState(state) {
+			t.Fatalf("link flow state %q is not marked", state)
+		}
+		handoff, err := service.FinishOIDCLogin(context.Background(), state, code, ClientInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !handoff.Link {
+			t.Fatalf("link flow handed off a login: %+v", handoff)
+		}
+		return handoff.Token
+	}
+
+	// A link started by Bob cannot be confirmed by Ada, and is used up
+	token := link("user-2", adaClaims)
+	if err := service.ConfirmOIDCLink("user-1", token); !errors.Is(err, ErrInvalidSSOLink) {
+		t.Fatalf("confirmed by another user: got %v, want ErrInvalidSSOLink", err)
+	}
+	if err := service.ConfirmOIDCLink("user-2", token); !errors.Is(err, ErrInvalidSSOLink) {
+		t.Fatalf("reused link token: got %v, want ErrInvalidSSOLink", err)
+	}
+
+	if err := service.ConfirmOIDCLink("user-1", link("user-1", adaClaims)); err != nil {
+		t.Fatalf("ConfirmOIDCLink: %v", err)
+	}
+	handoff, err := ssoLogin(t, service, idp, adaClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := service.VerifySecondFactor(handoff.Token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.User.ID != "user-1" {
+		t.Fatalf("linked identity signed in as %s", resp.User.ID)
+	}
+
+	// An identity belongs to one account
+	if err := service.ConfirmOIDCLink("user-2", link("user-2", adaClaims)); !errors.Is(err, ErrSSOIdentityTaken) {
+		t.Fatalf("linking a taken identity: got %v, want ErrSSOIdentityTaken", err)
+	}
+}
+
+func TestOIDCRejectsBadLogins(t *testing.T) {
+	service, idp, _ := newOIDCTestService(t)
+
+	if _, err := ssoLogin(t, service, idp, jwt.MapClaims{"sub": "idp-1", "email": "x@example.com", "email_verified": false}); !errors.Is(err, ErrSSOEmailUnverified) {
+		t.Fatalf("unverified email: got %v, want ErrSSOEmailUnverified", err)
+	}
+	if _, err := ssoLogin(t, service, idp, jwt.MapClaims{"sub": "idp-1", "email": "x@example.com", "nonce": "replayed"}); !errors.Is(err, ErrSSOFailed) {
+		t.Fatalf("wrong nonce: got %v, want ErrSSOFailed", err)
+	}
+	if _, err := ssoLogin(t, service, idp, jwt.MapClaims{"sub": "idp-1", "email": "x@example.com", "aud": "someone-else"}); !errors.Is(err, ErrSSOFailed) {
+		t.Fatalf("wrong audience: got %v, want ErrSSOFailed", err)
+	}
+
+	// A state is only good once
+	_, authURL, err := service.BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Authorize(t, authURL, jwt.MapClaims{"sub": "idp-1", "email": "x@example.com", "email_verified": true})
+	if _, err := service.FinishOIDCLogin(context.Background(), state, code, ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishOIDCLogin(context.Background(), state, code, ClientInfo{}); !errors.Is(err, ErrInvalidSSOState) {
+		t.Fatalf("replayed state: got %v, want ErrInvalidSSOState", err)
+	}
+}
+
+func TestOIDCKeepsSecondFactor(t *testing.T) {
+	service, idp, _ := newOIDCTestService(t)
+	admin := &domain.User{ID: "admin-1", Email: "root@example.com", Role: domain.RoleAdmin, CreatedAt: time.Now()}
+	if err := service.repo.Create(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	handoff, err := ssoLogin(t, service, idp, jwt.MapClaims{"sub": "idp-root", "email": "root@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handoff.SecondFactor != SecondFactorTOTPEnrollment {
+		t.Fatalf("SecondFactor = %q, want %q", handoff.SecondFactor, SecondFactorTOTPEnrollment)
+	}
+	if _, err := service.VerifySecondFactor(handoff.Token, ""); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Fatalf("skipped enrolment: got %v, want ErrTOTPNotEnrolled", err)
+	}
+}
+
+func TestOIDCCallbackRedirects(t *testing.T) {
+	service, idp, _ := newOIDCTestService(t)
+	app := fiber.New()
+	NewAuthHandler(service).RegisterRoutes(app, func(c *fiber.Ctx) error { return c.Next() })
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusFound {
+		t.Fatalf("login status = %d, want 302", res.StatusCode)
+	}
+	var stateCookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == oidcStateCookie {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("no state cookie set")
+	}
+	code, state := idp.Authorize(t, res.Header.Get("Location"), jwt.MapClaims{"sub": "idp-9", "email": "nine@example.com", "email_verified": true})
+	callback := "/api/auth/oidc/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+
+	// Without the cookie the callback is refused: it was started elsewhere
+	res, err = app.Test(httptest.NewRequest(http.MethodGet, callback, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := res.Header.Get("Location"); loc != testOrigin+"/login?ssoError=expired" {
+		t.Fatalf("callback without cookie redirected to %q", loc)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: stateCookie.Value})
+	res, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), testOrigin+"/login?") || loc.Query().Get("sso") == "" {
+		t.Fatalf("callback redirected to %q", loc)
+	}
+	if _, err := service.VerifySecondFactor(loc.Query().Get("sso"), ""); err != nil {
+		t.Fatalf("redeeming the handoff: %v", err)
+	}
+}
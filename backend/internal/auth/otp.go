@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+const (
+	otpDigits         = 6
+	otpTTL            = 5 * time.Minute
+	otpMaxAttempts    = 5
+	otpResendInterval = 30 * time.Second
+)
+
+var (
+	ErrOTPNotRequested = domain.Unauthorized("otp_not_requested", "no OTP requested or expired")
+	ErrOTPExpired      = domain.Unauthorized("otp_expired", "OTP expired")
+	ErrOTPInvalid      = domain.Unauthorized("invalid_otp", "invalid OTP")
+	ErrOTPTooManyTries = domain.RateLimited("otp_too_many_tries", "too many attempts, request a new OTP")
+	ErrOTPTooSoon      = domain.RateLimited("otp_too_soon", "please wait before requesting another OTP")
+)
+
+type otpEntry struct {
+	codeHash  string
+	expiresAt time.Time
+	issuedAt  time.Time
+	attempts  int
+}
+
+// otpStore keeps one pending code per email in memory. Codes are stored
+// hashed, expire after otpTTL and are burned after otpMaxAttempts wrong
+// guesses.
+type otpStore struct {
+	mu    sync.Mutex
+	codes map[string]*otpEntry
+}
+
+func newOTPStore() *otpStore {
+	return &otpStore{codes: map[string]*otpEntry{}}
+}
+
+// Issue generates a fresh code for email, replacing any pending one
+func (o *otpStore) Issue(email string) (string, error) {
+	key := strings.ToLower(email)
+	now := time.Now()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.purgeExpired(now)
+	if prev, ok := o.codes[key]; ok && now.Sub(prev.issuedAt) < otpResendInterval {
+		return "", ErrOTPTooSoon
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%0*d", otpDigits, n.Int64())
+
+	o.codes[key] = &otpEntry{
+		codeHash:  hashVerifier(code),
+		expiresAt: now.Add(otpTTL),
+		issuedAt:  now,
+	}
+	return code, nil
+}
+
+// Verify consumes the pending code for email if code matches
+func (o *otpStore) Verify(email, code string) error {
+	key := strings.ToLower(email)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, ok := o.codes[key]
+	if !ok {
+		return ErrOTPNotRequested
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(o.codes, key)
+		return ErrOTPExpired
+	}
+	if !verifierMatches(entry.codeHash, strings.TrimSpace(code)) {
+		entry.attempts++
+		if entry.attempts >= otpMaxAttempts {
+			delete(o.codes, key)
+			return ErrOTPTooManyTries
+		}
+		return ErrOTPInvalid
+	}
+
+	delete(o.codes, key) // Consume OTP
+	return nil
+}
+
+// Caller must hold o.mu
+func (o *otpStore) purgeExpired(now time.Time) {
+	for key, entry := range o.codes {
+		if now.After(entry.expiresAt) {
+			delete(o.codes, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// issueOTP issues a code for email and returns it with a different one
+func issueOTP(t *testing.T, o *otpStore, email string) (code, wrong string) {
+	t.Helper()
+	code, err := o.Issue(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		t.Fatalf("code %q is not numeric", code)
+	}
+	return code, fmt.Sprintf("%0*d", otpDigits, (n+1)%1_000_000)
+}
+
+func TestOTPWorksOnce(t *testing.T) {
+	o := newOTPStore()
+	code, _ := issueOTP(t, o, "Ada@Example.com")
+
+	if err := o.Verify("ada@example.com", " "+code+" "); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := o.Verify("ada@example.com", code); !errors.Is(err, ErrOTPNotRequested) {
+		t.Errorf("second use: got %v, want %v", err, ErrOTPNotRequested)
+	}
+}
+
+func TestOTPExpires(t *testing.T) {
+	o := newOTPStore()
+	code, _ := issueOTP(t, o, "ada@example.com")
+	o.codes["ada@example.com"].expiresAt = time.Now().Add(-time.Second)
+
+	if err := o.Verify("ada@example.com", code); !errors.Is(err, ErrOTPExpired) {
+		t.Fatalf("expired code: got %v, want %v", err, ErrOTPExpired)
+	}
+	if err := o.Verify("ada@example.com", code); !errors.Is(err, ErrOTPNotRequested) {
+		t.Errorf("expired code again: got %v, want %v", err, ErrOTPNotRequested)
+	}
+}
+
+// TestOTPAttemptLimit guesses wrong until the code is burned, after which
+// even the right code is refused
+func TestOTPAttemptLimit(t *testing.T) {
+	o := newOTPStore()
+	code, wrong := issueOTP(t, o, "ada@example.com")
+
+	for i := 1; i < otpMaxAttempts; i++ {
+		if err := o.Verify("ada@example.com", wrong); !errors.Is(err, ErrOTPInvalid) {
+			t.Fatalf("wrong guess %d: got %v, want %v", i, err, ErrOTPInvalid)
+		}
+	}
+	if err := o.Verify("ada@example.com", wrong); !errors.Is(err, ErrOTPTooManyTries) {
+		t.Fatalf("wrong guess %d: got %v, want %v", otpMaxAttempts, err, ErrOTPTooManyTries)
+	}
+	if err := o.Verify("ada@example.com", code); !errors.Is(err, ErrOTPNotRequested) {
+		t.Errorf("right code after the limit: got %v, want %v", err, ErrOTPNotRequested)
+	}
+}
+
+func TestOTPResendInterval(t *testing.T) {
+	o := newOTPStore()
+	first, _ := issueOTP(t, o, "ada@example.com")
+	if _, err := o.Issue("ADA@example.com"); !errors.Is(err, ErrOTPTooSoon) {
+		t.Fatalf("second code at once: got %v, want %v", err, ErrOTPTooSoon)
+	}
+
+	o.codes["ada@example.com"].issuedAt = time.Now().Add(-otpResendInterval)
+	second, _ := issueOTP(t, o, "ada@example.com")
+	if first != second {
+		if err := o.Verify("ada@example.com", first); !errors.Is(err, ErrOTPInvalid) {
+			t.Errorf("the replaced code: got %v, want %v", err, ErrOTPInvalid)
+		}
+	}
+	if err := o.Verify("ada@example.com", second); err != nil {
+		t.Errorf("the new code: %v", err)
+	}
+}
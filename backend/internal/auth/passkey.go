@@ -0,0 +1,368 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+const (
+	ceremonyTTL        = 5 * time.Minute
+	passkeyNameMaxLen  = 64
+	defaultPasskeyName = "Passkey"
+)
+
+var (
+	ErrInvalidCeremony = domain.Invalid("invalid_ceremony", "invalid or expired passkey request")
+	ErrPasskeyRejected = domain.Unauthorized("passkey_rejected", "passkey verification failed")
+	ErrPasskeyCloned   = domain.Unauthorized("passkey_cloned", "passkey signature counter went backwards; it may have been cloned")
+)
+
+// NewRelyingParty configures WebAuthn for the app. Passkeys are
+// discoverable credentials with user verification, so one on its own
+// stands in for both the password and a second factor.
+func NewRelyingParty(cfg *config.Config) (*webauthn.WebAuthn, error) {
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTTL, TimeoutUVD: ceremonyTTL}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: "StackleVest",
+		RPOrigins:     cfg.WebAuthnOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// PasskeyCeremony is handed to the browser to start a registration or
+// login. Options goes to navigator.credentials.create() or .get(); Token
+// comes back with the result.
+type PasskeyCeremony struct {
+	Token   string      `json:"token"`
+	Options interface{} `json:"options"`
+}
+
+// BeginPasskeyRegistration starts adding a passkey to the user's account
+func (s *AuthService) BeginPasskeyRegistration(userID string) (*PasskeyCeremony, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.passkeyOwner(user)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, session, err := s.webauthn.BeginRegistration(owner,
+		webauthn.WithExclusions(webauthn.Credentials(owner.WebAuthnCredentials()).CredentialDescriptors()))
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.ceremonies.Put(user.ID, session)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyCeremony{Token: token, Options: creation}, nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's attestation
+// response and stores the new passkey under name
+func (s *AuthService) FinishPasskeyRegistration(userID, token, name string, response []byte) (*domain.Passkey, error) {
+	session, err := s.ceremonies.Take(token, userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.passkeyOwner(user)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+	credential, err := s.webauthn.CreateCredential(owner, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+	if existing, err := s.passkeys.FindPasskey(id); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, fmt.Errorf("%w: credential is already registered", ErrPasskeyRejected)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	if len([]rune(name)) > passkeyNameMaxLen {
+		name = string([]rune(name)[:passkeyNameMaxLen])
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+	passkey := &domain.Passkey{
+		ID:              id,
+		UserID:          user.ID,
+		Name:            name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	if err := s.passkeys.CreatePasskey(passkey); err != nil {
+		return nil, err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventPasskeyAdded, "", passkey.Name)
+	return passkey, nil
+}
+
+// BeginPasskeyLogin starts a passwordless login. No email is needed: the
+// browser offers whichever passkeys it holds for this site.
+func (s *AuthService) BeginPasskeyLogin() (*PasskeyCeremony, error) {
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.ceremonies.Put("", session)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyCeremony{Token: token, Options: assertion}, nil
+}
+
+// FinishPasskeyLogin verifies the assertion and signs the passkey's owner
+// in. A signature counter that did not move forward means the private key
+// exists twice, so the login is refused.
+func (s *AuthService) FinishPasskeyLogin(token string, response []byte, client ClientInfo) (*LoginResponse, error) {
+	session, err := s.ceremonies.Take(token, "")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+
+	var user *domain.User
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		found, err := s.repo.FindByID(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			return nil, domain.ErrUserNotFound
+		}
+		user = found
+		return s.passkeyOwner(user)
+	}
+	credential, err := s.webauthn.ValidateDiscoverableLogin(lookup, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+
+	passkey, err := s.passkeys.FindPasskey(base64.RawURLEncoding.EncodeToString(credential.ID))
+	if err != nil {
+		return nil, err
+	}
+	if passkey == nil || passkey.UserID != user.ID {
+		return nil, ErrPasskeyRejected
+	}
+	if credential.Authenticator.CloneWarning {
+		s.recordEvent(user.ID, domain.AuthEventPasskeyCloneDetected, "",
+			fmt.Sprintf("passkey %s presented counter %d, expected more than %d",
+				passkey.ID, parsed.Response.AuthenticatorData.Counter, passkey.SignCount))
+		return nil, ErrPasskeyCloned
+	}
+
+	now := time.Now()
+	passkey.SignCount = credential.Authenticator.SignCount
+	passkey.BackupState = credential.Flags.BackupState
+	passkey.LastUsedAt = &now
+	if err := s.passkeys.UpdatePasskey(passkey); err != nil {
+		return nil, err
+	}
+
+	if user.MustResetPassword {
+		return s.passwordResetResponse(user)
+	}
+
+	sess, refreshToken, err := s.createSession(user.ID, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.generateAccessToken(user, sess.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		User:         user,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *AuthService) ListPasskeys(userID string) ([]domain.Passkey, error) {
+	return s.passkeys.FindUserPasskeys(userID)
+}
+
+// DeletePasskey removes one of the user's passkeys. Passkeys belonging to
+// someone else are reported as not found.
+func (s *AuthService) DeletePasskey(userID, passkeyID string) error {
+	passkey, err := s.passkeys.FindPasskey(passkeyID)
+	if err != nil {
+		return err
+	}
+	if passkey == nil || passkey.UserID != userID {
+		return domain.ErrPasskeyNotFound
+	}
+	if err := s.passkeys.DeletePasskey(passkeyID); err != nil {
+		return err
+	}
+
+	s.recordEvent(userID, domain.AuthEventPasskeyRemoved, "", passkey.Name)
+	return nil
+}
+
+// passkeyOwner adapts a user and their stored passkeys to webauthn.User
+func (s *AuthService) passkeyOwner(user *domain.User) (*passkeyUser, error) {
+	passkeys, err := s.passkeys.FindUserPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(passkeys))
+	for _, p := range passkeys {
+		id, err := base64.RawURLEncoding.DecodeString(p.ID)
+		if err != nil {
+			continue
+		}
+		flags := protocol.FlagUserPresent | protocol.FlagUserVerified
+		if p.BackupEligible {
+			flags |= protocol.FlagBackupEligible
+		}
+		if p.BackupState {
+			flags |= protocol.FlagBackupState
+		}
+		transports := make([]protocol.AuthenticatorTransport, len(p.Transports))
+		for i, t := range p.Transports {
+			transports[i] = protocol.AuthenticatorTransport(t)
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.NewCredentialFlags(flags),
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		})
+	}
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+type passkeyUser struct {
+	user        *domain.User
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID is the user handle the authenticator stores with the passkey;
+// the user ID, so a discoverable login leads straight back to the account
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+type ceremonyEntry struct {
+	userID    string // Empty for a login, where the user is not known yet
+	session   *webauthn.SessionData
+	expiresAt time.Time
+}
+
+// ceremonyStore holds the challenge of each registration or login between
+// its begin and finish calls. Entries are single-use.
+type ceremonyStore struct {
+	mu      sync.Mutex
+	pending map[string]*ceremonyEntry
+}
+
+func newCeremonyStore() *ceremonyStore {
+	return &ceremonyStore{pending: map[string]*ceremonyEntry{}}
+}
+
+// Put stores session and returns the token to finish it with
+func (c *ceremonyStore) Put(userID string, session *webauthn.SessionData) (string, error) {
+	token, err := randomToken(selectorBytes)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for t, entry := range c.pending {
+		if now.After(entry.expiresAt) {
+			delete(c.pending, t)
+		}
+	}
+	c.pending[token] = &ceremonyEntry{userID: userID, session: session, expiresAt: now.Add(ceremonyTTL)}
+	return token, nil
+}
+
+// Take removes and returns the session for token, which must have been
+// started by userID
+func (c *ceremonyStore) Take(token, userID string) (*webauthn.SessionData, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.pending[token]
+	if !found {
+		return nil, ErrInvalidCeremony
+	}
+	delete(c.pending, token)
+	if entry.userID != userID || time.Now().After(entry.expiresAt) {
+		return nil, ErrInvalidCeremony
+	}
+	return entry.session, nil
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+var b64 = base64.RawURLEncoding
+
+// softAuthenticator plays the part of a platform authenticator holding a
+// single discoverable credential, producing the responses a browser would
+// pass back from navigator.credentials.create() and .get()
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	counter      uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+// authData builds authenticator data with user presence and verification,
+// plus the attested credential when attest is set
+func (a *softAuthenticator) authData(t *testing.T, attest bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attest {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if !attest {
+		return data
+	}
+
+	cose, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, cose...)
+}
+
+func clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      testOrigin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Create answers a registration with a "none" attestation
+func (a *softAuthenticator) Create(t *testing.T, options interface{}) []byte {
+	t.Helper()
+	creation := options.(*protocol.CredentialCreation)
+	a.userHandle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+	a.counter++
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mustJSON(t, map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64.EncodeToString(clientData(t, "webauthn.create", creation.Response.Challenge.String())),
+			"attestationObject": b64.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+		"authenticatorAttachment": "platform",
+		"clientExtensionResults":  map[string]any{},
+	})
+}
+
+// Get answers a login challenge, signing with the current counter. Callers
+// bump the counter first, as a real authenticator does.
+func (a *softAuthenticator) Get(t *testing.T, options interface{}) []byte {
+	t.Helper()
+	assertion := options.(*protocol.CredentialAssertion)
+	authData := a.authData(t, false)
+	client := clientData(t, "webauthn.get", assertion.Response.Challenge.String())
+
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mustJSON(t, map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64.EncodeToString(client),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+		"authenticatorAttachment": "platform",
+		"clientExtensionResults":  map[string]any{},
+	})
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	user := &domain.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", Role: domain.RoleStaff, CreatedAt: time.Now()}
+	if err := store.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	authenticator := newSoftAuthenticator(t)
+
+	// Register
+	ceremony, err := service.BeginPasskeyRegistration(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkey, err := service.FinishPasskeyRegistration(user.ID, ceremony.Token, "Laptop", authenticator.Create(t, ceremony.Options))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	if passkey.ID != b64.EncodeToString(authenticator.credentialID) || passkey.UserID != user.ID || passkey.SignCount != 1 {
+		t.Fatalf("unexpected passkey %+v", passkey)
+	}
+
+	// The ceremony token is single-use
+	if _, err := service.FinishPasskeyRegistration(user.ID, ceremony.Token, "Laptop", authenticator.Create(t, ceremony.Options)); !errors.Is(err, ErrInvalidCeremony) {
+		t.Fatalf("reused registration token: got %v, want ErrInvalidCeremony", err)
+	}
+
+	// Log in
+	ceremony, err = service.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.counter = 5
+	resp, err := service.FinishPasskeyLogin(ceremony.Token, authenticator.Get(t, ceremony.Options), ClientInfo{})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if resp.User == nil || resp.User.ID != user.ID || resp.AccessToken == "" || resp.RefreshToken == "" {
+		t.Fatalf("unexpected login response %+v", resp)
+	}
+	stored, err := store.FindPasskey(passkey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SignCount != 5 || stored.LastUsedAt == nil {
+		t.Fatalf("counter not recorded: %+v", stored)
+	}
+
+	// A counter that does not move forward means a cloned key
+	ceremony, err = service.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishPasskeyLogin(ceremony.Token, authenticator.Get(t, ceremony.Options), ClientInfo{}); !errors.Is(err, ErrPasskeyCloned) {
+		t.Fatalf("replayed counter: got %v, want ErrPasskeyCloned", err)
+	}
+
+	// A different key under the same credential ID fails the signature check
+	forged := newSoftAuthenticator(t)
+	forged.credentialID = authenticator.credentialID
+	forged.userHandle = authenticator.userHandle
+	forged.counter = 10
+	ceremony, err = service.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishPasskeyLogin(ceremony.Token, forged.Get(t, ceremony.Options), ClientInfo{}); !errors.Is(err, ErrPasskeyRejected) {
+		t.Fatalf("forged signature: got %v, want ErrPasskeyRejected", err)
+	}
+}
+
+func TestPasskeyManagement(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	for _, u := range []*domain.User{
+		{ID: "user-1", Email: "ada@example.com", Role: domain.RoleStaff, CreatedAt: time.Now()},
+		{ID: "user-2", Email: "bob@example.com", Role: domain.RoleStaff, CreatedAt: time.Now()},
+	} {
+		if err := store.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authenticator := newSoftAuthenticator(t)
+	ceremony, err := service.BeginPasskeyRegistration("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another user cannot finish a ceremony they did not start
+	if _, err := service.FinishPasskeyRegistration("user-2", ceremony.Token, "", authenticator.Create(t, ceremony.Options)); !errors.Is(err, ErrInvalidCeremony) {
+		t.Fatalf("finished by another user: got %v, want ErrInvalidCeremony", err)
+	}
+
+	ceremony, err = service.BeginPasskeyRegistration("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkey, err := service.FinishPasskeyRegistration("user-1", ceremony.Token, "", authenticator.Create(t, ceremony.Options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passkey.Name != defaultPasskeyName {
+		t.Fatalf("name = %q, want %q", passkey.Name, defaultPasskeyName)
+	}
+
+	passkeys, err := service.ListPasskeys("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].ID != passkey.ID {
+		t.Fatalf("ListPasskeys = %+v", passkeys)
+	}
+
+	if err := service.DeletePasskey("user-2", passkey.ID); !errors.Is(err, domain.ErrPasskeyNotFound) {
+		t.Fatalf("delete by another user: got %v, want ErrPasskeyNotFound", err)
+	}
+	if err := service.DeletePasskey("user-1", passkey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if passkeys, _ := service.ListPasskeys("user-1"); len(passkeys) != 0 {
+		t.Fatalf("passkey still listed after delete: %+v", passkeys)
+	}
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/mail"
+	"github.com/stacklevest/backend/internal/password"
+)
+
+const (
+	resetTokenTTL       = time.Hour
+	resetResendInterval = time.Minute
+)
+
+var (
+	ErrWrongPassword     = domain.Forbidden("wrong_password", "current password is incorrect")
+	ErrInvalidResetToken = domain.Invalid("invalid_reset_token", "invalid or expired reset token")
+)
+
+func passwordMatches(user *domain.User, pw string) bool {
+	return password.Verify(user.Password, pw)
+}
+
+// upgradeLegacyPassword replaces a plaintext password with its bcrypt hash
+// after a successful login, reporting whether user changed. Plaintext
+// passwords were never held to any policy, so one that fails today's must
+// be replaced before the user gets a session.
+func (s *AuthService) upgradeLegacyPassword(user *domain.User, pw string) bool {
+	if password.IsHash(user.Password) {
+		return false
+	}
+	hashed, err := password.Hash(pw)
+	if err != nil {
+		return false
+	}
+	user.Password = hashed
+	if s.policy.Check(pw, nil) != nil {
+		user.MustResetPassword = true
+	}
+	return true
+}
+
+// ChangePassword sets a new password after checking the current one. It also
+// completes onboarding, which starts with choosing a password. Every session
+// except the caller's is signed out.
+func (s *AuthService) ChangePassword(userID, sessionID, currentPassword, newPassword string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return domain.ErrUserNotFound
+	}
+	if !passwordMatches(user, currentPassword) {
+		return ErrWrongPassword
+	}
+
+	if err := s.policy.Set(user, newPassword); err != nil {
+		return err
+	}
+	user.NeedsOnboarding = false
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventPasswordChanged, "", "")
+	return s.revokeOtherSessions(user.ID, sessionID)
+}
+
+// ForcePasswordReset makes the user choose a new password on their next
+// login and signs them out everywhere until they do
+func (s *AuthService) ForcePasswordReset(userID string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return domain.ErrUserNotFound
+	}
+
+	user.MustResetPassword = true
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventPasswordResetForced, "", "")
+	return s.RevokeAllSessions(user.ID)
+}
+
+// RequestPasswordReset emails a reset link. Unknown emails, and requests
+// repeated within resetResendInterval, succeed silently so the endpoint
+// reveals nothing about which accounts exist.
+func (s *AuthService) RequestPasswordReset(email string) error {
+	user, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user == nil || s.resets.IssuedWithin(user.ID, resetResendInterval) {
+		return nil
+	}
+
+	token, err := s.resets.Issue(user.ID)
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(s.config.AppURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your StackleVest password",
+		Text: "Someone asked to reset the password for your StackleVest account.\n\n" +
+			"Open this link to choose a new one:\n" + link + "\n\n" +
+			"The link expires in 1 hour. If this wasn't you, you can ignore this email.",
+		HTML: `<div style="font-family: sans-serif; max-width: 600px; margin: 0 auto;">
+	<h2>Reset your password</h2>
+	<p>Someone asked to reset the password for your StackleVest account.</p>
+	<p><a href="` + link + `" style="display: inline-block; background: #1d4ed8; color: #fff; padding: 12px 20px; border-radius: 8px; text-decoration: none;">Choose a new password</a></p>
+	<p>The link expires in 1 hour. If this wasn't you, you can ignore this email.</p>
+</div>`,
+	}
+
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("Failed to send password reset email to %s: %v", user.Email, err)
+		return err
+	}
+	return nil
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset
+// or a forced-reset login. It lifts any lockout and signs the user out
+// everywhere.
+func (s *AuthService) ResetPassword(token, newPassword string) error {
+	userID, err := s.resets.Lookup(token)
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidResetToken
+	}
+
+	// A rejected password leaves the token usable for another try
+	if err := s.policy.Set(user, newPassword); err != nil {
+		return err
+	}
+	if _, err := s.resets.Consume(token); err != nil {
+		return err
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventPasswordReset, "", "")
+	return s.RevokeAllSessions(user.ID)
+}
+
+type resetEntry struct {
+	userID       string
+	verifierHash string
+	issuedAt     time.Time
+	expiresAt    time.Time
+}
+
+// resetStore keeps pending password-reset tokens in memory, keyed by
+// selector like refresh tokens. A user has at most one pending token and
+// each token works once.
+type resetStore struct {
+	mu     sync.Mutex
+	tokens map[string]*resetEntry
+}
+
+func newResetStore() *resetStore {
+	return &resetStore{tokens: map[string]*resetEntry{}}
+}
+
+// Issue returns a new "<selector>.<verifier>" token for userID, replacing
+// any pending one
+func (r *resetStore) Issue(userID string) (string, error) {
+	selector, err := randomToken(selectorBytes)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomToken(verifierBytes)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for sel, entry := range r.tokens {
+		if entry.userID == userID || now.After(entry.expiresAt) {
+			delete(r.tokens, sel)
+		}
+	}
+	r.tokens[selector] = &resetEntry{
+		userID:       userID,
+		verifierHash: hashVerifier(verifier),
+		issuedAt:     now,
+		expiresAt:    now.Add(resetTokenTTL),
+	}
+	return selector + "." + verifier, nil
+}
+
+// IssuedWithin reports whether userID was sent a token less than d ago
+func (r *resetStore) IssuedWithin(userID string, d time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range r.tokens {
+		if entry.userID == userID && time.Since(entry.issuedAt) < d {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the user token was issued to, leaving it pending
+func (r *resetStore) Lookup(token string) (string, error) {
+	return r.find(token, false)
+}
+
+// Consume burns token and returns the user it was issued to
+func (r *resetStore) Consume(token string) (string, error) {
+	return r.find(token, true)
+}
+
+func (r *resetStore) find(token string, consume bool) (string, error) {
+	selector, verifier, ok := splitRefreshToken(token)
+	if !ok {
+		return "", ErrInvalidResetToken
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, found := r.tokens[selector]
+	if !found || !verifierMatches(entry.verifierHash, verifier) {
+		return "", ErrInvalidResetToken
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(r.tokens, selector)
+		return "", ErrInvalidResetToken
+	}
+	if consume {
+		delete(r.tokens, selector)
+	}
+	return entry.userID, nil
+}
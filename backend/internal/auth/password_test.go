@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/middleware"
+	"github.com/stacklevest/backend/internal/password"
+)
+
+const (
+	oldPassphrase = "correct horse battery staple"
+	newPassphrase = "tr0ubadour and three more words"
+)
+
+// newPasswordTestService returns a service holding Ada, whose password is
+// oldPassphrase, and the mailer it sends through
+func newPasswordTestService(t *testing.T) (*AuthService, *recordingMailer) {
+	t.Helper()
+	service, store := newTestService(t, testConfig())
+	mailer := &recordingMailer{}
+	service.mailer = mailer
+
+	hash, err := password.Hash(oldPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &domain.User{ID: "user-ada", Name: "Ada", Email: "ada@example.com", Password: hash, Role: domain.RoleStaff,
+		Status: domain.StatusActive, CreatedAt: time.Now()}
+	if err := store.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	return service, mailer
+}
+
+// requestReset asks for a reset link for Ada and returns its token
+func requestReset(t *testing.T, service *AuthService, mailer *recordingMailer) string {
+	t.Helper()
+	sent := len(mailer.sent)
+	if err := service.RequestPasswordReset("ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != sent+1 {
+		t.Fatalf("%d reset emails sent, want 1", len(mailer.sent)-sent)
+	}
+	_, rest, ok := strings.Cut(mailer.sent[sent].Text, "token=")
+	if !ok {
+		t.Fatalf("reset email has no link: %q", mailer.sent[sent].Text)
+	}
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func passwordIs(t *testing.T, service *AuthService, pw string) bool {
+	t.Helper()
+	u, err := service.repo.FindByID("user-ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return passwordMatches(u, pw)
+}
+
+// TestResetTokenWorksOnce resets the password with an emailed link, which
+// signs Ada out everywhere, and checks the link cannot be used again
+func TestResetTokenWorksOnce(t *testing.T) {
+	service, mailer := newPasswordTestService(t)
+	laptop := signIn(t, service, "user-ada", "Laptop")
+	token := requestReset(t, service, mailer)
+
+	if err := service.ResetPassword(token, newPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if !passwordIs(t, service, newPassphrase) {
+		t.Error("the new password does not work after the reset")
+	}
+	if _, err := service.ValidateAccessToken(laptop.accessToken); !errors.Is(err, domain.ErrTokenRevoked) {
+		t.Errorf("access token from before the reset: got %v, want %v", err, domain.ErrTokenRevoked)
+	}
+
+	if err := service.ResetPassword(token, oldPassphrase); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("second use of the link: got %v, want %v", err, ErrInvalidResetToken)
+	}
+	if !passwordIs(t, service, newPassphrase) {
+		t.Error("the second use of the link changed the password")
+	}
+}
+
+func TestResetTokenExpires(t *testing.T) {
+	service, mailer := newPasswordTestService(t)
+	token := requestReset(t, service, mailer)
+	selector, _, _ := splitRefreshToken(token)
+	service.resets.tokens[selector].expiresAt = time.Now().Add(-time.Second)
+
+	if err := service.ResetPassword(token, newPassphrase); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expired link: got %v, want %v", err, ErrInvalidResetToken)
+	}
+	if !passwordIs(t, service, oldPassphrase) {
+		t.Error("the expired link changed the password")
+	}
+}
+
+// TestChangePasswordSignsOutOtherDevices changes the password from one
+// device and checks that the others are signed out and it is not
+func TestChangePasswordSignsOutOtherDevices(t *testing.T) {
+	service, _ := newPasswordTestService(t)
+	laptop := signIn(t, service, "user-ada", "Laptop")
+	phone := signIn(t, service, "user-ada", "Phone")
+
+	if err := service.ChangePassword("user-ada", laptop.session.ID, "wrong", newPassphrase); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("wrong current password: got %v, want %v", err, ErrWrongPassword)
+	}
+	if err := service.ChangePassword("user-ada", laptop.session.ID, oldPassphrase, newPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if !passwordIs(t, service, newPassphrase) {
+		t.Error("the new password does not work after the change")
+	}
+
+	if _, err := service.ValidateAccessToken(laptop.accessToken); err != nil {
+		t.Errorf("the device the password was changed on: %v", err)
+	}
+	if _, err := service.Refresh(laptop.refreshToken, ClientInfo{}); err != nil {
+		t.Errorf("refreshing the device the password was changed on: %v", err)
+	}
+	if _, err := service.ValidateAccessToken(phone.accessToken); !errors.Is(err, domain.ErrTokenRevoked) {
+		t.Errorf("the other device's access token: got %v, want %v", err, domain.ErrTokenRevoked)
+	}
+	if _, err := service.Refresh(phone.refreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("the other device's refresh token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+// TestForgotPasswordRevealsNothing checks that asking for a reset link
+// answers the same for an unknown email as for an account, and only mails
+// the account
+func TestForgotPasswordRevealsNothing(t *testing.T) {
+	service, mailer := newPasswordTestService(t)
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	NewAuthHandler(service).RegisterRoutes(app, func(c *fiber.Ctx) error { return c.Next() })
+
+	forgot := func(email string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/forgot-password", strings.NewReader(`{"email": "`+email+`"}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(data)
+	}
+
+	knownStatus, knownBody := forgot("ada@example.com")
+	unknownStatus, unknownBody := forgot("nobody@example.com")
+	if knownStatus != fiber.StatusOK || unknownStatus != knownStatus || unknownBody != knownBody {
+		t.Errorf("unknown email: %d %s; account: %d %s; want both 200 and the same", unknownStatus, unknownBody, knownStatus, knownBody)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "ada@example.com" {
+		t.Errorf("sent %d emails, want one to Ada", len(mailer.sent))
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"math"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+const (
+	accessTokenTTL = 5 * time.Minute
+	jtiBytes       = 16
+)
+
+var ErrInvalidToken = domain.Unauthorized("invalid_token", "invalid or expired token")
+
+// ValidateAccessToken checks the token's signature and expiry, then that it
+// has not been revoked: neither denylisted by jti nor issued before the
+// user's tokens-invalid-before cut-off. A token whose user or session no
+// longer exists is revoked too.
+func (s *AuthService) ValidateAccessToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := s.keys.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["id"].(string)
+	iat, ok := issuedAt(claims)
+	if jti == "" || userID == "" || !ok {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := s.revoked.IsTokenRevoked(jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, domain.ErrTokenRevoked
+	}
+
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, domain.ErrTokenRevoked
+	}
+	// A token from the same microsecond as the cut-off counts as issued
+	// before it
+	if user.TokensInvalidBefore != nil && !iat.After(user.TokensInvalidBefore.Truncate(time.Microsecond)) {
+		return nil, domain.ErrTokenRevoked
+	}
+
+	// Tokens die with their session, so signing a device out takes effect
+	// immediately rather than when its last access token expires
+	if sid, _ := claims["sid"].(string); sid != "" {
+		sess, err := s.repo.FindSessionByID(sid)
+		if err != nil {
+			return nil, err
+		}
+		if sess == nil {
+			return nil, domain.ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+// issuedAt reads iat to the microsecond, as generateAccessToken writes it;
+// jwt's NumericDate would round it to whole seconds
+func issuedAt(claims jwt.MapClaims) (time.Time, bool) {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(iat)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e6))*int64(time.Microsecond)), true
+}
+
+// RevokeAccessToken denylists a single token until it expires. Tokens that
+// are already invalid need no entry.
+func (s *AuthService) RevokeAccessToken(tokenString string) error {
+	token, err := s.keys.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return nil
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	jti, _ := claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if jti == "" || err != nil || exp == nil {
+		return nil
+	}
+	userID, _ := claims["id"].(string)
+
+	return s.revoked.RevokeToken(&domain.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: exp.Time,
+	})
+}
+
+// InvalidateUserTokens rejects every access token issued to the user so far
+func (s *AuthService) InvalidateUserTokens(userID string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return domain.ErrUserNotFound
+	}
+
+	now := time.Now()
+	user.TokensInvalidBefore = &now
+	return s.repo.Update(user)
+}
@@ -2,84 +2,205 @@ package auth
 
 import (
 	"errors"
+	"log"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 	"github.com/stacklevest/backend/internal/config"
 	"github.com/stacklevest/backend/internal/domain"
-	"github.com/stacklevest/backend/internal/storage"
+	"github.com/stacklevest/backend/internal/keyring"
+	"github.com/stacklevest/backend/internal/mail"
+	"github.com/stacklevest/backend/internal/password"
 )
 
+var (
+	ErrInvalidCredentials  = domain.Unauthorized("invalid_credentials", "invalid credentials")
+	ErrOTPNotSent          = domain.Upstream("otp_not_sent", "failed to send OTP email")
+	ErrInvalidRefreshToken = domain.Unauthorized("invalid_refresh_token", "invalid or expired refresh token")
+	ErrRefreshTokenReused  = domain.Unauthorized("refresh_token_reused", "refresh token reuse detected")
+)
+
+// rotatedSessionRetention is how long a rotated session is kept so that its
+// refresh token coming back is caught as reuse; after that it is deleted and
+// the token is merely unknown
+const rotatedSessionRetention = 24 * time.Hour
+
+// Provisioner creates accounts the way the user directory does, so that
+// invited and single sign-on users get the same checks and a staff number
+type Provisioner interface {
+	Provision(user *domain.User) error
+}
+
 type AuthService struct {
-	repo   domain.UserRepository
-	config *config.Config
+	repo     domain.UserRepository
+	users    Provisioner
+	events   domain.AuthEventRepository
+	revoked  domain.RevokedTokenRepository
+	passkeys domain.PasskeyRepository
+	tokens   domain.APITokenRepository
+	invites  domain.InvitationRepository
+	mailer   mail.Mailer
+	keys     *keyring.KeyRing
+	policy   *password.Policy
+	webauthn *webauthn.WebAuthn
+	oidc     *OIDCProvider // Nil when single sign-on is off
+	otps     *otpStore
+	resets   *resetStore
+	config   *config.Config
+
+	challenges *challengeStore
+	ceremonies *ceremonyStore
+	ssoFlows   *ssoFlowStore
+	ipFailures *ipThrottle
 }
 
-func NewAuthService(repo domain.UserRepository, cfg *config.Config) *AuthService {
+func NewAuthService(repo domain.UserRepository, events domain.AuthEventRepository, revoked domain.RevokedTokenRepository,
+	passkeys domain.PasskeyRepository, tokens domain.APITokenRepository, invites domain.InvitationRepository,
+	users Provisioner, mailer mail.Mailer, keys *keyring.KeyRing, policy *password.Policy, rp *webauthn.WebAuthn, sso *OIDCProvider,
+	cfg *config.Config) *AuthService {
 	return &AuthService{
-		repo:   repo,
-		config: cfg,
+		repo:     repo,
+		users:    users,
+		events:   events,
+		revoked:  revoked,
+		passkeys: passkeys,
+		tokens:   tokens,
+		invites:  invites,
+		mailer:   mailer,
+		keys:     keys,
+		policy:   policy,
+		webauthn: rp,
+		oidc:     sso,
+		otps:     newOTPStore(),
+		resets:   newResetStore(),
+		config:   cfg,
+
+		challenges: newChallengeStore(),
+		ceremonies: newCeremonyStore(),
+		ssoFlows:   newSSOFlowStore(),
+		ipFailures: newIPThrottle(),
 	}
 }
 
+// ClientInfo describes the device a request came from; it is recorded on
+// new sessions so users can recognise them later
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// LoginResponse is the outcome of a login step; handlers send it as a
+// dto.LoginResponse
 type LoginResponse struct {
-	User         *domain.User `json:"user,omitempty"`
-	AccessToken  string       `json:"accessToken,omitempty"`
-	RefreshToken string       `json:"refreshToken,omitempty"` // For the first login response if needed
-	RequiresOTP  bool         `json:"requiresOtp,omitempty"`
+	User         *domain.User
+	AccessToken  string
+	RefreshToken string // For the first login response if needed
+	RequiresOTP  bool
+
+	RequiresPasswordReset bool
+	ResetToken            string // For POST /api/auth/reset-password
+
+	// The password was right but a second factor is still needed; the
+	// client finishes the login with POST /api/auth/2fa/verify
+	RequiresSecondFactor bool
+	SecondFactor         string // SecondFactorTOTP or SecondFactorTOTPEnrollment
+	SecondFactorToken    string
 }
 
-func (s *AuthService) Login(email, password string) (*LoginResponse, error) {
+// Login checks the password and, for users still onboarding, the emailed
+// OTP. Called without otp for such a user it sends a code and returns
+// RequiresOTP; the client then repeats the call with the code. Users with
+// TOTP enabled, or whose role requires it, get RequiresSecondFactor instead
+// of a session.
+func (s *AuthService) Login(email, password, otp string, client ClientInfo) (*LoginResponse, error) {
+	if s.ipFailures.Blocked(client.IP) {
+		return nil, ErrTooManyAttempts
+	}
+
 	// 1. Find User
 	user, err := s.repo.FindByEmail(email)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, errors.New("invalid credentials")
+		s.ipFailures.Fail(client.IP)
+		return nil, ErrInvalidCredentials
 	}
 
-	// 2. Check Password
-	// Check if stored password is a bcrypt hash (starts with $2a$)
-	isHashed := len(user.Password) >= 4 && user.Password[:4] == "$2a$"
+	// A locked account is refused before the password is even looked at
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, &AccountLockedError{Until: *user.LockedUntil}
+	}
 
-	if isHashed {
-		// Verify Hash
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-			return nil, errors.New("invalid credentials")
-		}
-	} else {
-		// Legacy Plaintext Check
-		if user.Password != password {
-			return nil, errors.New("invalid credentials")
+	// 2. Check Password
+	if !passwordMatches(user, password) {
+		s.ipFailures.Fail(client.IP)
+		if lockErr := s.recordLoginFailure(user); lockErr != nil {
+			return nil, lockErr
 		}
-		
-		// 3. Auto-Upgrade to Bcrypt
-		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-		if err == nil {
-			user.Password = string(hashedBytes)
-			if err := s.repo.Update(user); err != nil {
-				// Log error but continue login
-				// In production, use a proper logger
-			}
+		return nil, ErrInvalidCredentials
+	}
+
+	dirty := user.FailedLoginAttempts > 0 || user.LockedUntil != nil
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+
+	// 3. Auto-Upgrade to Bcrypt
+	if s.upgradeLegacyPassword(user, password) {
+		dirty = true
+	}
+
+	if dirty {
+		if err := s.repo.Update(user); err != nil {
+			// Log error but continue login
+			log.Printf("Failed to update user %s after login: %v", user.ID, err)
 		}
 	}
 
+	if user.MustResetPassword {
+		return s.passwordResetResponse(user)
+	}
+
 	// 4. Check Onboarding
 	if user.NeedsOnboarding {
+		if otp == "" {
+			if err := s.sendOTP(user); err != nil && !errors.Is(err, ErrOTPTooSoon) {
+				return nil, err
+			}
+			return &LoginResponse{
+				RequiresOTP: true,
+			}, nil
+		}
+		if err := s.otps.Verify(user.Email, otp); err != nil {
+			return nil, err
+		}
+	}
+
+	// 5. Second factor
+	if user.TOTPEnabled || s.secondFactorRequired(user) {
+		factor := SecondFactorTOTP
+		if !user.TOTPEnabled {
+			factor = SecondFactorTOTPEnrollment
+		}
+		token, err := s.challenges.Issue(user.ID, factor, client)
+		if err != nil {
+			return nil, err
+		}
 		return &LoginResponse{
-			RequiresOTP: true,
+			RequiresSecondFactor: true,
+			SecondFactor:         factor,
+			SecondFactorToken:    token,
 		}, nil
 	}
 
-	// 5. Generate Tokens
-	accessToken, err := s.generateAccessToken(user)
+	// 6. Generate Tokens
+	session, refreshToken, err := s.createSession(user.ID, client, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := s.createSession(user.ID)
+	accessToken, err := s.generateAccessToken(user, session.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -91,45 +212,125 @@ func (s *AuthService) Login(email, password string) (*LoginResponse, error) {
 	}, nil
 }
 
-func (s *AuthService) Refresh(refreshToken string) (*LoginResponse, error) {
-	// Find session (In a real DB this would be indexed, here we iterate)
-	// For rotation, we might need the actual token to find it, but it's hashed.
-	// So we find by UserID? No, we don't have it.
-	// We'll have to iterate all sessions and bcrypt compare.
-	
-	sessions, err := s.repo.(*storage.JSONStore).FindAllSessions() // I may need to add this to repo interface or cast
+// passwordResetResponse answers a login for an account with an admin-forced
+// reset: the credentials were right, but instead of a session the client
+// gets a token that can only set a new password
+func (s *AuthService) passwordResetResponse(user *domain.User) (*LoginResponse, error) {
+	resetToken, err := s.resets.Issue(user.ID)
 	if err != nil {
 		return nil, err
 	}
+	return &LoginResponse{
+		RequiresPasswordReset: true,
+		ResetToken:            resetToken,
+	}, nil
+}
 
-	var foundSession *domain.UserSession
-	for _, sess := range sessions {
-		if err := bcrypt.CompareHashAndPassword([]byte(sess.RefreshToken), []byte(refreshToken)); err == nil {
-			foundSession = &sess
-			break
-		}
+// RequestOTP (re)sends a login code. Unknown emails and users that do not
+// need one succeed silently so the endpoint cannot be used to probe accounts.
+func (s *AuthService) RequestOTP(email string) error {
+	user, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user == nil || !user.NeedsOnboarding {
+		return nil
+	}
+	return s.sendOTP(user)
+}
+
+func (s *AuthService) sendOTP(user *domain.User) error {
+	code, err := s.otps.Issue(user.Email)
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Your StackleVest Login Code",
+		Text:    "Your one-time password is: " + code + "\n\nThis code will expire in 5 minutes.",
+		HTML: `<div style="font-family: sans-serif; max-width: 600px; margin: 0 auto;">
+	<h2>Login Verification</h2>
+	<p>Your one-time password is:</p>
+	<h1 style="background: #f4f4f5; padding: 20px; text-align: center; letter-spacing: 5px; border-radius: 8px;">` + code + `</h1>
+	<p>This code will expire in 5 minutes.</p>
+</div>`,
+	}
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("Failed to send OTP email to %s: %v", user.Email, err)
+		return ErrOTPNotSent
+	}
+	return nil
+}
+
+func (s *AuthService) Refresh(refreshToken string, client ClientInfo) (*LoginResponse, error) {
+	// Single indexed lookup by selector, then a constant-time verifier check
+	foundSession, err := s.findSession(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if foundSession == nil {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	if foundSession == nil || foundSession.ExpiresAt.Before(time.Now()) {
-		return nil, errors.New("invalid or expired refresh token")
+	// A rotated token coming back means two parties hold the same family:
+	// revoke all of it so the thief's copy dies with the victim's.
+	if foundSession.RotatedAt != nil {
+		s.revokeFamily(foundSession)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if foundSession.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidRefreshToken
 	}
 
 	// Token is valid. ROTATE.
 	user, err := s.repo.FindByID(foundSession.UserID)
-	if err != nil || user == nil {
-		return nil, errors.New("user not found")
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	// Delete old session
-	s.repo.DeleteSession(foundSession.ID)
+	// Keep the old session, marked as rotated, so a replay is recognised.
+	// Marking it is the check: of two requests racing with one token, only
+	// one rotates it, and the loser is treated as the replay it is.
+	rotated, err := s.repo.RotateSession(foundSession.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		s.revokeFamily(foundSession)
+		return nil, ErrRefreshTokenReused
+	}
 
 	// Create new session
-	newAccessToken, err := s.generateAccessToken(user)
+	newSession, newRefreshToken, err := s.createSession(user.ID, client, foundSession)
+	if err != nil {
+		return nil, err
+	}
+
+	// A loser of the race may have revoked the family before the new
+	// session existed; its predecessor being gone gives that away
+	prev, err := s.repo.FindSessionByID(foundSession.ID)
 	if err != nil {
 		return nil, err
 	}
+	if prev == nil {
+		if err := s.repo.DeleteSessionFamily(newSession.FamilyID); err != nil {
+			log.Printf("Failed to revoke session family %s: %v", newSession.FamilyID, err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	// Without pruning, every rotation would leave a row behind for good
+	if err := s.repo.DeleteStaleSessions(foundSession.FamilyID, time.Now().Add(-rotatedSessionRetention)); err != nil {
+		log.Printf("Failed to prune sessions of family %s: %v", foundSession.FamilyID, err)
+	}
 
-	newRefreshToken, err := s.createSession(user.ID)
+	newAccessToken, err := s.generateAccessToken(user, newSession.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -141,49 +342,144 @@ func (s *AuthService) Refresh(refreshToken string) (*LoginResponse, error) {
 	}, nil
 }
 
-func (s *AuthService) Logout(refreshToken string) error {
-	// Find and delete session
-	sessions, _ := s.repo.(*storage.JSONStore).FindAllSessions()
-	for _, sess := range sessions {
-		if err := bcrypt.CompareHashAndPassword([]byte(sess.RefreshToken), []byte(refreshToken)); err == nil {
-			return s.repo.DeleteSession(sess.ID)
+// Logout ends the session behind refreshToken and, if given, denylists the
+// access token the client was still holding
+func (s *AuthService) Logout(refreshToken, accessToken string) error {
+	if accessToken != "" {
+		if err := s.RevokeAccessToken(accessToken); err != nil {
+			log.Printf("Failed to revoke access token on logout: %v", err)
 		}
 	}
-	return nil
+	if refreshToken == "" {
+		return nil
+	}
+
+	// Find and delete session
+	sess, err := s.findSession(refreshToken)
+	if err != nil || sess == nil {
+		return err
+	}
+	// Drop the rotated ancestors too; they are of no further use
+	if sess.FamilyID == "" {
+		return s.repo.DeleteSession(sess.ID)
+	}
+	return s.repo.DeleteSessionFamily(sess.FamilyID)
 }
 
-func (s *AuthService) createSession(userID string) (string, error) {
-	// Actually, just using a UUID or a long random string is fine.
-	refreshToken := domain.GenerateRandomString(32) 
-	
-	hashedToken, err := bcrypt.GenerateFromPassword([]byte(refreshToken), bcrypt.DefaultCost)
+// revokeFamily deletes every session descended from the same login as sess
+// and records the reuse for the security log
+func (s *AuthService) revokeFamily(sess *domain.UserSession) {
+	var err error
+	if sess.FamilyID == "" {
+		err = s.repo.DeleteSession(sess.ID)
+	} else {
+		err = s.repo.DeleteSessionFamily(sess.FamilyID)
+	}
 	if err != nil {
-		return "", err
+		log.Printf("Failed to revoke session family %s: %v", sess.FamilyID, err)
 	}
 
+	s.recordEvent(sess.UserID, domain.AuthEventRefreshTokenReuse, sess.FamilyID,
+		"rotated session "+sess.ID+" presented again; family revoked")
+}
+
+// recordEvent appends to the auth event log. Failures are logged rather than
+// returned: the action being audited has already happened.
+func (s *AuthService) recordEvent(userID, eventType, familyID, detail string) {
+	event := &domain.AuthEvent{
+		ID:        domain.GenerateID("evt"),
+		UserID:    userID,
+		Type:      eventType,
+		FamilyID:  familyID,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+	if err := s.events.CreateAuthEvent(event); err != nil {
+		log.Printf("Failed to record auth event for user %s: %v", userID, err)
+	}
+}
+
+// findSession resolves a "<selector>.<verifier>" refresh token to its
+// session, or nil if the token is malformed or does not match
+func (s *AuthService) findSession(refreshToken string) (*domain.UserSession, error) {
+	selector, verifier, ok := splitRefreshToken(refreshToken)
+	if !ok {
+		return nil, nil
+	}
+
+	sess, err := s.repo.FindSessionBySelector(selector)
+	if err != nil || sess == nil {
+		return nil, err
+	}
+	if !verifierMatches(sess.RefreshToken, verifier) {
+		return nil, nil
+	}
+	return sess, nil
+}
+
+// createSession starts a new session. prev is nil for a fresh login; when
+// rotating it is the session being replaced, whose family and device
+// details carry over so the device keeps one identity across rotations.
+func (s *AuthService) createSession(userID string, client ClientInfo, prev *domain.UserSession) (*domain.UserSession, string, error) {
+	selector, err := randomToken(selectorBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	verifier, err := randomToken(verifierBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
 	session := &domain.UserSession{
 		ID:           domain.GenerateID("sess"),
 		UserID:       userID,
-		RefreshToken: string(hashedToken),
-		ExpiresAt:    time.Now().Add(time.Hour * 24 * 7), // 7 days
-		CreatedAt:    time.Now(),
+		Selector:     selector,
+		RefreshToken: hashVerifier(verifier),
+		FamilyID:     domain.GenerateID("fam"),
+		IP:           client.IP,
+		UserAgent:    client.UserAgent,
+		LastUsedAt:   now,
+		ExpiresAt:    now.Add(time.Hour * 24 * 7), // 7 days
+		CreatedAt:    now,
+	}
+	if prev != nil {
+		if prev.FamilyID != "" {
+			session.FamilyID = prev.FamilyID
+		}
+		session.IP = prev.IP
+		session.UserAgent = prev.UserAgent
+		session.CreatedAt = prev.CreatedAt
 	}
 
 	if err := s.repo.CreateSession(session); err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	return refreshToken, nil
+	return session, selector + "." + verifier, nil
 }
 
-func (s *AuthService) generateAccessToken(user *domain.User) (string, error) {
+func (s *AuthService) generateAccessToken(user *domain.User, sessionID string) (string, error) {
+	jti, err := randomToken(jtiBytes)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"id":    user.ID,
 		"email": user.Email,
 		"role":  user.Role,
-		"exp":   time.Now().Add(time.Minute * 5).Unix(), // 5 minutes as requested
+		"sid":   sessionID,
+		"jti":   jti,
+		"iat":   float64(now.UnixMicro()) / 1e6, // Sub-second, see issuedAt
+		"exp":   now.Add(accessTokenTTL).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.config.JWTSecret))
+	return s.keys.Sign(claims)
+}
+
+// JWKS returns the public keys access tokens can be verified with
+func (s *AuthService) JWKS() keyring.JWKSet {
+	return s.keys.JWKS()
 }
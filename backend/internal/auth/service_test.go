@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/keyring"
+	"github.com/stacklevest/backend/internal/mail"
+	"github.com/stacklevest/backend/internal/password"
+	"github.com/stacklevest/backend/internal/storage"
+	"github.com/stacklevest/backend/internal/user"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		AppURL:            testOrigin,
+		WebAuthnRPID:      testRPID,
+		WebAuthnOrigins:   []string{testOrigin},
+		StaffNumberPrefix: "SLV-",
+		StaffNumberStart:  1,
+		StaffNumberDigits: 4,
+	}
+}
+
+// newTestService wires an AuthService to a fresh SQLite database and key
+// directory. Single sign-on is enabled when cfg names an issuer.
+func newTestService(t *testing.T, cfg *config.Config) (*AuthService, *storage.SQLiteStore) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.NewSQLiteStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	keys, err := keyring.Load(filepath.Join(dir, "keys"), "", keyring.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := NewRelyingParty(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sso, err := NewOIDCProvider(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := user.NewUserService(store, store, &password.Policy{}, cfg)
+	service := NewAuthService(store, store, store, store, store, store, users, mail.NewLogMailer(), keys, &password.Policy{}, rp, sso, cfg)
+	return service, store
+}
+
+// newTestServiceOn is newTestService on the named store, "sqlite" or "json"
+func newTestServiceOn(t *testing.T, name string) *AuthService {
+	t.Helper()
+	service, _ := newTestService(t, testConfig())
+	if name == "json" {
+		store := storage.NewJSONStore(filepath.Join(t.TempDir(), "db.json"))
+		t.Cleanup(func() { store.Close() })
+		users := user.NewUserService(store, store, &password.Policy{}, testConfig())
+		service = NewAuthService(store, store, store, store, store, store, users, mail.NewLogMailer(),
+			service.keys, &password.Policy{}, service.webauthn, nil, testConfig())
+	}
+	return service
+}
+
+// racingRepo holds every FindSessionBySelector until n callers have read
+// the session, so they all go on to rotate the same unrotated row
+type racingRepo struct {
+	domain.UserRepository
+
+	mu      sync.Mutex
+	waiting int
+	release chan struct{}
+}
+
+func (r *racingRepo) FindSessionBySelector(selector string) (*domain.UserSession, error) {
+	sess, err := r.UserRepository.FindSessionBySelector(selector)
+	r.mu.Lock()
+	if r.waiting--; r.waiting == 0 {
+		close(r.release)
+	}
+	r.mu.Unlock()
+	<-r.release
+	return sess, err
+}
+
+// TestRefreshRotatesOnce races one refresh token against itself: at most
+// one request may rotate it, and the others count as reuse and revoke the
+// family, the winner's new session included
+func TestRefreshRotatesOnce(t *testing.T) {
+	for _, name := range []string{"sqlite", "json"} {
+		t.Run(name, func(t *testing.T) {
+			service := newTestServiceOn(t, name)
+
+			user := &domain.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", Role: domain.RoleStaff, CreatedAt: time.Now()}
+			if err := service.repo.Create(user); err != nil {
+				t.Fatal(err)
+			}
+			_, token, err := service.createSession(user.ID, ClientInfo{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const racers = 8
+			service.repo = &racingRepo{UserRepository: service.repo, waiting: racers, release: make(chan struct{})}
+			var (
+				wg        sync.WaitGroup
+				mu        sync.Mutex
+				rotated   []string
+				reuseErrs int
+			)
+			for range racers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := service.Refresh(token, ClientInfo{})
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						rotated = append(rotated, resp.RefreshToken)
+					case errors.Is(err, ErrRefreshTokenReused):
+						reuseErrs++
+					default:
+						t.Errorf("Refresh: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// The winner learns of the reuse too if a loser revoked the
+			// family before its new session was stored
+			if len(rotated) > 1 || reuseErrs != racers-len(rotated) {
+				t.Fatalf("%d rotations and %d reuses, want at most 1 and the rest reuses", len(rotated), reuseErrs)
+			}
+			for _, token := range rotated {
+				if _, err := service.Refresh(token, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+					t.Fatalf("refresh after reuse: got %v, want the family revoked", err)
+				}
+			}
+		})
+	}
+}
+
+// TestRefreshPrunesSessions refreshes a session over and over, each time
+// after its rotated predecessors have aged past the reuse window, and checks
+// that they and other users' expired sessions are deleted along the way
+func TestRefreshPrunesSessions(t *testing.T) {
+	for _, name := range []string{"sqlite", "json"} {
+		t.Run(name, func(t *testing.T) {
+			service := newTestServiceOn(t, name)
+			for _, id := range []string{"user-1", "user-2"} {
+				u := &domain.User{ID: id, Name: id, Email: id + "@example.com", Role: domain.RoleStaff, CreatedAt: time.Now()}
+				if err := service.repo.Create(u); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expired, _, err := service.createSession("user-2", ClientInfo{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expired.ExpiresAt = time.Now().Add(-time.Minute)
+			if err := service.repo.UpdateSession(expired); err != nil {
+				t.Fatal(err)
+			}
+
+			_, token, err := service.createSession("user-1", ClientInfo{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var previous string
+			for range 20 {
+				sessions, err := service.repo.FindUserSessions("user-1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, sess := range sessions {
+					if sess.RotatedAt != nil {
+						aged := time.Now().Add(-rotatedSessionRetention - time.Minute)
+						sess.RotatedAt = &aged
+						if err := service.repo.UpdateSession(&sess); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				resp, err := service.Refresh(token, ClientInfo{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				previous, token = token, resp.RefreshToken
+			}
+
+			if sessions, _ := service.repo.FindUserSessions("user-1"); len(sessions) != 2 {
+				t.Errorf("%d sessions after 20 refreshes, want the active one and its predecessor", len(sessions))
+			}
+			if sessions, _ := service.repo.FindUserSessions("user-2"); len(sessions) != 0 {
+				t.Errorf("expired session of another user was kept")
+			}
+			if _, err := service.Refresh(previous, ClientInfo{}); !errors.Is(err, ErrRefreshTokenReused) {
+				t.Errorf("replaying the predecessor: got %v, want %v", err, ErrRefreshTokenReused)
+			}
+		})
+	}
+}
+
+// TestCutOffSparesLaterTokens signs a user out everywhere and checks that
+// a token issued right after, within the same second, still works
+func TestCutOffSparesLaterTokens(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	u := &domain.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", Role: domain.RoleStaff, Status: domain.StatusActive}
+	if err := store.Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := service.generateAccessToken(u, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.InvalidateUserTokens(u.ID); err != nil {
+		t.Fatal(err)
+	}
+	after, err := service.generateAccessToken(u, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.ValidateAccessToken(before); !errors.Is(err, domain.ErrTokenRevoked) {
+		t.Errorf("token from before the cut-off: got %v, want %v", err, domain.ErrTokenRevoked)
+	}
+	if _, err := service.ValidateAccessToken(after); err != nil {
+		t.Errorf("token from after the cut-off: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// ListSessions returns the user's signed-in devices: one active session per
+// login, without the rotated predecessors or any token material
+func (s *AuthService) ListSessions(userID string) ([]domain.UserSession, error) {
+	sessions, err := s.repo.FindUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := []domain.UserSession{}
+	for _, sess := range sessions {
+		if sess.Active(now) {
+			sess.Sanitize()
+			active = append(active, sess)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession signs the device out by deleting the session's whole family.
+// Sessions belonging to another user are reported as not found.
+func (s *AuthService) RevokeSession(userID, sessionID string) error {
+	sessions, err := s.repo.FindUserSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, sess := range sessions {
+		if sess.ID == sessionID {
+			if sess.FamilyID == "" {
+				return s.repo.DeleteSession(sess.ID)
+			}
+			return s.repo.DeleteSessionFamily(sess.FamilyID)
+		}
+	}
+	return domain.ErrSessionNotFound
+}
+
+// RevokeAllSessions signs the user out everywhere: no session can be
+// refreshed and every access token already issued stops working
+func (s *AuthService) RevokeAllSessions(userID string) error {
+	if err := s.repo.DeleteUserSessions(userID); err != nil {
+		return err
+	}
+	return s.InvalidateUserTokens(userID)
+}
+
+// revokeOtherSessions signs out every device except the one sessionID
+// belongs to
+func (s *AuthService) revokeOtherSessions(userID, sessionID string) error {
+	sessions, err := s.repo.FindUserSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	keepFamily := ""
+	for _, sess := range sessions {
+		if sess.ID == sessionID {
+			keepFamily = sess.FamilyID
+		}
+	}
+
+	revoked := map[string]bool{}
+	for _, sess := range sessions {
+		switch {
+		case sess.ID == sessionID, keepFamily != "" && sess.FamilyID == keepFamily:
+			continue
+		case sess.FamilyID == "":
+			err = s.repo.DeleteSession(sess.ID)
+		case !revoked[sess.FamilyID]:
+			revoked[sess.FamilyID] = true
+			err = s.repo.DeleteSessionFamily(sess.FamilyID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
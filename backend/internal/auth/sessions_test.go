@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// signedIn is a device signed in with createSession
+type signedIn struct {
+	session      *domain.UserSession
+	refreshToken string
+	accessToken  string
+}
+
+// newSessionTestService returns a service holding Ada and Bob
+func newSessionTestService(t *testing.T) *AuthService {
+	t.Helper()
+	service, store := newTestService(t, testConfig())
+	for _, u := range []*domain.User{
+		{ID: "user-ada", Name: "Ada", Email: "ada@example.com", Role: domain.RoleStaff},
+		{ID: "user-bob", Name: "Bob", Email: "bob@example.com", Role: domain.RoleStaff},
+	} {
+		u.Status, u.CreatedAt = domain.StatusActive, time.Now()
+		if err := store.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return service
+}
+
+func signIn(t *testing.T, service *AuthService, userID, userAgent string) signedIn {
+	t.Helper()
+	sess, refreshToken, err := service.createSession(userID, ClientInfo{IP: "203.0.113.7", UserAgent: userAgent}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := service.repo.FindByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := service.generateAccessToken(u, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedIn{session: sess, refreshToken: refreshToken, accessToken: accessToken}
+}
+
+func sessionIDs(t *testing.T, service *AuthService, userID string) map[string]bool {
+	t.Helper()
+	sessions, err := service.ListSessions(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for _, sess := range sessions {
+		if sess.Selector != "" || sess.RefreshToken != "" {
+			t.Errorf("session %s is listed with its token material", sess.ID)
+		}
+		ids[sess.ID] = true
+	}
+	return ids
+}
+
+// TestListSessions lists one entry per device: the latest session of a
+// refreshed login, and neither expired sessions nor other users'
+func TestListSessions(t *testing.T) {
+	service := newSessionTestService(t)
+	laptop := signIn(t, service, "user-ada", "Laptop")
+	phone := signIn(t, service, "user-ada", "Phone")
+	signIn(t, service, "user-bob", "Desktop")
+	stale := signIn(t, service, "user-ada", "Old tablet")
+	stale.session.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := service.repo.UpdateSession(stale.session); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := service.Refresh(laptop.refreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := service.findSession(refreshed.RefreshToken)
+	if err != nil || rotated == nil {
+		t.Fatalf("session of the refreshed token: %v, %v", rotated, err)
+	}
+
+	want := map[string]bool{rotated.ID: true, phone.session.ID: true}
+	if got := sessionIDs(t, service, "user-ada"); len(got) != len(want) || !got[rotated.ID] || !got[phone.session.ID] {
+		t.Errorf("Ada's sessions = %v, want %v", got, want)
+	}
+}
+
+// TestRevokeSession signs one device out: its refresh and access tokens
+// stop working at once, the other device is untouched, and nobody else can
+// revoke it
+func TestRevokeSession(t *testing.T) {
+	service := newSessionTestService(t)
+	laptop := signIn(t, service, "user-ada", "Laptop")
+	phone := signIn(t, service, "user-ada", "Phone")
+
+	if err := service.RevokeSession("user-bob", laptop.session.ID); !errors.Is(err, domain.ErrSessionNotFound) {
+		t.Fatalf("Bob revoking Ada's session: got %v, want %v", err, domain.ErrSessionNotFound)
+	}
+	if _, err := service.ValidateAccessToken(laptop.accessToken); err != nil {
+		t.Fatalf("Ada's token after Bob's attempt: %v", err)
+	}
+
+	// Revoking the rotated session takes its predecessor along
+	refreshed, err := service.Refresh(laptop.refreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := service.ValidateAccessToken(refreshed.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RevokeSession("user-ada", claims["sid"].(string)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"first": laptop.accessToken, "refreshed": refreshed.AccessToken} {
+		if _, err := service.ValidateAccessToken(token); !errors.Is(err, domain.ErrTokenRevoked) {
+			t.Errorf("the laptop's %s access token: got %v, want %v", name, err, domain.ErrTokenRevoked)
+		}
+	}
+	if _, err := service.Refresh(refreshed.RefreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("the laptop's refresh token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, err := service.ValidateAccessToken(phone.accessToken); err != nil {
+		t.Errorf("the phone's access token: %v", err)
+	}
+	if got := sessionIDs(t, service, "user-ada"); len(got) != 1 || !got[phone.session.ID] {
+		t.Errorf("Ada's sessions = %v, want only the phone's", got)
+	}
+}
+
+// TestRevokeAllSessions signs a user out everywhere without touching other
+// users' sessions
+func TestRevokeAllSessions(t *testing.T) {
+	service := newSessionTestService(t)
+	devices := []signedIn{signIn(t, service, "user-ada", "Laptop"), signIn(t, service, "user-ada", "Phone")}
+	bob := signIn(t, service, "user-bob", "Desktop")
+
+	if err := service.RevokeAllSessions("user-ada"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range devices {
+		if _, err := service.ValidateAccessToken(d.accessToken); !errors.Is(err, domain.ErrTokenRevoked) {
+			t.Errorf("%s access token: got %v, want %v", d.session.UserAgent, err, domain.ErrTokenRevoked)
+		}
+		if _, err := service.Refresh(d.refreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("%s refresh token: got %v, want %v", d.session.UserAgent, err, ErrInvalidRefreshToken)
+		}
+	}
+	if got := sessionIDs(t, service, "user-ada"); len(got) != 0 {
+		t.Errorf("Ada's sessions = %v, want none", got)
+	}
+	if _, err := service.ValidateAccessToken(bob.accessToken); err != nil {
+		t.Errorf("Bob's access token: %v", err)
+	}
+}
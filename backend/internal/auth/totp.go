@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// TOTP as described in RFC 6238 with the parameters every authenticator app
+// supports: HMAC-SHA1, six digits, 30-second steps.
+const (
+	totpIssuer      = "StackleVest"
+	totpDigits      = 6
+	totpPeriod      = 30 // Seconds
+	totpSkew        = 1  // Steps either side of now that are still accepted
+	totpSecretBytes = 20
+
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 8
+)
+
+var (
+	ErrTOTPInvalid        = domain.Unauthorized("invalid_code", "invalid authentication code")
+	ErrTOTPNotEnrolled    = domain.Conflict("totp_not_enrolled", "two-factor authentication is not set up")
+	ErrTOTPAlreadyEnabled = domain.Conflict("totp_already_enabled", "two-factor authentication is already enabled")
+	ErrTOTPRequired       = domain.Forbidden("totp_required", "two-factor authentication is required for your role")
+)
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is what an authenticator app needs to start producing codes
+type TOTPEnrollment struct {
+	Secret string `json:"secret"` // Base32, for manual entry
+	URI    string `json:"uri"`    // otpauth:// URI, usually shown as a QR code
+}
+
+// EnrollTOTP generates a new secret for the user. It only takes effect once
+// ConfirmTOTP sees a code generated from it; calling again before then
+// replaces it.
+func (s *AuthService) EnrollTOTP(userID string) (*TOTPEnrollment, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.enrollTOTP(user)
+}
+
+func (s *AuthService) enrollTOTP(user *domain.User) (*TOTPEnrollment, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = base32NoPad.EncodeToString(secret)
+	user.TOTPLastStep = 0
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	label := url.PathEscape(totpIssuer + ":" + user.Email)
+	query := url.Values{
+		"secret": {user.TOTPSecret},
+		"issuer": {totpIssuer},
+		"digits": {fmt.Sprint(totpDigits)},
+		"period": {fmt.Sprint(totpPeriod)},
+	}
+	return &TOTPEnrollment{
+		Secret: user.TOTPSecret,
+		URI:    "otpauth://totp/" + label + "?" + query.Encode(),
+	}, nil
+}
+
+// ConfirmTOTP switches two-factor authentication on once code shows the
+// user's app holds the secret from EnrollTOTP. It returns the recovery
+// codes, which are never shown again.
+func (s *AuthService) ConfirmTOTP(userID, code string) ([]string, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.confirmTOTP(user, code)
+}
+
+func (s *AuthService) confirmTOTP(user *domain.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if !s.matchTOTP(user, code) {
+		return nil, ErrTOTPInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	user.RecoveryCodes = hashes
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.recordEvent(user.ID, domain.AuthEventTOTPEnabled, "", "")
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off after checking a current
+// code or a recovery code. Users whose role requires it cannot opt out.
+func (s *AuthService) DisableTOTP(userID, code string) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if s.secondFactorRequired(user) {
+		return ErrTOTPRequired
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+	if err := s.checkSecondFactor(user, code); err != nil {
+		return err
+	}
+
+	clearTOTP(user)
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	s.recordEvent(user.ID, domain.AuthEventTOTPDisabled, "", "")
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all of the user's recovery codes after
+// checking a current code
+func (s *AuthService) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if !s.matchTOTP(user, code) {
+		return nil, ErrTOTPInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	user.RecoveryCodes = hashes
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+	s.recordEvent(user.ID, domain.AuthEventRecoveryCodesRenewed, "", "")
+	return codes, nil
+}
+
+// ResetTwoFactor is the admin path for a user who lost their authenticator
+// and their recovery codes. If their role requires 2FA they enrol again at
+// their next login.
+func (s *AuthService) ResetTwoFactor(userID string) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	clearTOTP(user)
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	s.recordEvent(user.ID, domain.AuthEventTOTPDisabled, "", "reset by an admin")
+	return nil
+}
+
+// secondFactorRequired reports whether the user's role is one that
+// TOTP_REQUIRED_ROLES makes enrol
+func (s *AuthService) secondFactorRequired(user *domain.User) bool {
+	return slices.ContainsFunc(s.config.TOTPRequiredRoles, func(role string) bool {
+		return strings.EqualFold(role, user.Role)
+	})
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code,
+// burning whichever was used. The caller saves user.
+func (s *AuthService) checkSecondFactor(user *domain.User, code string) error {
+	if s.matchTOTP(user, code) {
+		return nil
+	}
+
+	hash := hashVerifier(normalizeRecoveryCode(code))
+	for i, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			user.RecoveryCodes = slices.Delete(user.RecoveryCodes, i, i+1)
+			s.recordEvent(user.ID, domain.AuthEventRecoveryCodeUsed, "",
+				fmt.Sprintf("%d recovery codes left", len(user.RecoveryCodes)))
+			return nil
+		}
+	}
+	return ErrTOTPInvalid
+}
+
+// matchTOTP checks code against the steps around now. A match records its
+// step on user so the same code cannot be used twice.
+func (s *AuthService) matchTOTP(user *domain.User, code string) bool {
+	secret, err := base32NoPad.DecodeString(user.TOTPSecret)
+	if err != nil || len(secret) == 0 {
+		return false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return false
+	}
+
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			user.TOTPLastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode is the RFC 4226 HOTP value for counter step
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// newRecoveryCodes returns codes formatted for the user ("abcde-fghij")
+// and the hashes to store
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, recoveryCodeCount)
+	hashes = make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32NoPad.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashVerifier(raw)
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func clearTOTP(user *domain.User) {
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+}
+
+func (s *AuthService) findUser(userID string) (*domain.User, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, domain.ErrUserNotFound
+	}
+	return user, nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/password"
+)
+
+// TestTOTPStepCannotBeReplayed signs in with a code, then checks that the
+// same code, or one from an earlier step still within the skew, is refused,
+// while a code from a later step works
+func TestTOTPStepCannotBeReplayed(t *testing.T) {
+	service, store := newTestService(t, testConfig())
+	hash, err := password.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("12345678901234567890")
+	u := &domain.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", Password: hash, Role: domain.RoleStaff,
+		Status: domain.StatusActive, TOTPSecret: base32NoPad.EncodeToString(secret), TOTPEnabled: true}
+	if err := store.Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(step int64) error {
+		t.Helper()
+		challenge, err := service.Login(u.Email, "correct horse battery staple", "", ClientInfo{IP: "203.0.113.7"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !challenge.RequiresSecondFactor {
+			t.Fatal("login did not ask for a second factor")
+		}
+		_, err = service.VerifySecondFactor(challenge.SecondFactorToken, totpCode(secret, step))
+		return err
+	}
+
+	now := time.Now().Unix() / totpPeriod
+	if err := verify(now); err != nil {
+		t.Fatalf("first use of the current code: %v", err)
+	}
+	for name, step := range map[string]int64{"the same code": now, "an earlier code": now - 1} {
+		if err := verify(step); !errors.Is(err, ErrTOTPInvalid) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrTOTPInvalid)
+		}
+	}
+	if err := verify(now + 1); err != nil {
+		t.Fatalf("the next step's code: %v", err)
+	}
+	if err := verify(now + 1); !errors.Is(err, ErrTOTPInvalid) {
+		t.Errorf("the next step's code again: got %v, want %v", err, ErrTOTPInvalid)
+	}
+
+	// The last step survives a reload, so a restart cannot reopen it
+	stored, err := store.FindByID(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TOTPLastStep != now+1 {
+		t.Errorf("stored last step %d, want %d", stored.TOTPLastStep, now+1)
+	}
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// Second factors a pending login can wait for
+const (
+	SecondFactorTOTP           = "totp"
+	SecondFactorTOTPEnrollment = "totp_enrollment" // Role requires 2FA but the user has none yet
+)
+
+const (
+	challengeTTL         = 5 * time.Minute
+	challengeMaxAttempts = 5
+)
+
+var ErrInvalidChallenge = domain.Unauthorized("challenge_expired", "invalid or expired login challenge")
+
+// VerifySecondFactor finishes a login that Login or FinishOIDCLogin left
+// waiting for a second factor. code is a TOTP or recovery code; it may be
+// empty once ConfirmChallengeTOTP has completed an enrolment for the
+// challenge, or when single sign-on needed no second factor.
+func (s *AuthService) VerifySecondFactor(token, code string) (*LoginResponse, error) {
+	pending, err := s.challenges.Lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.FindByID(pending.userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	if !pending.satisfied {
+		if pending.factor != SecondFactorTOTP {
+			return nil, ErrTOTPNotEnrolled
+		}
+		if err := s.checkSecondFactor(user, code); err != nil {
+			return nil, s.challenges.Fail(token, err)
+		}
+		if err := s.repo.Update(user); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.challenges.Consume(token); err != nil {
+		return nil, err
+	}
+
+	session, refreshToken, err := s.createSession(user.ID, pending.client, nil)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.generateAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		User:         user,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// EnrollChallengeTOTP starts TOTP enrolment for a user whose login is
+// waiting on it, before they have a session to call EnrollTOTP with
+func (s *AuthService) EnrollChallengeTOTP(token string) (*TOTPEnrollment, error) {
+	user, err := s.challengeUser(token)
+	if err != nil {
+		return nil, err
+	}
+	return s.enrollTOTP(user)
+}
+
+// ConfirmChallengeTOTP completes the enrolment begun by EnrollChallengeTOTP
+// and marks the challenge satisfied, so VerifySecondFactor needs no further
+// code. It returns the new recovery codes.
+func (s *AuthService) ConfirmChallengeTOTP(token, code string) ([]string, error) {
+	user, err := s.challengeUser(token)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := s.confirmTOTP(user, code)
+	if errors.Is(err, ErrTOTPInvalid) {
+		return nil, s.challenges.Fail(token, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.challenges.Satisfy(token)
+	return codes, nil
+}
+
+func (s *AuthService) challengeUser(token string) (*domain.User, error) {
+	pending, err := s.challenges.Lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	if pending.factor != SecondFactorTOTPEnrollment {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	user, err := s.repo.FindByID(pending.userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidChallenge
+	}
+	return user, nil
+}
+
+type challengeEntry struct {
+	userID       string
+	verifierHash string
+	factor       string
+	client       ClientInfo // Device the password was entered on
+	expiresAt    time.Time
+	attempts     int
+	satisfied    bool
+}
+
+// challengeStore keeps logins that passed their first factor (password or
+// single sign-on) and wait for a second one, keyed by selector like reset
+// tokens. A challenge is dropped after challengeMaxAttempts wrong codes,
+// which sends the user back to the password (and its lockout).
+type challengeStore struct {
+	mu      sync.Mutex
+	pending map[string]*challengeEntry
+}
+
+func newChallengeStore() *challengeStore {
+	return &challengeStore{pending: map[string]*challengeEntry{}}
+}
+
+// Issue returns a new "<selector>.<verifier>" token for a login by userID
+// that is waiting on factor
+func (c *challengeStore) Issue(userID, factor string, client ClientInfo) (string, error) {
+	selector, err := randomToken(selectorBytes)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomToken(verifierBytes)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for sel, entry := range c.pending {
+		if now.After(entry.expiresAt) {
+			delete(c.pending, sel)
+		}
+	}
+	c.pending[selector] = &challengeEntry{
+		userID:       userID,
+		verifierHash: hashVerifier(verifier),
+		factor:       factor,
+		client:       client,
+		expiresAt:    now.Add(challengeTTL),
+	}
+	return selector + "." + verifier, nil
+}
+
+// Lookup returns a copy of the pending challenge, leaving it in place
+func (c *challengeStore) Lookup(token string) (challengeEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, _ := c.entry(token)
+	if entry == nil {
+		return challengeEntry{}, ErrInvalidChallenge
+	}
+	return *entry, nil
+}
+
+// Consume removes the challenge and returns it
+func (c *challengeStore) Consume(token string) (challengeEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, selector := c.entry(token)
+	if entry == nil {
+		return challengeEntry{}, ErrInvalidChallenge
+	}
+	delete(c.pending, selector)
+	return *entry, nil
+}
+
+// Satisfy marks the challenge as passed
+func (c *challengeStore) Satisfy(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, _ := c.entry(token); entry != nil {
+		entry.satisfied = true
+	}
+}
+
+// Fail counts a wrong code against the challenge and returns err, or
+// ErrInvalidChallenge once the challenge has been dropped
+func (c *challengeStore) Fail(token string, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, selector := c.entry(token)
+	if entry == nil {
+		return ErrInvalidChallenge
+	}
+	entry.attempts++
+	if entry.attempts >= challengeMaxAttempts {
+		delete(c.pending, selector)
+		return ErrInvalidChallenge
+	}
+	return err
+}
+
+// entry returns the live challenge token refers to, or nil. Caller must
+// hold c.mu.
+func (c *challengeStore) entry(token string) (*challengeEntry, string) {
+	selector, verifier, ok := splitRefreshToken(token)
+	if !ok {
+		return nil, ""
+	}
+	entry, found := c.pending[selector]
+	if !found || !verifierMatches(entry.verifierHash, verifier) {
+		return nil, ""
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.pending, selector)
+		return nil, ""
+	}
+	return entry, selector
+}
@@ -1,25 +1,121 @@
 package config
 
 import (
+	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port      string
-	JWTSecret string
-	DBPath    string
+	Port       string
+	AppURL     string // Frontend base URL, for links in emails
+	DBPath     string
+	DBDriver   string // "sqlite" or "json"
+	SQLitePath string
+
+	// Addresses whose X-Forwarded-For header is taken as the client's
+	// address: the Next.js server, which logs users in on their behalf.
+	// Everyone else is identified by the connection's address.
+	TrustedProxies []string
+
+	// Access token signing keys
+	JWTKeysDir   string
+	JWTActiveKID string // Empty selects the only key, required once there are several
+	JWTKeyAlg    string // "EdDSA" or "RS256"; used when a key has to be generated
+
+	// Password policy
+	PasswordMinLength     int
+	PasswordMinEntropy    float64 // Estimated bits
+	PasswordHistory       int     // Previous passwords that may not be reused
+	BreachedPasswordsFile string  // Empty uses the built-in list
+
+	// Staff numbers given to new users: StaffNumberPrefix followed by the
+	// next value of a sequence starting at StaffNumberStart, zero-padded to
+	// StaffNumberDigits
+	StaffNumberPrefix string
+	StaffNumberStart  int
+	StaffNumberDigits int
+
+	// Roles whose users must set up TOTP before they can sign in; none by
+	// default, since listing a role sends all its users to enrolment
+	TOTPRequiredRoles []string
+
+	// Passkeys: the domain they are bound to and the origins the browser
+	// may report
+	WebAuthnRPID    string
+	WebAuthnOrigins []string
+
+	// Single sign-on through an OpenID Connect provider; disabled while
+	// OIDCIssuerURL is empty
+	OIDCIssuerURL       string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCRedirectURL     string // This server's /api/auth/oidc/callback, as registered with the provider
+	OIDCDefaultRole     string // Given to users created at their first SSO login
+	OIDCDepartmentClaim string
+	OIDCJobTitleClaim   string
+
+	// Mail
+	MailDriver   string // "smtp", "file" or "log"
+	MailFrom     string
+	MailDropDir  string // Used by the "file" driver
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
 }
 
 func Load() *Config {
 	// Attempt to load .env, ignore error if not found (production env vars)
 	_ = godotenv.Load()
 
+	appURL := getEnv("APP_URL", "http://localhost:3000")
+
 	return &Config{
-		Port:      getEnv("PORT", "8080"),
-		JWTSecret: getEnv("JWT_SECRET", "stacklevest-secret-2025"), // Default for dev
-		DBPath:    getEnv("DB_PATH", "../websocket-server/db.json"),  // Path to existing db.json
+		Port:       getEnv("PORT", "8080"),
+		AppURL:     appURL,
+		DBPath:     getEnv("DB_PATH", "../websocket-server/db.json"), // Path to existing db.json
+		DBDriver:   getEnv("DB_DRIVER", "sqlite"),                    // Use "json" for local dev against db.json
+		SQLitePath: getEnv("SQLITE_PATH", "stacklevest.db"),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES", "127.0.0.1,::1"),
+
+		JWTKeysDir:   getEnv("JWT_KEYS_DIR", "keys"),
+		JWTActiveKID: getEnv("JWT_ACTIVE_KID", ""),
+		JWTKeyAlg:    getEnv("JWT_KEY_ALG", "EdDSA"),
+
+		PasswordMinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMinEntropy:    getEnvFloat("PASSWORD_MIN_ENTROPY", 40),
+		PasswordHistory:       getEnvInt("PASSWORD_HISTORY", 5),
+		BreachedPasswordsFile: getEnv("BREACHED_PASSWORDS_FILE", ""),
+
+		StaffNumberPrefix: getEnv("STAFF_NUMBER_PREFIX", "SLV-"),
+		StaffNumberStart:  getEnvInt("STAFF_NUMBER_START", 1),
+		StaffNumberDigits: getEnvInt("STAFF_NUMBER_DIGITS", 4),
+
+		TOTPRequiredRoles: getEnvList("TOTP_REQUIRED_ROLES", ""),
+
+		WebAuthnRPID:    getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnOrigins: getEnvList("WEBAUTHN_ORIGINS", appURL),
+
+		OIDCIssuerURL:       getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:        getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:    getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:     getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback"),
+		OIDCDefaultRole:     getEnv("OIDC_DEFAULT_ROLE", "staff"),
+		OIDCDepartmentClaim: getEnv("OIDC_DEPARTMENT_CLAIM", "department"),
+		OIDCJobTitleClaim:   getEnv("OIDC_JOB_TITLE_CLAIM", "job_title"),
+
+		MailDriver:   getEnv("MAIL_DRIVER", "log"),
+		MailFrom:     getEnv("SENDER_EMAIL", "onboarding@resend.dev"), // Same variable as the websocket-server
+		MailDropDir:  getEnv("MAIL_DROP_DIR", "mail"),
+		SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
 	}
 }
 
@@ -29,3 +125,40 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring %s=%q: not an integer", key, value)
+		return fallback
+	}
+	return n
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Ignoring %s=%q: not a number", key, value)
+		return fallback
+	}
+	return f
+}
+
+// getEnvList splits a comma-separated variable, dropping empty items
+func getEnvList(key, fallback string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
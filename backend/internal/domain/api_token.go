@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix starts every API token, telling them apart from access
+// tokens in an Authorization header and making leaked ones easy to scan for
+const APITokenPrefix = "slv_"
+
+// Scopes an API token can be given. Resource scopes are "<resource>:read"
+// for GET requests under /api/<resource> and "<resource>:write" for the
+// rest; write implies read. The organisation chart is read-only.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeOrgRead    = "org:read"
+	ScopeAdmin      = "admin" // Use the owner's admin role; only for admins' tokens
+)
+
+var APITokenScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeOrgRead, ScopeAdmin}
+
+var (
+	ErrAPITokenNotFound = NotFound("api_token_not_found", "API token not found")
+	ErrScopeNotAllowed  = Forbidden("scope_not_allowed", "only admins can create tokens with the admin scope")
+)
+
+// APIToken is a personal access token or service API key: a long-lived
+// bearer credential for scripts and bots that acts as its owner, within its
+// scopes. The secret is "slv_<selector>.<verifier>" like refresh tokens.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"` // Owner the token acts as
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Selector   string     `json:"selector,omitempty"`  // Public half of the secret, indexed
+	TokenHash  string     `json:"tokenHash,omitempty"` // SHA-256 of the verifier half
+	CreatedBy  string     `json:"createdBy"`           // The owner, or the admin who issued it
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIp,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Sanitize strips the secret material before a token is returned
+func (t *APIToken) Sanitize() {
+	t.Selector = ""
+	t.TokenHash = ""
+}
+
+// HasScope reports whether the token grants scope
+func (t *APIToken) HasScope(scope string) bool {
+	if slices.Contains(t.Scopes, scope) {
+		return true
+	}
+	if resource, ok := strings.CutSuffix(scope, ":read"); ok {
+		return slices.Contains(t.Scopes, resource+":write")
+	}
+	return false
+}
+
+type APITokenRepository interface {
+	CreateAPIToken(token *APIToken) error
+	FindAPITokenBySelector(selector string) (*APIToken, error)
+	FindUserAPITokens(userID string) ([]APIToken, error)
+	UpdateAPIToken(token *APIToken) error
+	DeleteAPIToken(id string) error
+}
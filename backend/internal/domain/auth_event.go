@@ -0,0 +1,47 @@
+package domain
+
+import "time"
+
+const (
+	// AuthEventRefreshTokenReuse is recorded when a refresh token that was
+	// already rotated is presented again, which indicates it was stolen
+	AuthEventRefreshTokenReuse = "refresh_token_reuse"
+	AuthEventAccountLocked     = "account_locked"
+	AuthEventAccountUnlocked   = "account_unlocked"
+
+	AuthEventPasswordChanged     = "password_changed"
+	AuthEventPasswordReset       = "password_reset"
+	AuthEventPasswordResetForced = "password_reset_forced"
+
+	AuthEventTOTPEnabled          = "totp_enabled"
+	AuthEventTOTPDisabled         = "totp_disabled"
+	AuthEventRecoveryCodeUsed     = "recovery_code_used"
+	AuthEventRecoveryCodesRenewed = "recovery_codes_renewed"
+
+	AuthEventPasskeyAdded         = "passkey_added"
+	AuthEventPasskeyRemoved       = "passkey_removed"
+	AuthEventPasskeyCloneDetected = "passkey_clone_detected"
+
+	AuthEventSSOUserProvisioned = "sso_user_provisioned"
+	AuthEventSSOAccountLinked   = "sso_account_linked"
+
+	AuthEventAPITokenCreated = "api_token_created"
+	AuthEventAPITokenRevoked = "api_token_revoked"
+
+	AuthEventInvitationSent     = "invitation_sent"
+	AuthEventInvitationAccepted = "invitation_accepted"
+)
+
+type AuthEvent struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Type      string    `json:"type"`
+	FamilyID  string    `json:"familyId,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type AuthEventRepository interface {
+	CreateAuthEvent(event *AuthEvent) error
+	FindAuthEventsByUser(userID string) ([]AuthEvent, error)
+}
@@ -1,11 +1,19 @@
 package domain
 
-import "time"
+var ErrChannelNotFound = NotFound("channel_not_found", "channel not found")
 
 type Channel struct {
-	ID          string    `json:"id"`
-	WorkspaceID string    `json:"workspace_id"`
-	Name        string    `json:"name"`
-	Type        string    `json:"type"` // public, private
-	CreatedAt   time.Time `json:"created_at"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"` // public, private
+	UnreadCount int    `json:"unreadCount"`
+}
+
+type ChannelRepository interface {
+	FindAllChannels() ([]Channel, error)
+	FindChannelByID(id string) (*Channel, error)
+	CreateChannel(channel *Channel) error
+	UpdateChannel(channel *Channel) error
+	DeleteChannel(id string) error
 }
@@ -0,0 +1,80 @@
+package domain
+
+import "errors"
+
+// Error kinds. Every error meant for API clients wraps one of these, which
+// decides its HTTP status; any other error is internal and its text is
+// logged, never sent.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("invalid request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrLocked       = errors.New("locked")
+	ErrRateLimited  = errors.New("too many requests")
+	ErrUpstream     = errors.New("upstream service failed") // Such as the mail server
+)
+
+// Error is an error whose message is written for clients, with a stable
+// code for them to match on. Kind is one of the error kinds above.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+
+	// Further members of the response body, such as when to retry
+	Details map[string]interface{}
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) Unwrap() error { return e.Kind }
+
+// Is matches errors by code, so a sentinel still matches after WithKind or
+// with a more specific message
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && e.Code != "" && t.Code == e.Code
+}
+
+func NotFound(code, message string) *Error {
+	return &Error{Kind: ErrNotFound, Code: code, Message: message}
+}
+
+func Conflict(code, message string) *Error {
+	return &Error{Kind: ErrConflict, Code: code, Message: message}
+}
+
+// Invalid is a request rejected as a whole; see ValidationError for one
+// rejected field by field
+func Invalid(code, message string) *Error {
+	return &Error{Kind: ErrValidation, Code: code, Message: message}
+}
+
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: ErrUnauthorized, Code: code, Message: message}
+}
+
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: ErrForbidden, Code: code, Message: message}
+}
+
+func RateLimited(code, message string) *Error {
+	return &Error{Kind: ErrRateLimited, Code: code, Message: message}
+}
+
+func Upstream(code, message string) *Error {
+	return &Error{Kind: ErrUpstream, Code: code, Message: message}
+}
+
+// WithKind returns err as a *Error of another kind, for an error whose
+// status depends on where it happened; the code and message are kept.
+// Errors that are not a *Error are returned unchanged.
+func WithKind(err error, kind error) error {
+	var e *Error
+	if !errors.As(err, &e) || e.Kind == kind {
+		return err
+	}
+	return &Error{Kind: kind, Code: e.Code, Message: e.Message, Details: e.Details}
+}
@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+var ErrInvitationNotFound = NotFound("invitation_not_found", "invitation not found")
+
+// Invitation is an emailed, single-use link that lets a new hire set up the
+// account an admin created for them. The user exists from the start with
+// NeedsOnboarding and no password; accepting deletes the invitation.
+type Invitation struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Selector  string    `json:"selector,omitempty"`  // Public half of the link's token, indexed
+	TokenHash string    `json:"tokenHash,omitempty"` // SHA-256 of the verifier half
+	InvitedBy string    `json:"invitedBy"`           // Admin who sent it, or last resent it
+	ExpiresAt time.Time `json:"expiresAt"`
+	SentAt    time.Time `json:"sentAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Sanitize strips the token material before an invitation is returned
+func (i *Invitation) Sanitize() {
+	i.Selector = ""
+	i.TokenHash = ""
+}
+
+type InvitationRepository interface {
+	CreateInvitation(invitation *Invitation) error
+	FindInvitationByID(id string) (*Invitation, error)
+	FindInvitationBySelector(selector string) (*Invitation, error)
+	FindAllInvitations() ([]Invitation, error)
+	UpdateInvitation(invitation *Invitation) error
+	DeleteInvitation(id string) error
+}
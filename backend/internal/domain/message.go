@@ -2,10 +2,51 @@ package domain
 
 import "time"
 
+var ErrMessageNotFound = NotFound("message_not_found", "message not found")
+
+// Message mirrors the shape the websocket-server persists in db.json.
+// A message belongs to either a channel (ChannelID) or a direct
+// conversation (DMID); replies in a thread carry the ParentID.
 type Message struct {
-	ID        string    `json:"id"`
-	ChannelID string    `json:"channel_id"`
-	UserID    string    `json:"user_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          string         `json:"id"`
+	Content     string         `json:"content"`
+	SenderID    string         `json:"senderId"`
+	ChannelID   string         `json:"channelId,omitempty"`
+	DMID        string         `json:"dmId,omitempty"`
+	ParentID    string         `json:"parentId,omitempty"`
+	Timestamp   time.Time      `json:"timestamp"`
+	Attachments []Attachment   `json:"attachments,omitempty"`
+	Reactions   []Reaction     `json:"reactions,omitempty"`
+	User        *MessageSender `json:"user,omitempty"` // Denormalised sender snapshot
+}
+
+type MessageSender struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+}
+
+type Attachment struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"` // image, pdf, doc
+	URL  string `json:"url"`
+	Size string `json:"size"`
+}
+
+type Reaction struct {
+	Emoji   string   `json:"emoji"`
+	UserIDs []string `json:"userIds"`
+}
+
+type MessageRepository interface {
+	FindAllMessages() ([]Message, error)
+	FindMessageByID(id string) (*Message, error)
+	FindMessagesByChannel(channelID string) ([]Message, error)
+	// FindDirectMessages returns the conversation between two users in both directions
+	FindDirectMessages(userID, otherUserID string) ([]Message, error)
+	FindThreadReplies(parentID string) ([]Message, error)
+	CreateMessage(message *Message) error
+	UpdateMessage(message *Message) error
+	DeleteMessage(id string) error
 }
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+)
+
+// OrgNode is a user in the organisation chart with the users who report to
+// them, ordered by name
+type OrgNode struct {
+	User    *User
+	Reports []*OrgNode
+
+	manager *OrgNode
+}
+
+// OrgChart is the reporting tree built from every user's ReportingManager
+type OrgChart struct {
+	// Roots are the users without a manager, ordered by name. A manager ID
+	// naming no user counts as none.
+	Roots []*OrgNode
+
+	nodes map[string]*OrgNode
+}
+
+// NewOrgChart builds the chart of users. Updates are checked for cycles,
+// but should records still loop, the loop is cut at its first user by name,
+// who becomes a root, so the chart is always a tree.
+func NewOrgChart(users []User) *OrgChart {
+	chart := &OrgChart{nodes: make(map[string]*OrgNode, len(users))}
+	nodes := make([]*OrgNode, len(users))
+	for i := range users {
+		u := users[i]
+		nodes[i] = &OrgNode{User: &u}
+		chart.nodes[u.ID] = nodes[i]
+	}
+	slices.SortFunc(nodes, byName)
+
+	for _, n := range nodes {
+		if m := chart.nodes[n.User.ReportingManager]; m != nil && m != n {
+			n.manager = m
+			m.Reports = append(m.Reports, n)
+		} else {
+			chart.Roots = append(chart.Roots, n)
+		}
+	}
+
+	// Users on a loop are nobody's reports from a root; cut each loop
+	reached := map[*OrgNode]bool{}
+	var reach func(n *OrgNode)
+	reach = func(n *OrgNode) {
+		reached[n] = true
+		for _, r := range n.Reports {
+			reach(r)
+		}
+	}
+	for _, root := range chart.Roots {
+		reach(root)
+	}
+	for _, n := range nodes {
+		if reached[n] {
+			continue
+		}
+		n.manager.Reports = slices.DeleteFunc(n.manager.Reports, func(r *OrgNode) bool { return r == n })
+		n.manager = nil
+		chart.Roots = append(chart.Roots, n)
+		reach(n)
+	}
+	slices.SortFunc(chart.Roots, byName)
+	return chart
+}
+
+func byName(a, b *OrgNode) int {
+	if c := strings.Compare(strings.ToLower(a.User.Name), strings.ToLower(b.User.Name)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.User.ID, b.User.ID)
+}
+
+// Node returns the user with id and their reports, or nil
+func (c *OrgChart) Node(id string) *OrgNode {
+	return c.nodes[id]
+}
+
+// Managers returns the chain of managers above the user with id, nearest
+// first
+func (c *OrgChart) Managers(id string) []*User {
+	var managers []*User
+	if n := c.nodes[id]; n != nil {
+		for m := n.manager; m != nil; m = m.manager {
+			managers = append(managers, m.User)
+		}
+	}
+	return managers
+}
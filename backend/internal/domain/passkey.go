@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+var ErrPasskeyNotFound = NotFound("passkey_not_found", "passkey not found")
+
+// Passkey is a WebAuthn credential registered to a user for passwordless
+// sign-in. Only the public key is held; the private key never leaves the
+// authenticator.
+type Passkey struct {
+	ID              string     `json:"id"` // Credential ID, base64url
+	UserID          string     `json:"userId"`
+	Name            string     `json:"name"` // Chosen by the user, e.g. "MacBook Touch ID"
+	PublicKey       []byte     `json:"publicKey,omitempty"`
+	AttestationType string     `json:"attestationType,omitempty"`
+	Transports      []string   `json:"transports,omitempty"`
+	AAGUID          []byte     `json:"aaguid,omitempty"` // Identifies the authenticator model
+	SignCount       uint32     `json:"signCount"`        // Highest counter seen; a lower one means a cloned key
+	BackupEligible  bool       `json:"backupEligible"`   // Synced passkey (e.g. iCloud Keychain)
+	BackupState     bool       `json:"backupState"`
+	LastUsedAt      *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+// Sanitize strips the key material before a passkey is returned
+func (p *Passkey) Sanitize() {
+	p.PublicKey = nil
+}
+
+type PasskeyRepository interface {
+	CreatePasskey(passkey *Passkey) error
+	FindPasskey(id string) (*Passkey, error)
+	FindUserPasskeys(userID string) ([]Passkey, error)
+	UpdatePasskey(passkey *Passkey) error
+	DeletePasskey(id string) error
+}
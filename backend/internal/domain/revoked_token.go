@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+// RevokedToken denylists a single access token by its jti. Entries are only
+// needed until the token would have expired on its own.
+type RevokedToken struct {
+	JTI       string    `json:"jti"`
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type RevokedTokenRepository interface {
+	// RevokeToken adds token to the denylist and drops entries that have expired
+	RevokeToken(token *RevokedToken) error
+	IsTokenRevoked(jti string) (bool, error)
+}
@@ -0,0 +1,42 @@
+package domain
+
+import "strconv"
+
+// StaffNumberSequence numbers the staff numbers of new users
+const StaffNumberSequence = "staff_number"
+
+// Sequence is a named counter handing out increasing values, such as staff
+// numbers. Values are never reused, even after the record holding one is
+// deleted.
+type Sequence struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"` // Last value handed out
+}
+
+type SequenceRepository interface {
+	// NextSequenceValue advances the named sequence and returns its new
+	// value; a sequence used for the first time returns start
+	NextSequenceValue(name string, start int64) (int64, error)
+
+	// SeedSequence raises the named sequence to value, so the next value
+	// handed out is above it; a sequence already past value is left alone
+	SeedSequence(name string, value int64) error
+}
+
+// HighestStaffNumber is the highest number a staff number of users ends in,
+// such as 42 for "SLV-0042", or 0. Data written before the sequence was
+// stored, or by a writer that dropped it, has only the staff numbers.
+func HighestStaffNumber(users []User) int64 {
+	var highest int64
+	for _, u := range users {
+		number := u.StaffNumber
+		i := len(number)
+		for i > 0 && number[i-1] >= '0' && number[i-1] <= '9' {
+			i--
+		}
+		if n, err := strconv.ParseInt(number[i:], 10, 64); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest
+}
@@ -2,11 +2,46 @@ package domain
 
 import "time"
 
+var ErrTaskNotFound = NotFound("task_not_found", "task not found")
+
+const (
+	TaskStatusTodo       = "todo"
+	TaskStatusInProgress = "in_progress"
+	TaskStatusDone       = "done"
+)
+
+// Task mirrors the shape the websocket-server persists in db.json.
+// CreatorID is empty on legacy tasks created before it was tracked.
 type Task struct {
-	ID          string    `json:"id"`
-	WorkspaceID string    `json:"workspace_id"`
-	AssigneeID  string    `json:"assignee_id"`
-	Title       string    `json:"title"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Status      string        `json:"status"`
+	Priority    string        `json:"priority,omitempty"` // high, medium, low
+	AssigneeIDs []string      `json:"assigneeIds"`
+	CreatorID   string        `json:"creatorId,omitempty"`
+	DueDate     string        `json:"dueDate,omitempty"` // Kept verbatim; clients send date-only values
+	ChannelID   string        `json:"channelId,omitempty"`
+	DMID        string        `json:"dmId,omitempty"`
+	Progress    *int          `json:"progress,omitempty"`
+	CompletedAt *time.Time    `json:"completedAt,omitempty"`
+	CreatedAt   *time.Time    `json:"createdAt,omitempty"`
+	Comments    []TaskComment `json:"comments,omitempty"`
+}
+
+type TaskComment struct {
+	ID        string    `json:"id"`
+	TaskID    string    `json:"taskId"`
+	UserID    string    `json:"userId"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type TaskRepository interface {
+	FindAllTasks() ([]Task, error)
+	FindTaskByID(id string) (*Task, error)
+	FindTasksByAssignee(userID string) ([]Task, error)
+	CreateTask(task *Task) error
+	UpdateTask(task *Task) error
+	DeleteTask(id string) error
 }
@@ -1,6 +1,12 @@
 package domain
 
-import "time"
+import (
+	"crypto/rand"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
 
 const (
 	RoleAdmin   = "admin"
@@ -8,6 +14,24 @@ const (
 	RoleStaff   = "staff"
 )
 
+var Roles = []string{RoleAdmin, RoleManager, RoleStaff}
+
+// Account statuses. PENDING is an invited user who has not accepted yet.
+const (
+	StatusActive   = "ACTIVE"
+	StatusInactive = "INACTIVE"
+	StatusPending  = "PENDING"
+)
+
+var Statuses = []string{StatusActive, StatusInactive, StatusPending}
+
+// NormalizeRole returns role as one of the Role constants, ignoring case,
+// and whether it is one
+func NormalizeRole(role string) (string, bool) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	return role, slices.Contains(Roles, role)
+}
+
 type User struct {
 	ID               string    `json:"id"`
 	Name             string    `json:"name"`
@@ -22,33 +46,165 @@ type User struct {
 	Status           string    `json:"status"`
 	Avatar           string    `json:"avatar"`
 	CreatedAt        time.Time `json:"createdAt"`
+
+	// Login throttling; reset on a successful login or by an admin unlock
+	FailedLoginAttempts int        `json:"failedLoginAttempts,omitempty"`
+	LockedUntil         *time.Time `json:"lockedUntil,omitempty"`
+
+	// Set by an admin: the next login must choose a new password
+	MustResetPassword bool `json:"mustResetPassword,omitempty"`
+
+	// Previous password hashes, newest first, to prevent reuse
+	PasswordHistory []string `json:"passwordHistory,omitempty"`
+
+	// Access tokens issued at or before this instant are rejected
+	TokensInvalidBefore *time.Time `json:"tokensInvalidBefore,omitempty"`
+
+	// TOTP second factor. The secret is stored at enrolment but only used
+	// once a first code confirms it; TOTPLastStep stops a code being
+	// replayed within its window.
+	TOTPSecret    string   `json:"totpSecret,omitempty"`
+	TOTPEnabled   bool     `json:"totpEnabled,omitempty"`
+	TOTPLastStep  int64    `json:"totpLastStep,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"` // SHA-256 of each unused code
+
+	// Subject ("sub") of the identity provider account this user signs in
+	// with through single sign-on; empty for accounts not linked to one
+	OIDCSubject string `json:"oidcSubject,omitempty"`
+}
+
+// Validate checks the profile fields an admin or the user can set: a
+// name, a well-formed email and role, and lengths. Whether the email is
+// free and the reporting manager exists is for the caller to check against
+// the store, as is the status with CheckStatus.
+func (u *User) Validate() error {
+	var v Validator
+	if v.Required("name", "Name", u.Name) {
+		v.MaxLength("name", "Name", u.Name, MaxNameLength)
+	}
+	if v.Required("email", "Email", u.Email) {
+		v.Email("email", u.Email)
+	}
+	if v.Required("role", "Role", u.Role) {
+		v.OneOf("role", "Role", u.Role, Roles)
+	}
+	v.MaxLength("department", "Department", u.Department, MaxTextLength)
+	v.MaxLength("jobTitle", "Job title", u.JobTitle, MaxTextLength)
+	v.MaxLength("staffNumber", "Staff number", u.StaffNumber, MaxTextLength)
+	v.MaxLength("reportingManager", "Reporting manager", u.ReportingManager, MaxIDLength)
+	v.MaxLength("avatar", "Avatar", u.Avatar, MaxURLLength)
+	return v.Err()
+}
+
+// CheckStatus adds a field error to v unless status is empty or one of
+// Statuses. Callers check a status only when it is set or changed: the
+// websocket server writes presence ("online", "busy", "offline") to the
+// same field, and an edit that leaves it alone must not be refused for it.
+func CheckStatus(v *Validator, status string) {
+	v.OneOf("status", "Status", status, Statuses)
+}
+
+// CheckReportingManager adds a field error to v unless managerID is empty
+// or the ID of a user in repo who is neither the user with userID nor, up
+// their own chain of managers, one of that user's reports. userID is empty
+// for a user not created yet, who has no reports.
+func CheckReportingManager(v *Validator, repo UserRepository, userID, managerID string) error {
+	if managerID == "" {
+		return nil
+	}
+	if managerID == userID {
+		v.Add("reportingManager", CodeCycle, "A user cannot be their own reporting manager")
+		return nil
+	}
+	// The seen set ends the walk should the stored chain already loop
+	seen := map[string]bool{}
+	for id := managerID; id != "" && !seen[id]; {
+		seen[id] = true
+		manager, err := repo.FindByID(id)
+		if err != nil {
+			return err
+		}
+		if manager == nil {
+			if id == managerID {
+				v.Add("reportingManager", CodeNotFound, "Reporting manager must be an existing user")
+			}
+			return nil
+		}
+		if userID != "" && manager.ReportingManager == userID {
+			v.Add("reportingManager", CodeCycle, "Reporting manager reports to this user, which would make them their own manager")
+			return nil
+		}
+		id = manager.ReportingManager
+	}
+	return nil
 }
 
 func (u *User) Sanitize() {
 	u.Password = ""
+	u.PasswordHistory = nil
+	u.TOTPSecret = ""
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = nil
 }
 
+var (
+	ErrUserNotFound     = NotFound("user_not_found", "user not found")
+	ErrSessionNotFound  = NotFound("session_not_found", "session not found")
+	ErrTokenRevoked     = Unauthorized("token_revoked", "token has been revoked")
+	ErrEmailTaken       = Conflict("email_taken", "a user with this email already exists")
+	ErrStaffNumberTaken = Conflict("staff_number_taken", "the next staff number is already in use")
+)
+
 type UserSession struct {
-	ID           string    `json:"id"`
-	UserID       string    `json:"userId"`
-	RefreshToken string    `json:"refreshToken"` // Hashed
-	ExpiresAt    time.Time `json:"expiresAt"`
-	CreatedAt    time.Time `json:"createdAt"`
+	ID           string     `json:"id"`
+	UserID       string     `json:"userId"`
+	Selector     string     `json:"selector,omitempty"`     // Public half of the refresh token, indexed
+	RefreshToken string     `json:"refreshToken,omitempty"` // SHA-256 of the secret verifier half
+	FamilyID     string     `json:"familyId"`               // Shared by every session rotated from the same login
+	RotatedAt    *time.Time `json:"rotatedAt,omitempty"`    // Set once exchanged; presenting it again is reuse
+	IP           string     `json:"ip"`                     // Address the session was created from
+	UserAgent    string     `json:"userAgent"`
+	LastUsedAt   time.Time  `json:"lastUsedAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// Sanitize strips the refresh token material before a session is returned
+func (s *UserSession) Sanitize() {
+	s.Selector = ""
+	s.RefreshToken = ""
+}
+
+// Active reports whether the session can still be refreshed
+func (s *UserSession) Active(now time.Time) bool {
+	return s.RotatedAt == nil && now.Before(s.ExpiresAt)
 }
 
 type UserRepository interface {
 	FindAll() ([]User, error)
+	FindUsers(q UserQuery) (*UserPage, error)
 	FindByID(id string) (*User, error)
 	FindByEmail(email string) (*User, error)
+	FindByOIDCSubject(subject string) (*User, error)
 	Create(user *User) error
 	Update(user *User) error
 	Delete(id string) error
 
 	// Session Management
 	CreateSession(session *UserSession) error
-	FindSessionByToken(token string) (*UserSession, error)
+	FindSessionByID(id string) (*UserSession, error)
+	FindSessionBySelector(selector string) (*UserSession, error)
+	UpdateSession(session *UserSession) error
+	// RotateSession marks the session rotated at the given time unless it
+	// already was, in one step, reporting whether this call rotated it
+	RotateSession(id string, at time.Time) (bool, error)
 	DeleteSession(id string) error
+	DeleteSessionFamily(familyID string) error
 	DeleteUserSessions(userID string) error
+	// DeleteStaleSessions deletes the family's sessions rotated before
+	// rotatedBefore, and every session of any user that has expired
+	DeleteStaleSessions(familyID string, rotatedBefore time.Time) error
+	FindUserSessions(userID string) ([]UserSession, error)
 	FindAllSessions() ([]UserSession, error)
 }
 
@@ -61,7 +217,11 @@ func GenerateRandomString(n int) string {
 	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letters[time.Now().UnixNano()%int64(len(letters))]
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		if err != nil {
+			panic(err) // crypto/rand does not fail on supported platforms
+		}
+		b[i] = letters[idx.Int64()]
 	}
 	return string(b)
 }
@@ -0,0 +1,147 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Page sizes of the user directory
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// User sort keys; a leading "-" sorts descending. Ties are broken by ID, so
+// every order is total and a cursor always lands in the same place.
+const (
+	SortCreatedAt   = "createdAt"
+	SortName        = "name"
+	SortEmail       = "email"
+	SortDepartment  = "department"
+	SortStaffNumber = "staffNumber"
+)
+
+var UserSortKeys = []string{SortCreatedAt, SortName, SortEmail, SortDepartment, SortStaffNumber}
+
+var ErrInvalidCursor = Invalid("invalid_cursor", "the cursor is malformed or belongs to another sort order")
+
+// UserQuery selects a page of the user directory. Empty filters match
+// everyone; filters compare case-insensitively, except ReportingManager,
+// an exact user ID.
+type UserQuery struct {
+	Role             string
+	Department       string
+	Status           string
+	ReportingManager string
+
+	// Search matches a substring of the name, email or job title, ignoring
+	// case
+	Search string
+
+	Sort  string // One of UserSortKeys, optionally prefixed with "-"
+	Limit int    // At most MaxPageSize; DefaultPageSize if zero
+	After string // NextCursor of the previous page
+}
+
+// UserPage is one page of users; NextCursor is empty on the last page
+type UserPage struct {
+	Users      []User
+	NextCursor string
+}
+
+// SortKey splits Sort into the key and direction, defaulting to oldest first
+func (q UserQuery) SortKey() (key string, desc bool) {
+	key = strings.TrimPrefix(q.Sort, "-")
+	if key == "" {
+		return SortCreatedAt, false
+	}
+	return key, strings.HasPrefix(q.Sort, "-")
+}
+
+// PageSize is Limit clamped to (0, MaxPageSize]
+func (q UserQuery) PageSize() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageSize
+	case q.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// Matches reports whether u passes the filters and search, for stores that
+// filter in memory
+func (q UserQuery) Matches(u *User) bool {
+	switch {
+	case q.Role != "" && !strings.EqualFold(u.Role, q.Role),
+		q.Department != "" && !strings.EqualFold(u.Department, q.Department),
+		q.Status != "" && !strings.EqualFold(u.Status, q.Status),
+		q.ReportingManager != "" && u.ReportingManager != q.ReportingManager:
+		return false
+	}
+	if q.Search == "" {
+		return true
+	}
+	search := strings.ToLower(q.Search)
+	for _, field := range []string{u.Name, u.Email, u.JobTitle} {
+		if strings.Contains(strings.ToLower(field), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserCursor is the position after the last user of a page: the sort key
+// it was made for, that user's ID, and their sort value as of that page
+type UserCursor struct {
+	Sort  string `json:"s"`
+	ID    string `json:"id"`
+	Value string `json:"v"`
+}
+
+// SortValue is u's value of a sort key, as text
+func SortValue(u *User, key string) string {
+	switch key {
+	case SortName:
+		return u.Name
+	case SortEmail:
+		return u.Email
+	case SortDepartment:
+		return u.Department
+	case SortStaffNumber:
+		return u.StaffNumber
+	}
+	return u.CreatedAt.Format(time.RFC3339Nano)
+}
+
+// NextUserCursor is the cursor of the page after last, the final user of a
+// page of q
+func NextUserCursor(q UserQuery, last *User) string {
+	key, _ := q.SortKey()
+	return UserCursor{Sort: q.Sort, ID: last.ID, Value: SortValue(last, key)}.Encode()
+}
+
+// Encode makes the cursor opaque to clients
+func (c UserCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeUserCursor reads q.After, which must have been made for the same
+// sort as q; no cursor is the first page
+func DecodeUserCursor(q UserQuery) (*UserCursor, error) {
+	if q.After == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(q.After)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c UserCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" || c.Sort != q.Sort {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field error codes, for clients to match on
+const (
+	CodeRequired    = "required"
+	CodeTooLong     = "too_long"
+	CodeInvalid     = "invalid"      // Not in the expected format
+	CodeInvalidType = "invalid_type" // A JSON value of the wrong type
+	CodeInvalidJSON = "invalid_json" // The body as a whole did not parse
+	CodeNotAllowed  = "not_allowed"  // Not one of the accepted values
+	CodeNotFound    = "not_found"    // Refers to something that does not exist
+	CodeCycle       = "cycle"        // Would make a user their own manager
+)
+
+// Length limits of free-text fields, in characters
+const (
+	MaxNameLength  = 100
+	MaxEmailLength = 254
+	MaxTextLength  = 100 // Department, job title, staff number, token names
+	MaxIDLength    = 64
+	MaxURLLength   = 2048
+)
+
+// FieldError is one reason a request field was rejected. Field is the JSON
+// member name, or empty when the body as a whole is at fault.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a request that was rejected. It is
+// of the ErrValidation kind.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidation }
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator collects field errors; Err returns them once every check has
+// run, so a client learns about all bad inputs at once
+type Validator struct {
+	fields []FieldError
+}
+
+func (v *Validator) Add(field, code, message string) {
+	v.fields = append(v.fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Required rejects a blank value and reports whether there was one
+func (v *Validator) Required(field, label, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, CodeRequired, label+" is required")
+		return false
+	}
+	return true
+}
+
+func (v *Validator) MaxLength(field, label, value string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		v.Add(field, CodeTooLong, fmt.Sprintf("%s must be at most %d characters", label, max))
+	}
+}
+
+// Email rejects anything but a bare address such as "name@example.com";
+// an empty value is left to Required
+func (v *Validator) Email(field, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if utf8.RuneCountInString(value) > MaxEmailLength {
+		v.Add(field, CodeTooLong, fmt.Sprintf("Email must be at most %d characters", MaxEmailLength))
+		return
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value || !strings.Contains(value[strings.LastIndex(value, "@"):], ".") {
+		v.Add(field, CodeInvalid, "Enter an email address such as name@example.com")
+	}
+}
+
+// OneOf rejects a non-empty value that is not in allowed, ignoring case
+func (v *Validator) OneOf(field, label, value string, allowed []string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSpace(value), a) {
+			return
+		}
+	}
+	v.Add(field, CodeNotAllowed, fmt.Sprintf("%s must be one of %s", label, strings.Join(allowed, ", ")))
+}
+
+// Merge adds the field errors of err, if it is a *ValidationError, and
+// returns any other error unchanged
+func (v *Validator) Merge(err error) error {
+	if invalid, ok := err.(*ValidationError); ok {
+		v.fields = append(v.fields, invalid.Fields...)
+		return nil
+	}
+	return err
+}
+
+// Err returns the collected field errors as a *ValidationError, or nil
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
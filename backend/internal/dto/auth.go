@@ -0,0 +1,288 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// LoginResponse is the body of every call that can end a login: password,
+// second factor, passkey and refresh
+type LoginResponse struct {
+	User         *UserResponse `json:"user,omitempty"` // The signed-in user, seen as themselves
+	AccessToken  string        `json:"accessToken,omitempty"`
+	RefreshToken string        `json:"refreshToken,omitempty"` // Also set as a cookie
+	RequiresOTP  bool          `json:"requiresOtp,omitempty"`
+
+	RequiresPasswordReset bool   `json:"requiresPasswordReset,omitempty"`
+	ResetToken            string `json:"resetToken,omitempty"` // For POST /api/auth/reset-password
+
+	// The password was right but a second factor is still needed; the
+	// client finishes the login with POST /api/auth/2fa/verify
+	RequiresSecondFactor bool   `json:"requiresSecondFactor,omitempty"`
+	SecondFactor         string `json:"secondFactor,omitempty"`
+	SecondFactorToken    string `json:"secondFactorToken,omitempty"`
+}
+
+// maxSecretLength bounds tokens, codes and passwords sent to the auth
+// routes; none of the real ones come close
+const maxSecretLength = 512
+
+// LoginRequest is the body of POST /api/login. OTP is only sent by users
+// still onboarding, on the second call.
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+}
+
+func (r *LoginRequest) Validate() error {
+	var v domain.Validator
+	if v.Required("email", "Email", r.Email) {
+		v.Email("email", r.Email)
+	}
+	if v.Required("password", "Password", r.Password) {
+		v.MaxLength("password", "Password", r.Password, maxSecretLength)
+	}
+	v.MaxLength("otp", "Code", r.OTP, maxSecretLength)
+	return v.Err()
+}
+
+// EmailRequest is the body of the routes that only take an email: OTP
+// requests and forgotten passwords
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+func (r *EmailRequest) Validate() error {
+	var v domain.Validator
+	if v.Required("email", "Email", r.Email) {
+		v.Email("email", r.Email)
+	}
+	return v.Err()
+}
+
+// ChangePasswordRequest is the body of POST /api/auth/change-password; the
+// new password is checked against the policy by the service
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func (r *ChangePasswordRequest) Validate() error {
+	var v domain.Validator
+	v.Required("currentPassword", "Current password", r.CurrentPassword)
+	v.Required("newPassword", "New password", r.NewPassword)
+	return v.Err()
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+func (r *ResetPasswordRequest) Validate() error {
+	var v domain.Validator
+	requireToken(&v, r.Token)
+	v.Required("newPassword", "New password", r.NewPassword)
+	return v.Err()
+}
+
+// ChallengeRequest carries the token of a login waiting on a second factor
+type ChallengeRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *ChallengeRequest) Validate() error {
+	var v domain.Validator
+	requireToken(&v, r.Token)
+	return v.Err()
+}
+
+// ChallengeCodeRequest answers a second-factor challenge with a TOTP or
+// recovery code
+type ChallengeCodeRequest struct {
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+func (r *ChallengeCodeRequest) Validate() error {
+	var v domain.Validator
+	requireToken(&v, r.Token)
+	requireCode(&v, r.Code)
+	return v.Err()
+}
+
+// CodeRequest confirms a TOTP setting change with a current or recovery
+// code
+type CodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (r *CodeRequest) Validate() error {
+	var v domain.Validator
+	requireCode(&v, r.Code)
+	return v.Err()
+}
+
+// PasskeyRequest finishes a passkey ceremony with the credential from
+// navigator.credentials.get() or .create(). Name is only read when
+// registering.
+type PasskeyRequest struct {
+	Token      string          `json:"token"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+func (r *PasskeyRequest) Validate() error {
+	var v domain.Validator
+	requireToken(&v, r.Token)
+	v.MaxLength("name", "Name", r.Name, domain.MaxTextLength)
+	if len(r.Credential) == 0 || string(r.Credential) == "null" {
+		v.Add("credential", domain.CodeRequired, "Credential is required")
+	}
+	return v.Err()
+}
+
+// APITokenRequest is the body of both routes that create API tokens. The
+// service checks the name, scopes and lifetime.
+type APITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"` // 0 for the default
+}
+
+// AcceptInvitationRequest is the body of POST /api/invitations/accept
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	JobTitle string `json:"jobTitle"`
+	Avatar   string `json:"avatar"`
+}
+
+func (r *AcceptInvitationRequest) Validate() error {
+	var v domain.Validator
+	requireToken(&v, r.Token)
+	v.Required("password", "Password", r.Password)
+	v.MaxLength("name", "Name", r.Name, domain.MaxNameLength)
+	v.MaxLength("jobTitle", "Job title", r.JobTitle, domain.MaxTextLength)
+	v.MaxLength("avatar", "Avatar", r.Avatar, domain.MaxURLLength)
+	return v.Err()
+}
+
+func requireToken(v *domain.Validator, token string) {
+	if v.Required("token", "Token", token) {
+		v.MaxLength("token", "Token", token, maxSecretLength)
+	}
+}
+
+func requireCode(v *domain.Validator, code string) {
+	if v.Required("code", "Code", code) {
+		v.MaxLength("code", "Code", code, maxSecretLength)
+	}
+}
+
+// SessionResponse is a signed-in device as its owner or an admin sees it.
+// Current marks the session of the access token making the request.
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Current    bool      `json:"current"`
+}
+
+// NewSessionResponses maps sessions, flagging the one with currentID
+func NewSessionResponses(sessions []domain.UserSession, currentID string) []SessionResponse {
+	resp := make([]SessionResponse, len(sessions))
+	for i, s := range sessions {
+		resp[i] = SessionResponse{
+			ID:         s.ID,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+			CreatedAt:  s.CreatedAt,
+			Current:    s.ID == currentID,
+		}
+	}
+	return resp
+}
+
+// PasskeyResponse is a registered passkey without its key material
+type PasskeyResponse struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Transports     []string   `json:"transports,omitempty"`
+	BackupEligible bool       `json:"backupEligible"`
+	BackupState    bool       `json:"backupState"`
+	LastUsedAt     *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+func NewPasskeyResponse(p *domain.Passkey) PasskeyResponse {
+	return PasskeyResponse{
+		ID:             p.ID,
+		Name:           p.Name,
+		Transports:     p.Transports,
+		BackupEligible: p.BackupEligible,
+		BackupState:    p.BackupState,
+		LastUsedAt:     p.LastUsedAt,
+		CreatedAt:      p.CreatedAt,
+	}
+}
+
+func NewPasskeyResponses(passkeys []domain.Passkey) []PasskeyResponse {
+	resp := make([]PasskeyResponse, len(passkeys))
+	for i := range passkeys {
+		resp[i] = NewPasskeyResponse(&passkeys[i])
+	}
+	return resp
+}
+
+// APITokenResponse describes an API token; the secret is only ever in
+// CreatedAPIToken
+type APITokenResponse struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"createdBy"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIp,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func NewAPITokenResponse(t *domain.APIToken) APITokenResponse {
+	return APITokenResponse{
+		ID:         t.ID,
+		UserID:     t.UserID,
+		Name:       t.Name,
+		Scopes:     t.Scopes,
+		CreatedBy:  t.CreatedBy,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		LastUsedIP: t.LastUsedIP,
+		CreatedAt:  t.CreatedAt,
+	}
+}
+
+func NewAPITokenResponses(tokens []domain.APIToken) []APITokenResponse {
+	resp := make([]APITokenResponse, len(tokens))
+	for i := range tokens {
+		resp[i] = NewAPITokenResponse(&tokens[i])
+	}
+	return resp
+}
+
+// CreatedAPIToken answers the creation of an API token: the secret, shown
+// this once, and the token's details
+type CreatedAPIToken struct {
+	Token    string           `json:"token"`
+	APIToken APITokenResponse `json:"apiToken"`
+}
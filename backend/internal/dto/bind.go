@@ -0,0 +1,88 @@
+package dto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// Bind parses the request body into req and, for the request types here,
+// checks it with their Validate method. Every failure is returned as a
+// *domain.ValidationError; a member of the wrong JSON type is reported
+// against that member.
+func Bind(c *fiber.Ctx, req interface{}) error {
+	if err := c.BodyParser(req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return &domain.ValidationError{Fields: []domain.FieldError{{
+				Field:   typeErr.Field,
+				Code:    domain.CodeInvalidType,
+				Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonKind(typeErr.Type)),
+			}}}
+		}
+		return &domain.ValidationError{Fields: []domain.FieldError{{
+			Code:    domain.CodeInvalidJSON,
+			Message: "The request body must be a JSON object",
+		}}}
+	}
+	if v, ok := req.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// BindQuery parses the query string into req and checks it like Bind. A
+// parameter that does not convert, such as a non-numeric limit, is reported
+// against that parameter.
+func BindQuery(c *fiber.Ctx, req interface{}) error {
+	if err := c.QueryParser(req); err != nil {
+		var multi fiber.MultiError
+		if !errors.As(err, &multi) {
+			return &domain.ValidationError{Fields: []domain.FieldError{{
+				Code:    domain.CodeInvalid,
+				Message: "The query string is malformed",
+			}}}
+		}
+		params := make([]string, 0, len(multi))
+		for param := range multi {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+		var v domain.Validator
+		for _, param := range params {
+			var convErr fiber.ConversionError
+			if errors.As(multi[param], &convErr) {
+				v.Add(param, domain.CodeInvalidType, fmt.Sprintf("%s must be %s", param, jsonKind(convErr.Type)))
+			} else {
+				v.Add(param, domain.CodeInvalid, param+" is not valid here")
+			}
+		}
+		return v.Err()
+	}
+	if v, ok := req.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// jsonKind describes the JSON value that decodes into t
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
@@ -0,0 +1,276 @@
+// Package dto holds the request and response bodies of the HTTP API. Users
+// leave the API only through these types, so fields such as the password
+// hash, TOTP secret and recovery codes cannot be serialized by accident.
+package dto
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// DirectoryUser is what any signed-in user may see about a colleague
+type DirectoryUser struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Role             string `json:"role"`
+	Department       string `json:"department"`
+	JobTitle         string `json:"jobTitle"`
+	ReportingManager string `json:"reportingManager"`
+	Status           string `json:"status"`
+	Avatar           string `json:"avatar"`
+}
+
+// UserResponse is the full profile with account state, for admins and for
+// users looking at themselves. Secrets are never included.
+type UserResponse struct {
+	DirectoryUser
+	StaffNumber     string    `json:"staffNumber"`
+	NeedsOnboarding bool      `json:"needsOnboarding"`
+	CreatedAt       time.Time `json:"createdAt"`
+
+	FailedLoginAttempts int        `json:"failedLoginAttempts,omitempty"`
+	LockedUntil         *time.Time `json:"lockedUntil,omitempty"`
+	MustResetPassword   bool       `json:"mustResetPassword,omitempty"`
+	TOTPEnabled         bool       `json:"totpEnabled"`
+	SSOLinked           bool       `json:"ssoLinked"`
+}
+
+func NewDirectoryUser(u *domain.User) DirectoryUser {
+	return DirectoryUser{
+		ID:               u.ID,
+		Name:             u.Name,
+		Email:            u.Email,
+		Role:             u.Role,
+		Department:       u.Department,
+		JobTitle:         u.JobTitle,
+		ReportingManager: u.ReportingManager,
+		Status:           u.Status,
+		Avatar:           u.Avatar,
+	}
+}
+
+func NewUserResponse(u *domain.User) *UserResponse {
+	return &UserResponse{
+		DirectoryUser:       NewDirectoryUser(u),
+		StaffNumber:         u.StaffNumber,
+		NeedsOnboarding:     u.NeedsOnboarding,
+		CreatedAt:           u.CreatedAt,
+		FailedLoginAttempts: u.FailedLoginAttempts,
+		LockedUntil:         u.LockedUntil,
+		MustResetPassword:   u.MustResetPassword,
+		TOTPEnabled:         u.TOTPEnabled,
+		SSOLinked:           u.OIDCSubject != "",
+	}
+}
+
+// Viewer is who a user is being shown to
+type Viewer struct {
+	ID      string
+	IsAdmin bool
+}
+
+// UserFor returns u as viewer may see it: the full profile for admins and
+// for the user themselves, directory fields for everyone else
+func UserFor(u *domain.User, viewer Viewer) interface{} {
+	if viewer.IsAdmin || viewer.ID == u.ID {
+		return NewUserResponse(u)
+	}
+	return NewDirectoryUser(u)
+}
+
+// UsersFor maps users with UserFor
+func UsersFor(users []domain.User, viewer Viewer) []interface{} {
+	out := make([]interface{}, len(users))
+	for i := range users {
+		out[i] = UserFor(&users[i], viewer)
+	}
+	return out
+}
+
+// OrgNode is a user in the organisation chart with their reports
+type OrgNode struct {
+	DirectoryUser
+	Reports []OrgNode `json:"reports"`
+}
+
+func NewOrgNodes(nodes []*domain.OrgNode) []OrgNode {
+	out := make([]OrgNode, len(nodes))
+	for i, n := range nodes {
+		out[i] = OrgNode{DirectoryUser: NewDirectoryUser(n.User), Reports: NewOrgNodes(n.Reports)}
+	}
+	return out
+}
+
+// OrgView is a user's place in the organisation chart: their managers,
+// nearest first, and the tree of their direct and indirect reports
+type OrgView struct {
+	User     DirectoryUser   `json:"user"`
+	Managers []DirectoryUser `json:"managers"`
+	Reports  []OrgNode       `json:"reports"`
+}
+
+func NewOrgView(chart *domain.OrgChart, node *domain.OrgNode) OrgView {
+	managers := chart.Managers(node.User.ID)
+	view := OrgView{
+		User:     NewDirectoryUser(node.User),
+		Managers: make([]DirectoryUser, len(managers)),
+		Reports:  NewOrgNodes(node.Reports),
+	}
+	for i, m := range managers {
+		view.Managers[i] = NewDirectoryUser(m)
+	}
+	return view
+}
+
+// UserListQuery is the query string of GET /api/users: filters, a search
+// term, the sort key ("-" prefixed for descending), the page size and the
+// nextCursor of the previous page
+type UserListQuery struct {
+	Role             string `query:"role"`
+	Department       string `query:"department"`
+	Status           string `query:"status"`
+	ReportingManager string `query:"reportingManager"`
+	Search           string `query:"search"`
+	Sort             string `query:"sort"`
+	Limit            int    `query:"limit"`
+	Cursor           string `query:"cursor"`
+}
+
+func (q *UserListQuery) Validate() error {
+	var v domain.Validator
+	v.OneOf("role", "Role", q.Role, domain.Roles)
+	v.OneOf("status", "Status", q.Status, domain.Statuses)
+	v.MaxLength("department", "Department", q.Department, domain.MaxTextLength)
+	v.MaxLength("reportingManager", "Reporting manager", q.ReportingManager, domain.MaxIDLength)
+	v.MaxLength("search", "Search", q.Search, domain.MaxTextLength)
+	if q.Sort != "" && !slices.Contains(domain.UserSortKeys, strings.TrimPrefix(q.Sort, "-")) {
+		v.Add("sort", domain.CodeNotAllowed, "Sort must be one of "+strings.Join(domain.UserSortKeys, ", ")+
+			", optionally prefixed with - for descending")
+	}
+	if q.Limit < 0 || q.Limit > domain.MaxPageSize {
+		v.Add("limit", domain.CodeInvalid, fmt.Sprintf("Limit must be between 1 and %d", domain.MaxPageSize))
+	}
+	return v.Err()
+}
+
+func (q *UserListQuery) ToDomain() domain.UserQuery {
+	return domain.UserQuery{
+		Role:             q.Role,
+		Department:       strings.TrimSpace(q.Department),
+		Status:           q.Status,
+		ReportingManager: q.ReportingManager,
+		Search:           strings.TrimSpace(q.Search),
+		Sort:             q.Sort,
+		Limit:            q.Limit,
+		After:            q.Cursor,
+	}
+}
+
+// UserPage is a page of GET /api/users. NextCursor, passed as cursor,
+// fetches the next page; it is left out on the last one.
+type UserPage struct {
+	Users      []interface{} `json:"users"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// UserRequest is the body of POST /api/users and PUT /api/users/:id.
+// Account state and the ID are the server's to set and cannot be sent.
+type UserRequest struct {
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Password         string `json:"password"` // Empty keeps the current one on update
+	Role             string `json:"role"`
+	Department       string `json:"department"`
+	JobTitle         string `json:"jobTitle"`
+	ReportingManager string `json:"reportingManager"`
+	StaffNumber      string `json:"staffNumber"` // Assigned by the server on create
+	Status           string `json:"status"`
+	Avatar           string `json:"avatar"`
+	NeedsOnboarding  bool   `json:"needsOnboarding"`
+}
+
+func (r *UserRequest) ToDomain() *domain.User {
+	return &domain.User{
+		Name:             r.Name,
+		Email:            r.Email,
+		Password:         r.Password,
+		Role:             r.Role,
+		Department:       r.Department,
+		JobTitle:         r.JobTitle,
+		ReportingManager: r.ReportingManager,
+		StaffNumber:      r.StaffNumber,
+		Status:           r.Status,
+		Avatar:           r.Avatar,
+		NeedsOnboarding:  r.NeedsOnboarding,
+	}
+}
+
+// InvitationRequest is the body of POST /api/invitations: the new hire's
+// profile as far as the admin knows it
+type InvitationRequest struct {
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Role             string `json:"role"`
+	Department       string `json:"department"`
+	JobTitle         string `json:"jobTitle"`
+	ReportingManager string `json:"reportingManager"`
+}
+
+func (r *InvitationRequest) ToDomain() *domain.User {
+	return &domain.User{
+		Name:             r.Name,
+		Email:            r.Email,
+		Role:             r.Role,
+		Department:       r.Department,
+		JobTitle:         r.JobTitle,
+		ReportingManager: r.ReportingManager,
+	}
+}
+
+// InvitationResponse is a pending invitation without its link token
+type InvitationResponse struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	InvitedBy string    `json:"invitedBy"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	SentAt    time.Time `json:"sentAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func NewInvitationResponse(i *domain.Invitation) InvitationResponse {
+	return InvitationResponse{
+		ID:        i.ID,
+		UserID:    i.UserID,
+		Email:     i.Email,
+		InvitedBy: i.InvitedBy,
+		ExpiresAt: i.ExpiresAt,
+		SentAt:    i.SentAt,
+		CreatedAt: i.CreatedAt,
+	}
+}
+
+func NewInvitationResponses(invitations []domain.Invitation) []InvitationResponse {
+	resp := make([]InvitationResponse, len(invitations))
+	for i := range invitations {
+		resp[i] = NewInvitationResponse(&invitations[i])
+	}
+	return resp
+}
+
+// InvitedUser is what an invitation link reveals before it is accepted
+type InvitedUser struct {
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Department string `json:"department"`
+	JobTitle   string `json:"jobTitle"`
+}
+
+func NewInvitedUser(u *domain.User) InvitedUser {
+	return InvitedUser{Name: u.Name, Email: u.Email, Department: u.Department, JobTitle: u.JobTitle}
+}
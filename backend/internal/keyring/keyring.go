@@ -0,0 +1,311 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms (JWS "alg" values)
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+const rsaKeyBits = 2048
+
+// Key file names in the key directory. The file name without its suffix is
+// the key ID that goes into the token's "kid" header.
+const (
+	privateSuffix = ".pem"     // PKCS#8 (or PKCS#1 RSA) private key: signs and verifies
+	publicSuffix  = ".pub.pem" // PKIX public key: verifies only, for retired keys
+)
+
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+
+	signer crypto.Signer // nil for verification-only keys
+}
+
+// KeyRing holds the keys used to sign and verify access tokens. Every key in
+// the directory is accepted for verification and published in the JWKS, but
+// only the active one signs. Rotating is therefore: drop a new key in, let
+// other services pick it up from the JWKS, make it active, and remove the
+// old one once the tokens it signed have expired. A new key never becomes
+// active on its own, since services that have not fetched it yet would
+// reject what it signs.
+type KeyRing struct {
+	dir         string
+	activeKID   string // Empty means the only private key, or the one already active
+	generateAlg string // Algorithm of the key created when the directory is empty
+
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active *Key
+}
+
+// Load reads every key in dir. If dir holds no private key, one is
+// generated so a fresh checkout can start without any setup.
+func Load(dir, activeKID, generateAlg string) (*KeyRing, error) {
+	if generateAlg != AlgEdDSA && generateAlg != AlgRS256 {
+		return nil, fmt.Errorf("unsupported JWT key algorithm %q (expected %q or %q)", generateAlg, AlgEdDSA, AlgRS256)
+	}
+	r := &KeyRing{dir: dir, activeKID: activeKID, generateAlg: generateAlg}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the key directory. On error the current keys stay in use.
+func (r *KeyRing) Reload() error {
+	keys, err := readKeys(r.dir)
+	if err != nil {
+		return err
+	}
+
+	if !hasSigner(keys) {
+		if r.activeKID != "" {
+			return fmt.Errorf("active JWT key %q not found in %s", r.activeKID, r.dir)
+		}
+		key, err := generateKey(r.dir, r.generateAlg)
+		if err != nil {
+			return fmt.Errorf("generate JWT signing key: %w", err)
+		}
+		log.Printf("No JWT signing key in %s, generated %s key %q", r.dir, key.Algorithm, key.ID)
+		keys[key.ID] = key
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	active, err := pickActive(keys, r.activeKID, r.active)
+	if err != nil {
+		return err
+	}
+	r.keys = keys
+	r.active = active
+	return nil
+}
+
+func hasSigner(keys map[string]*Key) bool {
+	for _, k := range keys {
+		if k.signer != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// pickActive returns the key named by activeKID or, without one, the only
+// private key. With several, current (the key active before a reload) keeps
+// signing if it is still there; otherwise activeKID must choose.
+func pickActive(keys map[string]*Key, activeKID string, current *Key) (*Key, error) {
+	if activeKID != "" {
+		k, ok := keys[activeKID]
+		if !ok || k.signer == nil {
+			return nil, fmt.Errorf("active JWT key %q has no private key", activeKID)
+		}
+		return k, nil
+	}
+
+	var signers []*Key
+	for _, k := range keys {
+		if k.signer != nil {
+			signers = append(signers, k)
+		}
+	}
+	if len(signers) == 1 {
+		return signers[0], nil
+	}
+	if current != nil {
+		if k := keys[current.ID]; k != nil && k.signer != nil {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%d private keys and none active; set JWT_ACTIVE_KID to the one that signs", len(signers))
+}
+
+// Sign issues a token for claims with the active key, tagged with its kid
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	r.mu.RLock()
+	active := r.active
+	r.mu.RUnlock()
+
+	token := jwt.NewWithClaims(signingMethod(active.Algorithm), claims)
+	token.Header["kid"] = active.ID
+	return token.SignedString(active.signer)
+}
+
+// Parse verifies tokenString against the key named by its kid header
+func (r *KeyRing) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, r.keyfunc, jwt.WithValidMethods([]string{AlgEdDSA, AlgRS256}))
+}
+
+func (r *KeyRing) keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	r.mu.RLock()
+	key, ok := r.keys[kid]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if t.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("token alg %s does not match key %q", t.Method.Alg(), kid)
+	}
+	return key.Public, nil
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	if alg == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// readKeys loads every *.pem file in dir; a missing dir is an empty ring
+func readKeys(dir string) (map[string]*Key, error) {
+	keys := map[string]*Key{}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, privateSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+
+		key, err := readKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if prev, ok := keys[key.ID]; ok {
+			// Both halves of the same key: keep the one that can sign
+			if prev.signer != nil {
+				continue
+			}
+		}
+		keys[key.ID] = key
+	}
+	return keys, nil
+}
+
+func readKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	name := filepath.Base(path)
+	key := &Key{}
+
+	if strings.HasSuffix(name, publicSuffix) {
+		key.ID = strings.TrimSuffix(name, publicSuffix)
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.Public = pub
+	} else {
+		key.ID = strings.TrimSuffix(name, privateSuffix)
+		var priv interface{}
+		if block.Type == "RSA PRIVATE KEY" {
+			priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		key.signer = signer
+		key.Public = signer.Public()
+	}
+
+	switch pub := key.Public.(type) {
+	case ed25519.PublicKey:
+		key.Algorithm = AlgEdDSA
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < rsaKeyBits {
+			return nil, fmt.Errorf("RSA key is %d bits, need at least %d", pub.N.BitLen(), rsaKeyBits)
+		}
+		key.Algorithm = AlgRS256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T (expected Ed25519 or RSA)", key.Public)
+	}
+	return key, nil
+}
+
+// generateKey creates a new private key file in dir named after a
+// date-prefixed random kid, so later keys sort after earlier ones
+func generateKey(dir, alg string) (*Key, error) {
+	var signer crypto.Signer
+	var err error
+	if alg == AlgRS256 {
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	} else {
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	kid := time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(suffix)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, kid+privateSuffix)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:        kid,
+		Algorithm: alg,
+		Public:    signer.Public(),
+		signer:    signer,
+	}, nil
+}
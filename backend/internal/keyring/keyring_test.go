@@ -0,0 +1,171 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey stores a new Ed25519 key as dir/<kid>.pem and returns it
+func writeKey(t *testing.T, dir, kid string) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+privateSuffix), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+// signedKID signs a token with the active key and returns its kid
+func signedKID(t *testing.T, r *KeyRing) string {
+	t.Helper()
+	signed, err := r.Sign(jwt.RegisteredClaims{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := r.Parse(signed)
+	if err != nil {
+		t.Fatalf("the ring rejected its own token: %v", err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+func TestLoadGeneratesAKey(t *testing.T) {
+	for _, alg := range []string{AlgEdDSA, AlgRS256} {
+		dir := filepath.Join(t.TempDir(), "keys")
+		r, err := Load(dir, "", alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set := r.JWKS()
+		if len(set.Keys) != 1 || set.Keys[0].Algorithm != alg {
+			t.Fatalf("%s: JWKS = %+v, want one generated key", alg, set)
+		}
+		if kid := signedKID(t, r); kid != set.Keys[0].KeyID {
+			t.Errorf("%s: signed with %q, want the generated %q", alg, kid, set.Keys[0].KeyID)
+		}
+
+		// A restart finds the generated key rather than making another
+		again, err := Load(dir, "", alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kid := signedKID(t, again); kid != set.Keys[0].KeyID {
+			t.Errorf("%s: after a restart signed with %q, want %q", alg, kid, set.Keys[0].KeyID)
+		}
+	}
+}
+
+// TestAddedKeyDoesNotSign walks through a rotation: a key added and
+// reloaded is published but does not sign until JWT_ACTIVE_KID names it
+func TestAddedKeyDoesNotSign(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old")
+	r, err := Load(dir, "", AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := r.Sign(jwt.RegisteredClaims{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKey(t, dir, "new")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.JWKS().Keys); n != 2 {
+		t.Errorf("JWKS has %d keys after the reload, want 2", n)
+	}
+	if kid := signedKID(t, r); kid != "old" {
+		t.Errorf("signed with %q after adding a key, want the current %q", kid, "old")
+	}
+
+	// Without a choice a fresh start cannot tell which key signs
+	if _, err := Load(dir, "", AlgEdDSA); err == nil {
+		t.Error("loaded two private keys without JWT_ACTIVE_KID")
+	}
+
+	rotated, err := Load(dir, "new", AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := signedKID(t, rotated); kid != "new" {
+		t.Errorf("signed with %q, want the selected %q", kid, "new")
+	}
+	if _, err := rotated.Parse(oldToken); err != nil {
+		t.Errorf("a token of the retiring key no longer verifies: %v", err)
+	}
+
+	// Dropping the active key leaves the other one as the only signer
+	if err := os.Remove(filepath.Join(dir, "old"+privateSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if kid := signedKID(t, r); kid != "new" {
+		t.Errorf("signed with %q after removing the old key, want %q", kid, "new")
+	}
+}
+
+func TestPublicKeyOnlyVerifies(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "current")
+	retired := writeKey(t, dir, "retired")
+	der, err := x509.MarshalPKIXPublicKey(retired.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "retired"+privateSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "retired"+publicSuffix), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Load(dir, "", AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := signedKID(t, r); kid != "current" {
+		t.Errorf("signed with %q, want the only private key", kid)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.RegisteredClaims{Subject: "user-1"})
+	token.Header["kid"] = "retired"
+	signed, err := token.SignedString(retired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Parse(signed); err != nil {
+		t.Errorf("a token of the retired key does not verify: %v", err)
+	}
+	token.Header["kid"] = "unknown"
+	if signed, err = token.SignedString(retired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Parse(signed); err == nil {
+		t.Error("accepted a token of an unknown kid")
+	}
+
+	if _, err := Load(dir, "retired", AlgEdDSA); err == nil {
+		t.Error("made a public key active")
+	}
+}
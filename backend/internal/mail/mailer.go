@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"fmt"
+
+	"github.com/stacklevest/backend/internal/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers transactional email (OTP codes, invitations, resets)
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns the Mailer selected by cfg.MailDriver
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "file":
+		return NewFileMailer(cfg.MailDropDir, cfg.MailFrom)
+	case "log":
+		return NewLogMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_DRIVER %q (expected \"smtp\", \"file\" or \"log\")", cfg.MailDriver)
+}
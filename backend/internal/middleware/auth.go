@@ -1,52 +1,101 @@
 package middleware
 
 import (
+	"errors"
+	"slices"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/stacklevest/backend/internal/config"
 	"github.com/stacklevest/backend/internal/domain"
 )
 
-func AuthMiddleware(cfg *config.Config) fiber.Handler {
+// TokenValidator verifies access tokens, returning their claims, and API
+// tokens, returning the token and its owner (implemented by
+// auth.AuthService)
+type TokenValidator interface {
+	ValidateAccessToken(tokenString string) (jwt.MapClaims, error)
+	ValidateAPIToken(tokenString, ip string) (*domain.APIToken, *domain.User, error)
+}
+
+func AuthMiddleware(tokens TokenValidator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing Authorization header"})
+			return domain.Unauthorized("missing_token", "Missing Authorization header")
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid Authorization header format"})
+			return domain.Unauthorized("invalid_token", "Invalid Authorization header format")
 		}
 		tokenString := parts[1]
 
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.ErrUnauthorized
-			}
-			return []byte(cfg.JWTSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
+		if strings.HasPrefix(tokenString, domain.APITokenPrefix) {
+			return apiTokenAuth(c, tokens, tokenString)
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token claims"})
+		claims, err := tokens.ValidateAccessToken(tokenString)
+		if errors.Is(err, domain.ErrTokenRevoked) {
+			return domain.ErrTokenRevoked
+		}
+		if err != nil {
+			return domain.Unauthorized("invalid_token", "Invalid or expired token")
 		}
 
 		// Store user info in context
 		c.Locals("user_id", claims["id"])
 		c.Locals("email", claims["email"])
 		c.Locals("role", claims["role"])
+		c.Locals("session_id", claims["sid"])
 
 		return c.Next()
 	}
 }
 
+// apiTokenAuth lets an API token through as its owner if it has the scope
+// for the route: "<resource>:read" for GET and HEAD under /api/<resource>,
+// "<resource>:write" otherwise. Routes outside every scope, such as account
+// security under /api/auth, are closed to API tokens.
+func apiTokenAuth(c *fiber.Ctx, tokens TokenValidator, tokenString string) error {
+	token, owner, err := tokens.ValidateAPIToken(tokenString, c.IP())
+	if err != nil {
+		return domain.Unauthorized("invalid_token", "Invalid or expired API token")
+	}
+
+	scope := requiredScope(c)
+	if !token.HasScope(scope) {
+		return domain.Forbidden("missing_scope", "API token lacks the "+scope+" scope")
+	}
+
+	c.Locals("user_id", owner.ID)
+	c.Locals("email", owner.Email)
+	c.Locals("role", owner.Role)
+	c.Locals("api_token_id", token.ID)
+	c.Locals("token_scopes", token.Scopes)
+
+	return c.Next()
+}
+
+func requiredScope(c *fiber.Ctx) string {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(c.Path(), "/api/"), "/")
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// IsAdmin reports whether the caller acts with the admin role; an API token
+// only does with the admin scope
+func IsAdmin(c *fiber.Ctx) bool {
+	role, _ := c.Locals("role").(string)
+	if !strings.EqualFold(role, domain.RoleAdmin) {
+		return false
+	}
+	scopes, viaToken := c.Locals("token_scopes").([]string)
+	return !viaToken || slices.Contains(scopes, domain.ScopeAdmin)
+}
+
 func AdminGuard(c *fiber.Ctx) error {
 	return RoleGuard(domain.RoleAdmin)(c)
 }
@@ -55,18 +104,22 @@ func RoleGuard(allowedRoles ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		role, ok := c.Locals("role").(string)
 		if !ok {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Role not found"})
+			return domain.Forbidden("forbidden", "Role not found")
 		}
 
 		role = strings.ToLower(role)
 		for _, allowed := range allowedRoles {
 			if role == strings.ToLower(allowed) {
+				// An API token only carries its owner's admin role if it
+				// was given the admin scope
+				scopes, viaToken := c.Locals("token_scopes").([]string)
+				if viaToken && role == domain.RoleAdmin && !slices.Contains(scopes, domain.ScopeAdmin) {
+					return domain.Forbidden("missing_scope", "API token lacks the admin scope")
+				}
 				return c.Next()
 			}
 		}
 
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "Insufficient privileges",
-		})
+		return domain.Forbidden("insufficient_role", "Insufficient privileges")
 	}
 }
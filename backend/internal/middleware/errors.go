@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// kindStatuses maps the domain error kinds to HTTP statuses
+var kindStatuses = []struct {
+	kind   error
+	status int
+}{
+	{domain.ErrValidation, fiber.StatusBadRequest},
+	{domain.ErrUnauthorized, fiber.StatusUnauthorized},
+	{domain.ErrForbidden, fiber.StatusForbidden},
+	{domain.ErrNotFound, fiber.StatusNotFound},
+	{domain.ErrConflict, fiber.StatusConflict},
+	{domain.ErrLocked, fiber.StatusLocked},
+	{domain.ErrRateLimited, fiber.StatusTooManyRequests},
+	{domain.ErrUpstream, fiber.StatusBadGateway},
+}
+
+// RequestID tags every request with an ID, taken from X-Request-ID if the
+// client sent one, echoed in the response header and in error bodies
+func RequestID() fiber.Handler {
+	return requestid.New(requestid.Config{ContextKey: "request_id"})
+}
+
+// ErrorHandler answers every error a handler returns with the same JSON
+// envelope:
+//
+//	{"error": "<message>", "code": "<code>", "requestId": "<id>"}
+//
+// plus "fields" for a *domain.ValidationError and the Details of a
+// *domain.Error. The status comes from the error's kind. Errors of no kind
+// are internal: they are logged with the request ID and answered with a
+// generic 500, so their text never reaches the client.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	requestID, _ := c.Locals("request_id").(string)
+	body := fiber.Map{"requestId": requestID}
+	status := fiber.StatusInternalServerError
+
+	var invalid *domain.ValidationError
+	var domainErr *domain.Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &invalid):
+		status = fiber.StatusBadRequest
+		body["error"] = "Some fields are invalid"
+		body["code"] = "invalid_fields"
+		body["fields"] = invalid.Fields
+	case errors.As(err, &domainErr):
+		for _, ks := range kindStatuses {
+			if errors.Is(domainErr.Kind, ks.kind) {
+				status = ks.status
+				break
+			}
+		}
+		for k, v := range domainErr.Details {
+			body[k] = v
+		}
+		body["error"] = domainErr.Message
+		body["code"] = domainErr.Code
+	case errors.As(err, &fiberErr):
+		// Raised by Fiber itself, such as for an unknown route
+		status = fiberErr.Code
+		body["error"] = fiberErr.Message
+		body["code"] = strings.ReplaceAll(strings.ToLower(utils.StatusMessage(status)), " ", "_")
+	}
+
+	if status == fiber.StatusInternalServerError {
+		log.Printf("[%s] %s %s: %v", requestID, c.Method(), c.OriginalURL(), err)
+		body["error"] = "Something went wrong on our side; quote the request ID if you report it"
+		body["code"] = "internal_error"
+	}
+	return c.Status(status).JSON(body)
+}
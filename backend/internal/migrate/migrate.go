@@ -0,0 +1,486 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CollectionReport summarises what happened to one collection
+type CollectionReport struct {
+	Name     string
+	Source   int      // Records in db.json
+	Inserted int      // Written (or, in a dry run, would be written)
+	Existing int      // Already present in the target, left untouched
+	Skipped  []string // Records that cannot be migrated, with the reason
+	Notes    []string // Legacy fix-ups applied along the way
+
+	// NotMigrated says why the whole collection was left out, such as a
+	// collection this version of the tool has no step for
+	NotMigrated string
+}
+
+type Report struct {
+	DryRun      bool
+	Collections []*CollectionReport
+}
+
+// OpenTarget opens the SQLite database at path to migrate into. A dry run
+// must neither create nor change it: an existing target is opened read-only,
+// so records already in it are reported as such, and a missing one is
+// stood in for by an empty database in memory, as a real run would start
+// from.
+func OpenTarget(path string, dryRun bool) (*storage.SQLiteStore, error) {
+	if !dryRun {
+		return storage.NewSQLiteStore(path)
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return storage.NewSQLiteStore(":memory:")
+	}
+	return storage.NewReadOnlySQLiteStore(path)
+}
+
+// Run copies every collection of src into dst. Records whose ID already
+// exists in dst are left alone, so running it again after a partial
+// failure only fills in what is missing. After writing, it checks that
+// every migrated ID can be read back from dst. A collection of db.json
+// without a step is reported as not migrated rather than silently dropped.
+func Run(src *storage.DB, dst storage.Store, dryRun bool) (*Report, error) {
+	report := &Report{DryRun: dryRun}
+
+	steps := []func(*storage.DB, storage.Store, bool) (*CollectionReport, error){
+		migrateUsers,
+		migrateSessions,
+		migrateAuthEvents,
+		migrateRevokedTokens,
+		migratePasskeys,
+		migrateAPITokens,
+		migrateInvitations,
+		migrateSequences,
+		migrateChannels,
+		migrateMessages,
+		migrateTasks,
+	}
+	migrated := map[string]bool{}
+	for _, step := range steps {
+		r, err := step(src, dst, dryRun)
+		if r != nil {
+			report.Collections = append(report.Collections, r)
+			migrated[r.Name] = true
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+
+	db := reflect.ValueOf(src).Elem()
+	for i := 0; i < db.NumField(); i++ {
+		name, _, _ := strings.Cut(db.Type().Field(i).Tag.Get("json"), ",")
+		if name != "" && !migrated[name] {
+			report.Collections = append(report.Collections, &CollectionReport{
+				Name:        name,
+				Source:      db.Field(i).Len(),
+				NotMigrated: "no migration step for this collection",
+			})
+		}
+	}
+	return report, nil
+}
+
+// copyCollection is the shared skeleton of every step: it skips records
+// that are invalid or already present, inserts the rest and verifies the
+// target afterwards. validate may fix up a record in place and returns a
+// non-empty reason to skip it.
+func copyCollection[T any](
+	r *CollectionReport,
+	items []T,
+	idOf func(T) string,
+	existingIDs func() (map[string]bool, error),
+	validate func(*T) string,
+	insert func(*T) error,
+	dryRun bool,
+) error {
+	r.Source = len(items)
+
+	existing, err := existingIDs()
+	if err != nil {
+		return fmt.Errorf("%s: read target: %w", r.Name, err)
+	}
+
+	seen := map[string]bool{}
+	var expected []string
+	for i := range items {
+		item := items[i]
+		id := idOf(item)
+		switch {
+		case id == "":
+			r.Skipped = append(r.Skipped, fmt.Sprintf("record #%d: missing id", i))
+			continue
+		case seen[id]:
+			r.Skipped = append(r.Skipped, fmt.Sprintf("%s: duplicate id", id))
+			continue
+		}
+		seen[id] = true
+
+		if existing[id] {
+			r.Existing++
+			expected = append(expected, id)
+			continue
+		}
+		if reason := validate(&item); reason != "" {
+			r.Skipped = append(r.Skipped, fmt.Sprintf("%s: %s", id, reason))
+			continue
+		}
+
+		if !dryRun {
+			if err := insert(&item); err != nil {
+				return fmt.Errorf("%s: insert %s: %w", r.Name, id, err)
+			}
+		}
+		r.Inserted++
+		expected = append(expected, id)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	// Verify row counts: every inserted or pre-existing ID must be readable
+	present, err := existingIDs()
+	if err != nil {
+		return fmt.Errorf("%s: verify: %w", r.Name, err)
+	}
+	found := 0
+	for _, id := range expected {
+		if present[id] {
+			found++
+		}
+	}
+	if found != len(expected) {
+		return fmt.Errorf("%s: verification failed: expected %d rows in target, found %d", r.Name, len(expected), found)
+	}
+	return nil
+}
+
+func idSet[T any](items []T, err error, idOf func(T) string) (map[string]bool, error) {
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(items))
+	for _, item := range items {
+		ids[idOf(item)] = true
+	}
+	return ids, nil
+}
+
+func userID(u domain.User) string { return u.ID }
+
+func migrateUsers(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "users"}
+	existingIDs := func() (map[string]bool, error) {
+		users, err := dst.FindAll()
+		return idSet(users, err, userID)
+	}
+
+	emails := map[string]string{} // lower-cased email -> first user ID claiming it
+	hashed := 0
+	err := copyCollection(r, src.Users, userID, existingIDs,
+		func(u *domain.User) string {
+			key := strings.ToLower(strings.TrimSpace(u.Email))
+			if key == "" {
+				return "missing email"
+			}
+			if owner, ok := emails[key]; ok && owner != u.ID {
+				return fmt.Sprintf("email %s already used by %s", u.Email, owner)
+			}
+			emails[key] = u.ID
+
+			if other, err := dst.FindByEmail(u.Email); err == nil && other != nil && other.ID != u.ID {
+				return fmt.Sprintf("email %s already used by %s in target", u.Email, other.ID)
+			}
+
+			// Legacy rows hold plaintext passwords next to bcrypt hashes.
+			// Hash them now so no plaintext lands in the new store; Login
+			// already accepts the result.
+			if u.Password != "" {
+				if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+					h, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+					if err != nil {
+						return "hash password: " + err.Error()
+					}
+					u.Password = string(h)
+					hashed++
+				}
+			}
+			return ""
+		},
+		dst.Create, dryRun)
+
+	if hashed > 0 {
+		r.Notes = append(r.Notes, fmt.Sprintf("hashed %d plaintext passwords", hashed))
+	}
+	return r, err
+}
+
+func migrateSessions(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "sessions"}
+	sessionID := func(s domain.UserSession) string { return s.ID }
+	existingIDs := func() (map[string]bool, error) {
+		sessions, err := dst.FindAllSessions()
+		return idSet(sessions, err, sessionID)
+	}
+
+	knownUser := userCheck(src, dst, dryRun)
+	err := copyCollection(r, src.Sessions, sessionID, existingIDs,
+		func(s *domain.UserSession) string { return knownUser(s.UserID) },
+		dst.CreateSession, dryRun)
+	return r, err
+}
+
+// userCheck returns a validate helper giving a reason to skip a record
+// whose user is not in dst. In a dry run the users were not written, so it
+// also accepts users that the users step would have inserted.
+func userCheck(src *storage.DB, dst storage.Store, dryRun bool) func(id string) string {
+	srcUsers, _ := idSet(src.Users, nil, userID)
+	return func(id string) string {
+		u, err := dst.FindByID(id)
+		if err != nil {
+			return "look up user: " + err.Error()
+		}
+		if u == nil && !(dryRun && srcUsers[id]) {
+			return fmt.Sprintf("unknown user %s", id)
+		}
+		return ""
+	}
+}
+
+// Auth events are an audit trail and are kept even for deleted users
+func migrateAuthEvents(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "authEvents"}
+	eventID := func(e domain.AuthEvent) string { return e.ID }
+	existingIDs := func() (map[string]bool, error) {
+		ids, users := map[string]bool{}, map[string]bool{}
+		for _, e := range src.AuthEvents {
+			if users[e.UserID] {
+				continue
+			}
+			users[e.UserID] = true
+			events, err := dst.FindAuthEventsByUser(e.UserID)
+			if err != nil {
+				return nil, err
+			}
+			for _, ev := range events {
+				ids[ev.ID] = true
+			}
+		}
+		return ids, nil
+	}
+	err := copyCollection(r, src.AuthEvents, eventID, existingIDs,
+		func(*domain.AuthEvent) string { return "" },
+		dst.CreateAuthEvent, dryRun)
+	return r, err
+}
+
+func migrateRevokedTokens(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "revokedTokens"}
+	jti := func(t domain.RevokedToken) string { return t.JTI }
+	existingIDs := func() (map[string]bool, error) {
+		ids := map[string]bool{}
+		for _, t := range src.RevokedTokens {
+			revoked, err := dst.IsTokenRevoked(t.JTI)
+			if err != nil {
+				return nil, err
+			}
+			ids[t.JTI] = revoked
+		}
+		return ids, nil
+	}
+
+	// An entry is only needed until its token would have expired anyway
+	now := time.Now()
+	err := copyCollection(r, src.RevokedTokens, jti, existingIDs,
+		func(t *domain.RevokedToken) string {
+			if !t.ExpiresAt.After(now) {
+				return "token has expired"
+			}
+			return ""
+		},
+		dst.RevokeToken, dryRun)
+	return r, err
+}
+
+func migratePasskeys(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "passkeys"}
+	passkeyID := func(p domain.Passkey) string { return p.ID }
+	existingIDs := func() (map[string]bool, error) {
+		ids := map[string]bool{}
+		for _, p := range src.Passkeys {
+			existing, err := dst.FindPasskey(p.ID)
+			if err != nil {
+				return nil, err
+			}
+			ids[p.ID] = existing != nil
+		}
+		return ids, nil
+	}
+	knownUser := userCheck(src, dst, dryRun)
+	err := copyCollection(r, src.Passkeys, passkeyID, existingIDs,
+		func(p *domain.Passkey) string { return knownUser(p.UserID) },
+		dst.CreatePasskey, dryRun)
+	return r, err
+}
+
+func migrateAPITokens(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "apiTokens"}
+	tokenID := func(t domain.APIToken) string { return t.ID }
+	existingIDs := func() (map[string]bool, error) {
+		ids := map[string]bool{}
+		for _, t := range src.APITokens {
+			existing, err := dst.FindAPITokenBySelector(t.Selector)
+			if err != nil {
+				return nil, err
+			}
+			if existing != nil {
+				ids[existing.ID] = true
+			}
+		}
+		return ids, nil
+	}
+	knownUser := userCheck(src, dst, dryRun)
+	err := copyCollection(r, src.APITokens, tokenID, existingIDs,
+		func(t *domain.APIToken) string {
+			if t.Selector == "" || t.TokenHash == "" {
+				return "missing selector or hash"
+			}
+			if other, err := dst.FindAPITokenBySelector(t.Selector); err == nil && other != nil && other.ID != t.ID {
+				return fmt.Sprintf("selector already used by %s in target", other.ID)
+			}
+			return knownUser(t.UserID)
+		},
+		dst.CreateAPIToken, dryRun)
+	return r, err
+}
+
+func migrateInvitations(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "invitations"}
+	invitationID := func(i domain.Invitation) string { return i.ID }
+	existingIDs := func() (map[string]bool, error) {
+		invitations, err := dst.FindAllInvitations()
+		return idSet(invitations, err, invitationID)
+	}
+	// A user has at most one pending invitation
+	invited := map[string]string{}
+	if existing, err := dst.FindAllInvitations(); err == nil {
+		for _, i := range existing {
+			invited[i.UserID] = i.ID
+		}
+	}
+	knownUser := userCheck(src, dst, dryRun)
+	err := copyCollection(r, src.Invitations, invitationID, existingIDs,
+		func(i *domain.Invitation) string {
+			if other, ok := invited[i.UserID]; ok && other != i.ID {
+				return fmt.Sprintf("user %s already has invitation %s", i.UserID, other)
+			}
+			invited[i.UserID] = i.ID
+			return knownUser(i.UserID)
+		},
+		dst.CreateInvitation, dryRun)
+	return r, err
+}
+
+// migrateSequences seeds each sequence of dst with the highest value it
+// had in src, so values handed out before are never handed out again.
+// Files from before sequences were stored only have the staff numbers, so
+// the staff number sequence is also seeded from the highest of those.
+func migrateSequences(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "sequences", Source: len(src.Sequences)}
+
+	values := map[string]int64{}
+	for _, seq := range src.Sequences {
+		if seq.Name == "" {
+			r.Skipped = append(r.Skipped, "sequence without a name")
+			continue
+		}
+		values[seq.Name] = max(values[seq.Name], seq.Value)
+	}
+	if n := domain.HighestStaffNumber(src.Users); n > values[domain.StaffNumberSequence] {
+		values[domain.StaffNumberSequence] = n
+		r.Notes = append(r.Notes, fmt.Sprintf("%s seeded from the highest staff number, %d", domain.StaffNumberSequence, n))
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !dryRun {
+			if err := dst.SeedSequence(name, values[name]); err != nil {
+				return r, fmt.Errorf("%s: seed %s: %w", r.Name, name, err)
+			}
+		}
+		r.Inserted++
+	}
+	return r, nil
+}
+
+func migrateChannels(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "channels"}
+	channelID := func(c domain.Channel) string { return c.ID }
+	existingIDs := func() (map[string]bool, error) {
+		channels, err := dst.FindAllChannels()
+		return idSet(channels, err, channelID)
+	}
+	err := copyCollection(r, src.Channels, channelID, existingIDs,
+		func(*domain.Channel) string { return "" },
+		dst.CreateChannel, dryRun)
+	return r, err
+}
+
+func migrateMessages(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "messages"}
+	messageID := func(m domain.Message) string { return m.ID }
+	existingIDs := func() (map[string]bool, error) {
+		messages, err := dst.FindAllMessages()
+		return idSet(messages, err, messageID)
+	}
+	err := copyCollection(r, src.Messages, messageID, existingIDs,
+		func(*domain.Message) string { return "" },
+		dst.CreateMessage, dryRun)
+	return r, err
+}
+
+func migrateTasks(src *storage.DB, dst storage.Store, dryRun bool) (*CollectionReport, error) {
+	r := &CollectionReport{Name: "tasks"}
+	taskID := func(t domain.Task) string { return t.ID }
+	existingIDs := func() (map[string]bool, error) {
+		tasks, err := dst.FindAllTasks()
+		return idSet(tasks, err, taskID)
+	}
+
+	// Tasks created before creatorId was tracked keep an empty CreatorID;
+	// the websocket-server treats those as deletable by anyone.
+	legacy := 0
+	err := copyCollection(r, src.Tasks, taskID, existingIDs,
+		func(t *domain.Task) string {
+			if t.CreatorID == "" {
+				legacy++
+			}
+			return ""
+		},
+		dst.CreateTask, dryRun)
+
+	if legacy > 0 {
+		r.Notes = append(r.Notes, fmt.Sprintf("%d legacy tasks without creatorId", legacy))
+	}
+	return r, err
+}
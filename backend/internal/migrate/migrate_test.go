@@ -0,0 +1,200 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/storage"
+)
+
+// testDB holds one record of every collection, plus an expired revoked
+// token and a session of an unknown user, which must be skipped
+func testDB() *storage.DB {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &storage.DB{
+		Users: []domain.User{
+			{ID: "u1", Name: "Ada", Email: "ada@example.com", Password: "plaintext", Role: domain.RoleAdmin, StaffNumber: "SLV-0041", CreatedAt: now},
+			{ID: "u2", Name: "Bob", Email: "bob@example.com", Role: domain.RoleStaff, StaffNumber: "SLV-0007", CreatedAt: now},
+		},
+		Sessions: []domain.UserSession{
+			{ID: "s1", UserID: "u1", Selector: "sel-s1", RefreshToken: "hash", FamilyID: "f1", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+			{ID: "s2", UserID: "u-gone", Selector: "sel-s2", RefreshToken: "hash", FamilyID: "f2", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		},
+		AuthEvents: []domain.AuthEvent{{ID: "e1", UserID: "u1", Type: domain.AuthEventPasswordChanged, CreatedAt: now}},
+		RevokedTokens: []domain.RevokedToken{
+			{JTI: "j1", UserID: "u1", ExpiresAt: now.Add(time.Hour)},
+			{JTI: "j2", UserID: "u1", ExpiresAt: now.Add(-time.Hour)},
+		},
+		Passkeys:    []domain.Passkey{{ID: "p1", UserID: "u1", Name: "Laptop", PublicKey: []byte{1, 2}, CreatedAt: now}},
+		APITokens:   []domain.APIToken{{ID: "t1", UserID: "u2", Name: "CI", Scopes: []string{"users:read"}, Selector: "sel-t1", TokenHash: "hash", ExpiresAt: now.Add(time.Hour), CreatedAt: now}},
+		Invitations: []domain.Invitation{{ID: "i1", UserID: "u2", Email: "bob@example.com", Selector: "sel-i1", TokenHash: "hash", ExpiresAt: now.Add(time.Hour), SentAt: now, CreatedAt: now}},
+		Sequences:   []domain.Sequence{{Name: domain.StaffNumberSequence, Value: 12}},
+		Channels:    []domain.Channel{{ID: "c1", Name: "general"}},
+		Messages:    []domain.Message{{ID: "m1", Content: "hi", SenderID: "u1", ChannelID: "c1"}},
+		Tasks:       []domain.Task{{ID: "k1", Title: "Ship it"}},
+	}
+}
+
+func collection(t *testing.T, report *Report, name string) *CollectionReport {
+	t.Helper()
+	for _, c := range report.Collections {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no report for %s", name)
+	return nil
+}
+
+// TestRunCopiesEveryCollection migrates twice and checks that the second
+// run finds everything already there
+func TestRunCopiesEveryCollection(t *testing.T) {
+	dst, err := OpenTarget(filepath.Join(t.TempDir(), "target.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	src := testDB()
+	report, err := Run(src, dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range report.Collections {
+		if c.NotMigrated != "" {
+			t.Errorf("%s not migrated: %s", c.Name, c.NotMigrated)
+		}
+	}
+	for name, want := range map[string]int{
+		"users": 2, "sessions": 1, "authEvents": 1, "revokedTokens": 1, "passkeys": 1,
+		"apiTokens": 1, "invitations": 1, "channels": 1, "messages": 1, "tasks": 1,
+	} {
+		if c := collection(t, report, name); c.Inserted != want {
+			t.Errorf("%s: inserted %d, want %d (skipped %v)", name, c.Inserted, want, c.Skipped)
+		}
+	}
+	if c := collection(t, report, "sessions"); len(c.Skipped) != 1 {
+		t.Errorf("sessions: skipped %v, want the unknown user's", c.Skipped)
+	}
+	if c := collection(t, report, "revokedTokens"); len(c.Skipped) != 1 {
+		t.Errorf("revokedTokens: skipped %v, want the expired one", c.Skipped)
+	}
+
+	again, err := Run(testDB(), dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range again.Collections {
+		if c.Name != "sequences" && (c.Inserted != 0 || c.Existing != c.Source-len(c.Skipped)) {
+			t.Errorf("second run, %s: inserted %d, existing %d of %d", c.Name, c.Inserted, c.Existing, c.Source)
+		}
+	}
+
+	// The highest staff number beats the stored sequence
+	if n, err := dst.NextSequenceValue(domain.StaffNumberSequence, 1); err != nil || n != 42 {
+		t.Errorf("next staff number = %d, %v; want 42", n, err)
+	}
+	if u, _ := dst.FindByID("u1"); u == nil || u.Password == "plaintext" {
+		t.Errorf("u1's password was not hashed: %+v", u)
+	}
+}
+
+// TestRunReportsCollectionsWithoutAStep guards against a collection added
+// to db.json being dropped by the migration
+func TestRunReportsCollectionsWithoutAStep(t *testing.T) {
+	dst, err := OpenTarget("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	report, err := Run(testDB(), dst, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Collections) != 11 {
+		t.Errorf("got %d collections, want one per db.json collection", len(report.Collections))
+	}
+	for _, c := range report.Collections {
+		if c.NotMigrated != "" {
+			t.Errorf("%s has no migration step", c.Name)
+		}
+	}
+}
+
+// TestDryRunLeavesTargetAlone checks that a dry run neither creates the
+// target file nor writes rows, yet reports what it would insert
+func TestDryRunLeavesTargetAlone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.db")
+	dst, err := OpenTarget(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	report, err := Run(testDB(), dst, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s", path)
+	}
+	if users, _ := dst.FindAll(); len(users) != 0 {
+		t.Errorf("dry run wrote %d users", len(users))
+	}
+	for name, want := range map[string]int{"users": 2, "sessions": 1, "passkeys": 1, "apiTokens": 1, "invitations": 1} {
+		if c := collection(t, report, name); c.Inserted != want {
+			t.Errorf("%s: would insert %d, want %d (skipped %v)", name, c.Inserted, want, c.Skipped)
+		}
+	}
+}
+
+// TestDryRunReadsExistingTarget dry-runs against a target that already
+// holds some of the records: they are reported as existing, the rest as to
+// be inserted, and the file is left byte for byte as it was
+func TestDryRunReadsExistingTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.db")
+	target, err := storage.NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Create(&testDB().Users[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := OpenTarget(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(testDB(), dst, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Create(&domain.User{ID: "u3", Email: "cy@example.com"}); err == nil {
+		t.Error("the dry run's target accepted a write")
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := collection(t, report, "users"); c.Existing != 1 || c.Inserted != 1 {
+		t.Errorf("users: %d existing and %d to insert, want 1 and 1 (skipped %v)", c.Existing, c.Inserted, c.Skipped)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("dry run changed the target")
+	}
+}
@@ -0,0 +1,109 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// defaultBreached holds the SHA-1 hashes of a few hundred of the most common
+// passwords. BREACHED_PASSWORDS_FILE can point at a longer list, up to
+// MaxBreachedFileSize; the full Pwned Passwords download is far larger.
+//
+//go:embed breached.txt
+var defaultBreached []byte
+
+const prefixLen = 5
+
+// MaxBreachedFileSize caps the breached list, which is held in memory: 32 MiB
+// is roughly 750,000 hashes, such as the most common slice of Pwned Passwords
+const MaxBreachedFileSize = 32 << 20
+
+// BreachedList answers "has this password appeared in a breach?" the way the
+// Pwned Passwords range API does: hashes are grouped by the first five hex
+// digits of their SHA-1 and a lookup only ever touches one group. Keeping the
+// same shape means a remote range client can replace the local file without
+// changing callers.
+type BreachedList struct {
+	ranges map[string]map[string]struct{} // SHA-1 prefix -> suffixes
+	size   int
+}
+
+// LoadBreachedList reads a file of upper- or lower-case SHA-1 hashes, one per
+// line, optionally followed by ":count" as in the Pwned Passwords download.
+// Files over MaxBreachedFileSize are refused. An empty path loads the
+// built-in list.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	if path == "" {
+		return parseBreached(bytes.NewReader(defaultBreached))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > MaxBreachedFileSize {
+		return nil, fmt.Errorf("%s: %d bytes is over the %d byte limit of a breached password list; use the most common hashes only",
+			path, info.Size(), MaxBreachedFileSize)
+	}
+
+	list, err := parseBreached(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return list, nil
+}
+
+func parseBreached(r io.Reader) (*BreachedList, error) {
+	list := &BreachedList{ranges: map[string]map[string]struct{}{}}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(entry, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+
+		prefix, suffix := hash[:prefixLen], hash[prefixLen:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = map[string]struct{}{}
+		}
+		list.ranges[prefix][suffix] = struct{}{}
+		list.size++
+	}
+	return list, scanner.Err()
+}
+
+// Contains reports whether password is on the list
+func (b *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, found := b.ranges[hash[:prefixLen]][hash[prefixLen:]]
+	return found
+}
+
+// Len is the number of hashes loaded
+func (b *BreachedList) Len() int {
+	return b.size
+}
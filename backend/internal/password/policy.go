@@ -0,0 +1,209 @@
+package password
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/stacklevest/backend/internal/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Violation codes returned in field errors
+const (
+	CodeTooShort = "too_short"
+	CodeTooWeak  = "too_weak"
+	CodeReused   = "reused"
+	CodeBreached = "breached"
+	CodeTooLong  = domain.CodeTooLong
+)
+
+// MaxBytes is the longest password bcrypt can hash
+const MaxBytes = 72
+
+// PolicyError lists every rule a password broke
+type PolicyError struct {
+	Violations []domain.FieldError
+}
+
+func (e *PolicyError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Fields returns the violations attributed to the named request field
+func (e *PolicyError) Fields(field string) []domain.FieldError {
+	fields := make([]domain.FieldError, len(e.Violations))
+	for i, v := range e.Violations {
+		v.Field = field
+		fields[i] = v
+	}
+	return fields
+}
+
+type Policy struct {
+	MinLength  int
+	MinEntropy float64 // Estimated bits, see Entropy
+	History    int     // Previous hashes a new password may not match
+	Breached   *BreachedList
+}
+
+// Check returns a *PolicyError if password may not be set for user. user may
+// be nil for an account that does not exist yet.
+func (p *Policy) Check(password string, user *domain.User) error {
+	var violations []domain.FieldError
+
+	if len(password) > MaxBytes {
+		violations = append(violations, domain.FieldError{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("Password must be at most %d bytes", MaxBytes),
+		})
+	} else if n := len([]rune(password)); n < p.MinLength {
+		violations = append(violations, domain.FieldError{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	} else if Entropy(password) < p.MinEntropy {
+		violations = append(violations, domain.FieldError{
+			Code:    CodeTooWeak,
+			Message: "Password is too easy to guess; make it longer or mix upper and lower case, digits and symbols",
+		})
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, domain.FieldError{
+			Code:    CodeBreached,
+			Message: "Password has appeared in a data breach; choose a different one",
+		})
+	}
+
+	if user != nil && p.reused(password, user) {
+		msg := "Password must be different from your current one"
+		if p.History > 0 {
+			msg = fmt.Sprintf("Password must not match your current or last %d passwords", p.History)
+		}
+		violations = append(violations, domain.FieldError{Code: CodeReused, Message: msg})
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (p *Policy) reused(password string, user *domain.User) bool {
+	if user.Password != "" && Verify(user.Password, password) {
+		return true
+	}
+	for i, old := range user.PasswordHistory {
+		if i >= p.History {
+			break
+		}
+		if Verify(old, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set checks password against the policy and stores its hash on user
+// (without saving), pushing the previous hash onto the history and clearing
+// any forced reset
+func (p *Policy) Set(user *domain.User, password string) error {
+	if err := p.Check(password, user); err != nil {
+		return err
+	}
+	hashed, err := Hash(password)
+	if err != nil {
+		return err
+	}
+
+	if p.History > 0 && user.Password != "" {
+		previous := user.Password
+		if !IsHash(previous) {
+			if previous, err = Hash(previous); err != nil {
+				return err
+			}
+		}
+		user.PasswordHistory = append([]string{previous}, user.PasswordHistory...)
+	}
+	if len(user.PasswordHistory) > p.History {
+		user.PasswordHistory = user.PasswordHistory[:p.History]
+	}
+
+	user.Password = hashed
+	user.MustResetPassword = false
+	return nil
+}
+
+// Entropy is a rough estimate of the password's strength in bits: the size
+// of the character pool its classes span, raised to its length. Repeated
+// characters count half, so "aaaaaaaaaaaa" does not pass for a strong one.
+func Entropy(password string) float64 {
+	var lower, upper, digit, other bool
+	seen := map[rune]bool{}
+	length := 0.0
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+		if seen[r] {
+			length += 0.5
+		} else {
+			seen[r] = true
+			length++
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if other {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+	return length * math.Log2(float64(pool))
+}
+
+// IsHash tells bcrypt hashes from legacy plaintext passwords
+func IsHash(stored string) bool {
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+func Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Verify compares a candidate against a stored password, which is a bcrypt
+// hash or, for legacy records, plaintext
+func Verify(stored, candidate string) bool {
+	if IsHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(candidate)) == nil
+	}
+	return stored != "" && stored == candidate
+}
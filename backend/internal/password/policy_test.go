@@ -0,0 +1,148 @@
+package password
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+func codes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var perr *PolicyError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got %v, want a *PolicyError", err)
+	}
+	var codes []string
+	for _, v := range perr.Violations {
+		codes = append(codes, v.Code)
+	}
+	return codes
+}
+
+func TestEntropy(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"abcdefghijkl", 12 * math.Log2(26)},
+		{"aaaaaaaaaaaa", 6.5 * math.Log2(26)}, // One character, then eleven halves
+		{"Abcdef12", 8 * math.Log2(62)},
+		{"Ab1!", 4 * math.Log2(95)},
+	} {
+		if got := Entropy(tc.password); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Entropy(%q) = %.2f, want %.2f", tc.password, got, tc.want)
+		}
+	}
+}
+
+// TestCheckReportsEveryViolation checks that a password breaking several
+// rules gets one code per rule
+func TestCheckReportsEveryViolation(t *testing.T) {
+	breached, err := LoadBreachedList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{MinLength: 8, MinEntropy: 50, Breached: breached}
+
+	for _, tc := range []struct {
+		password string
+		want     []string
+	}{
+		{"correct horse battery", nil},
+		{"Xk9#", []string{CodeTooShort}},
+		{"zzzzzzzzzzzz", []string{CodeTooWeak}},
+		{"password", []string{CodeTooWeak, CodeBreached}},
+		{string(make([]byte, MaxBytes+1)), []string{CodeTooLong}},
+	} {
+		if got := codes(t, policy.Check(tc.password, nil)); !slices.Equal(got, tc.want) {
+			t.Errorf("Check(%.12q) = %v, want %v", tc.password, got, tc.want)
+		}
+	}
+}
+
+// TestSetKeepsHistory sets passwords in turn and checks that the current
+// and last History ones are refused, while older ones are allowed again
+func TestSetKeepsHistory(t *testing.T) {
+	policy := &Policy{History: 2}
+	user := &domain.User{Password: "legacy plaintext", MustResetPassword: true}
+
+	for _, pw := range []string{"first passphrase", "second passphrase", "third passphrase"} {
+		if err := policy.Set(user, pw); err != nil {
+			t.Fatalf("Set(%q): %v", pw, err)
+		}
+	}
+	if user.MustResetPassword {
+		t.Error("Set left the forced reset in place")
+	}
+	if len(user.PasswordHistory) != 2 {
+		t.Fatalf("history holds %d hashes, want 2", len(user.PasswordHistory))
+	}
+	for _, h := range user.PasswordHistory {
+		if !IsHash(h) {
+			t.Errorf("history holds %q, want bcrypt hashes only", h)
+		}
+	}
+
+	for pw, reused := range map[string]bool{
+		"third passphrase":  true,
+		"second passphrase": true,
+		"first passphrase":  true,
+		"legacy plaintext":  false,
+	} {
+		if got := slices.Contains(codes(t, policy.Check(pw, user)), CodeReused); got != reused {
+			t.Errorf("Check(%q) reused = %v, want %v", pw, got, reused)
+		}
+	}
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "breached.txt")
+	list := "# SHA-1 of \"password\" and \"hunter2\"\n" +
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:9545824\n" +
+		"\n" +
+		"F3BBBD66A63D4BF1747940578EC3D0103530E21D\n"
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breached.Len() != 2 {
+		t.Errorf("loaded %d hashes, want 2", breached.Len())
+	}
+	for pw, want := range map[string]bool{"password": true, "hunter2": true, "Password": false, "": false} {
+		if got := breached.Contains(pw); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", pw, got, want)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.txt")
+	if err := os.WriteFile(bad, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\nnot-a-hash\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBreachedList(bad); err == nil {
+		t.Error("loaded a list with a malformed line")
+	}
+
+	big := filepath.Join(dir, "big.txt")
+	if err := os.WriteFile(big, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(big, MaxBreachedFileSize+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBreachedList(big); err == nil {
+		t.Error("loaded a list over MaxBreachedFileSize")
+	}
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// Journal operations. Every operation is idempotent so an entry can be
+// replayed safely on top of a snapshot that already contains it (e.g. after
+// a crash between compaction's rename and the journal truncate).
+const (
+	opPut                 = "put"
+	opDelete              = "delete"
+	opDeleteUserSessions  = "delete_user_sessions"
+	opDeleteSessionFamily = "delete_session_family"
+)
+
+const (
+	collUsers       = "users"
+	collSessions    = "sessions"
+	collChannels    = "channels"
+	collMessages    = "messages"
+	collTasks       = "tasks"
+	collEvents      = "authEvents"
+	collRevoked     = "revokedTokens"
+	collPasskeys    = "passkeys"
+	collAPITokens   = "apiTokens"
+	collInvitations = "invitations"
+	collSequences   = "sequences"
+)
+
+// journalEntry is a single mutation recorded in the write-ahead journal
+type journalEntry struct {
+	Op         string          `json:"op"`
+	Collection string          `json:"collection"`
+	ID         string          `json:"id"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+func putEntry(collection, id string, v interface{}) (journalEntry, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return journalEntry{}, err
+	}
+	return journalEntry{Op: opPut, Collection: collection, ID: id, Data: data}, nil
+}
+
+func deleteEntry(collection, id string) journalEntry {
+	return journalEntry{Op: opDelete, Collection: collection, ID: id}
+}
+
+// apply replays the entry against db
+func (e journalEntry) apply(db *DB) error {
+	switch e.Collection {
+	case collUsers:
+		return applyEntry(&db.Users, e, func(u domain.User) string { return u.ID })
+	case collSessions:
+		switch e.Op {
+		case opDeleteUserSessions:
+			db.Sessions = removeSessions(db.Sessions, func(s domain.UserSession) bool { return s.UserID == e.ID })
+			return nil
+		case opDeleteSessionFamily:
+			db.Sessions = removeSessions(db.Sessions, func(s domain.UserSession) bool { return s.FamilyID == e.ID })
+			return nil
+		}
+		return applyEntry(&db.Sessions, e, func(s domain.UserSession) string { return s.ID })
+	case collChannels:
+		return applyEntry(&db.Channels, e, func(c domain.Channel) string { return c.ID })
+	case collMessages:
+		return applyEntry(&db.Messages, e, func(m domain.Message) string { return m.ID })
+	case collTasks:
+		return applyEntry(&db.Tasks, e, func(t domain.Task) string { return t.ID })
+	case collEvents:
+		return applyEntry(&db.AuthEvents, e, func(ev domain.AuthEvent) string { return ev.ID })
+	case collRevoked:
+		return applyEntry(&db.RevokedTokens, e, func(t domain.RevokedToken) string { return t.JTI })
+	case collPasskeys:
+		return applyEntry(&db.Passkeys, e, func(p domain.Passkey) string { return p.ID })
+	case collAPITokens:
+		return applyEntry(&db.APITokens, e, func(t domain.APIToken) string { return t.ID })
+	case collInvitations:
+		return applyEntry(&db.Invitations, e, func(i domain.Invitation) string { return i.ID })
+	case collSequences:
+		return applyEntry(&db.Sequences, e, func(seq domain.Sequence) string { return seq.Name })
+	}
+	return fmt.Errorf("journal: unknown collection %q", e.Collection)
+}
+
+func removeSessions(sessions []domain.UserSession, match func(domain.UserSession) bool) []domain.UserSession {
+	remaining := []domain.UserSession{}
+	for _, sess := range sessions {
+		if !match(sess) {
+			remaining = append(remaining, sess)
+		}
+	}
+	return remaining
+}
+
+// applyEntry performs a put (replace or append) or delete by ID on items
+func applyEntry[T any](items *[]T, e journalEntry, idOf func(T) string) error {
+	switch e.Op {
+	case opPut:
+		var v T
+		if err := json.Unmarshal(e.Data, &v); err != nil {
+			return err
+		}
+		for i := range *items {
+			if idOf((*items)[i]) == e.ID {
+				(*items)[i] = v
+				return nil
+			}
+		}
+		*items = append(*items, v)
+		return nil
+	case opDelete:
+		for i := range *items {
+			if idOf((*items)[i]) == e.ID {
+				*items = append((*items)[:i], (*items)[i+1:]...)
+				return nil
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("journal: unknown op %q", e.Op)
+}
+
+// journal is an append-only log of mutations stored next to the snapshot.
+// Each record is one line: the CRC-32 of the payload in hex, a space, and
+// the JSON-encoded entry. A record without its trailing newline or with a
+// checksum mismatch is treated as a torn write and discarded on replay.
+type journal struct {
+	path    string
+	file    *os.File
+	pending []journalEntry // Records appended since the last compaction
+}
+
+// openJournal opens (creating if needed) the journal at path and returns
+// every intact entry it contains. A torn tail is truncated away.
+func openJournal(path string) (*journal, []journalEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	entries, good := parseJournal(data)
+	if good < len(data) {
+		log.Printf("Warning: discarding %d bytes of torn journal tail in %s", len(data)-good, path)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if good < len(data) {
+		if err := file.Truncate(int64(good)); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	if _, err := file.Seek(int64(good), 0); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &journal{path: path, file: file, pending: entries}, entries, nil
+}
+
+// parseJournal decodes records until the first damaged one and returns the
+// entries together with the byte offset just past the last intact record
+func parseJournal(data []byte) ([]journalEntry, int) {
+	var entries []journalEntry
+	good := 0
+	for good < len(data) {
+		end := bytes.IndexByte(data[good:], '\n')
+		if end < 0 {
+			break
+		}
+		line := data[good : good+end]
+
+		var sum uint32
+		if len(line) < 10 || line[8] != ' ' {
+			break
+		}
+		if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil {
+			break
+		}
+		payload := line[9:]
+		if crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+
+		var e journalEntry
+		if err := json.Unmarshal(payload, &e); err != nil {
+			break
+		}
+		entries = append(entries, e)
+		good += end + 1
+	}
+	return entries, good
+}
+
+// append durably records e; it returns only after the record is fsynced
+func (j *journal) append(e journalEntry) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	record := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+	if _, err := j.file.WriteString(record); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.pending = append(j.pending, e)
+	return nil
+}
+
+// reset empties the journal once its entries are part of the snapshot
+func (j *journal) reset() error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.pending = nil
+	return nil
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// writeFileAtomic replaces path with data via temp file + fsync + rename,
+// so readers only ever observe the old or the new contents
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
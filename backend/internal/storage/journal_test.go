@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// record encodes a put of user the way journal.append does, with sum
+// overriding the checksum when non-zero
+func record(t *testing.T, user domain.User, sum uint32) string {
+	t.Helper()
+	e, err := putEntry(collUsers, user.ID, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum == 0 {
+		sum = crc32.ChecksumIEEE(payload)
+	}
+	return fmt.Sprintf("%08x %s\n", sum, payload)
+}
+
+// snapshotUsers counts the users in db.json itself, ignoring the journal
+func snapshotUsers(t *testing.T, path string) int {
+	t.Helper()
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(snapshot.Users)
+}
+
+func userIDs(t *testing.T, s *JSONStore) map[string]bool {
+	t.Helper()
+	users, err := s.FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for _, u := range users {
+		ids[u.ID] = true
+	}
+	return ids
+}
+
+// TestReplayStopsAtDamagedTail starts a store on a journal left by a crash
+// and checks that the intact records are replayed, everything from the
+// first torn or corrupt record on is dropped, and the result is compacted
+func TestReplayStopsAtDamagedTail(t *testing.T) {
+	ada := domain.User{ID: "u1", Name: "Ada", Email: "ada@example.com"}
+	bob := domain.User{ID: "u2", Name: "Bob", Email: "bob@example.com"}
+	cy := domain.User{ID: "u3", Name: "Cy", Email: "cy@example.com"}
+	intact := record(t, ada, 0) + record(t, bob, 0)
+
+	for name, tail := range map[string]string{
+		"torn":    record(t, cy, 0)[:40],
+		"corrupt": record(t, cy, 0xdeadbeef) + record(t, domain.User{ID: "u4", Email: "d@example.com"}, 0),
+		"garbage": "not a journal record\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.json")
+			if err := os.WriteFile(path+".journal", []byte(intact+tail), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// ReadDB must see the same data without touching the files
+			db, err := ReadDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(db.Users) != 2 {
+				t.Errorf("ReadDB found %d users, want 2", len(db.Users))
+			}
+			if data, _ := os.ReadFile(path + ".journal"); string(data) != intact+tail {
+				t.Error("ReadDB changed the journal")
+			}
+
+			s := NewJSONStore(path)
+			defer s.Close()
+			if ids := userIDs(t, s); len(ids) != 2 || !ids["u1"] || !ids["u2"] {
+				t.Errorf("replayed users %v, want u1 and u2", ids)
+			}
+			if data, err := os.ReadFile(path + ".journal"); err != nil || len(data) != 0 {
+				t.Errorf("journal after replay holds %q, %v; want it compacted away", data, err)
+			}
+			if n := snapshotUsers(t, path); n != 2 {
+				t.Errorf("db.json holds %d users after replay, want 2", n)
+			}
+		})
+	}
+}
+
+// TestReplayIsIdempotent replays a journal whose entries are already in the
+// snapshot, as after a crash between compaction's rename and truncate
+func TestReplayIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	s := NewJSONStore(path)
+	ada := &domain.User{ID: "u1", Name: "Ada", Email: "ada@example.com"}
+	if err := s.Create(ada); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := *ada
+	renamed.Name = "Ada Lovelace"
+	journal := record(t, *ada, 0) + record(t, renamed, 0)
+	if err := os.WriteFile(path+".journal", []byte(journal), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewJSONStore(path)
+	defer s.Close()
+	users, err := s.FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "Ada Lovelace" {
+		t.Errorf("users after replay = %+v, want Ada once, renamed", users)
+	}
+}
+
+// TestCompaction checks that the journal is folded into db.json once it
+// reaches compactThreshold entries, and on Close
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	s := NewJSONStore(path)
+
+	for i := range compactThreshold - 1 {
+		u := &domain.User{ID: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)}
+		if err := s.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.journal.pending) != compactThreshold-1 {
+		t.Fatalf("journal holds %d entries, want %d", len(s.journal.pending), compactThreshold-1)
+	}
+	if n := snapshotUsers(t, path); n != 0 {
+		t.Fatalf("db.json holds %d users before compaction, want 0", n)
+	}
+
+	if err := s.Create(&domain.User{ID: "last", Email: "last@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.journal.pending) != 0 {
+		t.Errorf("journal holds %d entries after reaching the threshold, want 0", len(s.journal.pending))
+	}
+	if n := snapshotUsers(t, path); n != compactThreshold {
+		t.Errorf("db.json holds %d users after compaction, want %d", n, compactThreshold)
+	}
+
+	if err := s.Delete("last"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path + ".journal"); err != nil || len(data) != 0 {
+		t.Errorf("journal after Close holds %q, %v; want it empty", data, err)
+	}
+	if n := snapshotUsers(t, path); n != compactThreshold-1 {
+		t.Errorf("db.json holds %d users after Close, want %d", n, compactThreshold-1)
+	}
+}
+
+// TestCompactionKeepsWebsocketWrites rewrites db.json between compactions
+// the way the websocket-server's saveState does, with only the collections
+// it knows, and checks that neither its writes nor the auth state are lost
+func TestCompactionKeepsWebsocketWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	s := NewJSONStore(path)
+	if err := s.Create(&domain.User{ID: "u1", Name: "Ada", Email: "ada@example.com", Status: "ACTIVE"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateSession(&domain.UserSession{ID: "s1", UserID: "u1", FamilyID: "f1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]json.RawMessage
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["sessions"]; ok {
+		t.Error("db.json holds the sessions, want them only in the auth state file")
+	}
+
+	s = NewJSONStore(path)
+	defer s.Close()
+	if err := s.Create(&domain.User{ID: "u2", Name: "Bob", Email: "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The websocket-server changes Ada's presence and posts a message
+	if err := os.WriteFile(path, []byte(`{
+		"users": [{"id": "u1", "name": "Ada", "email": "ada@example.com", "status": "busy"}],
+		"channels": [],
+		"messages": [{"id": "m1", "content": "hi", "senderId": "u1", "timestamp": "2026-01-02T03:04:05Z"}],
+		"tasks": []
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	err = s.compact()
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ReadDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := map[string]string{}
+	for _, u := range db.Users {
+		statuses[u.ID] = u.Status
+	}
+	if len(statuses) != 2 || statuses["u1"] != "busy" {
+		t.Errorf("users after compaction = %v, want Ada busy and Bob", statuses)
+	}
+	if len(db.Messages) != 1 {
+		t.Errorf("db.json holds %d messages after compaction, want the websocket-server's one", len(db.Messages))
+	}
+	if len(db.Sessions) != 1 {
+		t.Errorf("%d sessions after compaction, want 1", len(db.Sessions))
+	}
+	if users, _ := s.FindAll(); len(users) != 2 {
+		t.Errorf("store sees %d users after compaction, want 2", len(users))
+	}
+}
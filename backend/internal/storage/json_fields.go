@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// sharedCollections are the members of db.json in the order they are
+// written, with the type of their records
+var sharedCollections = []struct {
+	name   string
+	record reflect.Type
+}{
+	{collUsers, reflect.TypeOf(domain.User{})},
+	{collChannels, reflect.TypeOf(domain.Channel{})},
+	{collMessages, reflect.TypeOf(domain.Message{})},
+	{collTasks, reflect.TypeOf(domain.Task{})},
+}
+
+// encodeShared encodes the shared collections of db as db.json, keeping what
+// the Go types do not model from current, the file as it is now: members
+// other than the collections, and the members of a record, matched by id,
+// that its type has no field for. The auth collections of a db.json from
+// before they had a file of their own are dropped.
+func encodeShared(db *DB, current []byte) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if len(current) > 0 {
+		if err := json.Unmarshal(current, &doc); err != nil {
+			return nil, err
+		}
+	}
+
+	typed, err := json.Marshal(db.shared())
+	if err != nil {
+		return nil, err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(typed, &members); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteByte('{')
+	write := func(name string, value []byte) {
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		out.Write(key)
+		out.WriteByte(':')
+		out.Write(value)
+	}
+	for _, c := range sharedCollections {
+		records, err := keepUnknownMembers(members[c.name], doc[c.name], c.record)
+		if err != nil {
+			return nil, err
+		}
+		write(c.name, records)
+	}
+
+	auth := jsonFields(reflect.TypeOf(authDB{}))
+	var extra []string
+	for name := range doc {
+		if _, ok := members[name]; !ok && !auth[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		write(name, doc[name])
+	}
+	out.WriteByte('}')
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
+
+// keepUnknownMembers adds to each record of typed the members its record in
+// current has that record's type does not know
+func keepUnknownMembers(typed, current json.RawMessage, record reflect.Type) (json.RawMessage, error) {
+	var stored []map[string]json.RawMessage
+	if len(current) > 0 && json.Unmarshal(current, &stored) != nil {
+		return typed, nil // Not a list of records; the typed data replaces it
+	}
+	unknown := map[string]map[string]json.RawMessage{}
+	known := jsonFields(record)
+	for _, r := range stored {
+		var id string
+		if json.Unmarshal(r["id"], &id) != nil {
+			continue
+		}
+		for name, value := range r {
+			if !known[name] {
+				if unknown[id] == nil {
+					unknown[id] = map[string]json.RawMessage{}
+				}
+				unknown[id][name] = value
+			}
+		}
+	}
+	if len(unknown) == 0 {
+		return typed, nil
+	}
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(typed, &records); err != nil {
+		return nil, err
+	}
+	for i, r := range records {
+		var id struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(r, &id); err != nil {
+			return nil, err
+		}
+		if unknown[id.ID] == nil {
+			continue
+		}
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(r, &members); err != nil {
+			return nil, err
+		}
+		for name, value := range unknown[id.ID] {
+			members[name] = value
+		}
+		merged, err := json.Marshal(members)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = merged
+	}
+	return json.Marshal(records)
+}
+
+// jsonFields returns the JSON member names of struct type t
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-":
+		case name != "":
+			fields[name] = true
+		default:
+			fields[f.Name] = true
+		}
+	}
+	return fields
+}
@@ -5,34 +5,154 @@ import (
 	"errors"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/stacklevest/backend/internal/domain"
 )
 
+// DB is everything the JSON store holds. The collections the websocket-server
+// shares are written to db.json; the auth state, which it would drop when it
+// writes the file back, is kept in a file of its own (see authStatePath).
 type DB struct {
 	Users    []domain.User        `json:"users"`
 	Sessions []domain.UserSession `json:"sessions"`
-	Channels []interface{}        `json:"channels"` // Placeholder to preserve data
-	Messages []interface{}        `json:"messages"` // Placeholder
-	Tasks    []interface{}        `json:"tasks"`    // Placeholder
+	Channels []domain.Channel     `json:"channels"`
+	Messages []domain.Message     `json:"messages"`
+	Tasks    []domain.Task        `json:"tasks"`
+
+	AuthEvents    []domain.AuthEvent    `json:"authEvents"`
+	RevokedTokens []domain.RevokedToken `json:"revokedTokens"`
+	Passkeys      []domain.Passkey      `json:"passkeys"`
+	APITokens     []domain.APIToken     `json:"apiTokens"`
+	Invitations   []domain.Invitation   `json:"invitations"`
+	Sequences     []domain.Sequence     `json:"sequences"`
+}
+
+// sharedDB is the part of DB written to db.json: the collections the
+// websocket-server reads and writes back
+type sharedDB struct {
+	Users    []domain.User    `json:"users"`
+	Channels []domain.Channel `json:"channels"`
+	Messages []domain.Message `json:"messages"`
+	Tasks    []domain.Task    `json:"tasks"`
+}
+
+// authDB is the part of DB only this store writes
+type authDB struct {
+	Sessions      []domain.UserSession  `json:"sessions"`
+	AuthEvents    []domain.AuthEvent    `json:"authEvents"`
+	RevokedTokens []domain.RevokedToken `json:"revokedTokens"`
+	Passkeys      []domain.Passkey      `json:"passkeys"`
+	APITokens     []domain.APIToken     `json:"apiTokens"`
+	Invitations   []domain.Invitation   `json:"invitations"`
+	Sequences     []domain.Sequence     `json:"sequences"`
+}
+
+func (db *DB) shared() sharedDB {
+	return sharedDB{Users: db.Users, Channels: db.Channels, Messages: db.Messages, Tasks: db.Tasks}
+}
+
+func (db *DB) auth() authDB {
+	return authDB{
+		Sessions:      db.Sessions,
+		AuthEvents:    db.AuthEvents,
+		RevokedTokens: db.RevokedTokens,
+		Passkeys:      db.Passkeys,
+		APITokens:     db.APITokens,
+		Invitations:   db.Invitations,
+		Sequences:     db.Sequences,
+	}
+}
+
+func (db *DB) setAuth(a authDB) {
+	db.Sessions = a.Sessions
+	db.AuthEvents = a.AuthEvents
+	db.RevokedTokens = a.RevokedTokens
+	db.Passkeys = a.Passkeys
+	db.APITokens = a.APITokens
+	db.Invitations = a.Invitations
+	db.Sequences = a.Sequences
+}
+
+// authStatePath is the file next to db.json holding the auth state, e.g.
+// "db.auth.json"
+func authStatePath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".auth.json"
+}
+
+// ensureCollections replaces nil slices with empty ones so the file is
+// always written with arrays the websocket-server can iterate.
+func (db *DB) ensureCollections() {
+	if db.Users == nil {
+		db.Users = []domain.User{}
+	}
+	if db.Sessions == nil {
+		db.Sessions = []domain.UserSession{}
+	}
+	if db.Channels == nil {
+		db.Channels = []domain.Channel{}
+	}
+	if db.Messages == nil {
+		db.Messages = []domain.Message{}
+	}
+	if db.Tasks == nil {
+		db.Tasks = []domain.Task{}
+	}
+	if db.AuthEvents == nil {
+		db.AuthEvents = []domain.AuthEvent{}
+	}
+	if db.RevokedTokens == nil {
+		db.RevokedTokens = []domain.RevokedToken{}
+	}
+	if db.Passkeys == nil {
+		db.Passkeys = []domain.Passkey{}
+	}
+	if db.APITokens == nil {
+		db.APITokens = []domain.APIToken{}
+	}
+	if db.Invitations == nil {
+		db.Invitations = []domain.Invitation{}
+	}
+	if db.Sequences == nil {
+		db.Sequences = []domain.Sequence{}
+	}
 }
 
+// Compaction folds the journal into db.json once it holds compactThreshold
+// entries, and at least every compactInterval while it is non-empty, so the
+// websocket-server sharing the file sees our writes promptly. Each
+// compaction first reads db.json again, picking up what the websocket-server
+// wrote since; the two processes do not lock the file, so a write either
+// makes between the other's read and write is lost. Run a single writer, or
+// the SQLite store, where that matters.
+const (
+	compactThreshold = 100
+	compactInterval  = 30 * time.Second
+)
+
 type JSONStore struct {
 	filepath string
 	mu       sync.RWMutex
 	cache    *DB // In-memory cache
+	journal  *journal
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func NewJSONStore(filepath string) *JSONStore {
 	store := &JSONStore{
 		filepath: filepath,
+		stop:     make(chan struct{}),
 	}
-	// Warm up cache on startup
+	// Warm up cache on startup (replays any journal left by a crash)
 	if _, err := store.load(); err != nil {
 		log.Printf("Warning: Failed to load initial data: %v", err)
 	}
+	go store.compactLoop()
 	return store
 }
 
@@ -55,41 +175,200 @@ func (s *JSONStore) load() (*DB, error) {
 		return s.cache, nil
 	}
 
-	data, err := os.ReadFile(s.filepath)
+	db, err := readSnapshot(s.filepath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			s.cache = &DB{Users: []domain.User{}}
-			return s.cache, nil
-		}
 		return nil, err
 	}
 
-	var db DB
-	if len(data) == 0 {
-		s.cache = &DB{Users: []domain.User{}}
-		return s.cache, nil
+	// 3. Replay mutations journaled after the snapshot was written
+	j, entries, err := openJournal(s.filepath + ".journal")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if err := e.apply(db); err != nil {
+			j.close()
+			return nil, err
+		}
+	}
+	db.ensureCollections()
+
+	s.cache = db
+	s.journal = j
+
+	if len(entries) > 0 {
+		log.Printf("Replayed %d journal entries into %s", len(entries), s.filepath)
+		if err := s.compact(); err != nil {
+			log.Printf("Warning: Failed to compact journal: %v", err)
+		}
 	}
+	return s.cache, nil
+}
 
-	if err := json.Unmarshal(data, &db); err != nil {
+// ReadDB returns the document at path with any pending journal entries
+// applied, without modifying either file. It is meant for offline tools;
+// servers should use NewJSONStore.
+func ReadDB(path string) (*DB, error) {
+	db, err := readSnapshot(path)
+	if err != nil {
 		return nil, err
 	}
 
-	s.cache = &db
-	return s.cache, nil
+	data, err := os.ReadFile(path + ".journal")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	entries, _ := parseJournal(data)
+	for _, e := range entries {
+		if err := e.apply(db); err != nil {
+			return nil, err
+		}
+	}
+	db.ensureCollections()
+	return db, nil
+}
+
+// readSnapshot parses db.json and then its auth state file, treating a
+// missing or empty file as empty data. Auth state still in a db.json from
+// before it had a file of its own is read from there until the file exists.
+func readSnapshot(path string) (*DB, error) {
+	db := &DB{}
+	for _, p := range []string{path, authStatePath(path)} {
+		data, err := os.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(data, db); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// commit journals e and then applies it to the cache.
+// Caller must hold s.mu.Lock()
+func (s *JSONStore) commit(e journalEntry) error {
+	if s.cache == nil || s.journal == nil {
+		return errors.New("store is not loaded")
+	}
+	if err := s.journal.append(e); err != nil {
+		return err
+	}
+	if err := e.apply(s.cache); err != nil {
+		return err
+	}
+
+	if len(s.journal.pending) >= compactThreshold {
+		// The entry is already durable in the journal; a failed compaction
+		// is retried later and must not fail the caller's write.
+		if err := s.compact(); err != nil {
+			log.Printf("Warning: Failed to compact journal: %v", err)
+		}
+	}
+	return nil
 }
 
-// save writes the current cache to disk
+// put journals an insert-or-replace of v under id.
 // Caller must hold s.mu.Lock()
-func (s *JSONStore) save() error {
+func (s *JSONStore) put(collection, id string, v interface{}) error {
+	e, err := putEntry(collection, id, v)
+	if err != nil {
+		return err
+	}
+	return s.commit(e)
+}
+
+// compact atomically rewrites db.json and the auth state file and empties
+// the journal. The shared collections are read from db.json again with the
+// journaled writes replayed on top, so changes the websocket-server made
+// meanwhile are kept, as are members the Go types do not model (see
+// encodeShared); the auth state is only ever written here and comes from the
+// cache.
+// Caller must hold s.mu.Lock()
+func (s *JSONStore) compact() error {
 	if s.cache == nil {
 		return errors.New("cache is nil")
 	}
 
-	data, err := json.MarshalIndent(s.cache, "", "  ")
+	db, err := readSnapshot(s.filepath)
+	if err != nil {
+		return err
+	}
+	if s.journal != nil {
+		for _, e := range s.journal.pending {
+			if err := e.apply(db); err != nil {
+				return err
+			}
+		}
+	}
+	db.setAuth(s.cache.auth())
+	db.ensureCollections()
+
+	auth, err := json.MarshalIndent(db.auth(), "", "  ")
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(s.filepath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	shared, err := encodeShared(db, current)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.filepath, data, 0644)
+	if err := writeFileAtomic(authStatePath(s.filepath), auth, 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.filepath, shared, 0644); err != nil {
+		return err
+	}
+	s.cache = db
+	if s.journal != nil {
+		return s.journal.reset()
+	}
+	return nil
+}
+
+func (s *JSONStore) compactLoop() {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.journal != nil && len(s.journal.pending) > 0 {
+				if err := s.compact(); err != nil {
+					log.Printf("Warning: Failed to compact journal: %v", err)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops background compaction, folds the journal into db.json and
+// releases the journal file
+func (s *JSONStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	err := s.compact()
+	if cerr := s.journal.close(); err == nil {
+		err = cerr
+	}
+	s.journal = nil
+	return err
 }
 
 // Implement UserRepository
@@ -105,6 +384,87 @@ func (s *JSONStore) FindAll() ([]domain.User, error) {
 	return db.Users, nil
 }
 
+func (s *JSONStore) FindUsers(q domain.UserQuery) (*domain.UserPage, error) {
+	key, desc := q.SortKey()
+	cursor, err := domain.DecodeUserCursor(q)
+	if err != nil {
+		return nil, err
+	}
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	users := []domain.User{}
+	for _, u := range db.Users {
+		if q.Matches(&u) {
+			users = append(users, u)
+		}
+	}
+	s.mu.RUnlock()
+
+	before := func(a, b *domain.User) bool {
+		c := compareUsers(a, b, key)
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.Slice(users, func(i, j int) bool { return before(&users[i], &users[j]) })
+
+	if cursor != nil {
+		after, err := cursorUser(key, cursor)
+		if err != nil {
+			return nil, err
+		}
+		users = users[sort.Search(len(users), func(i int) bool { return before(after, &users[i]) }):]
+	}
+
+	page := &domain.UserPage{Users: users}
+	if limit := q.PageSize(); len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = domain.NextUserCursor(q, &users[limit-1])
+	}
+	return page, nil
+}
+
+// compareUsers orders users by a sort key, text ignoring case, then by ID
+func compareUsers(a, b *domain.User, key string) int {
+	var c int
+	if key == domain.SortCreatedAt {
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	} else {
+		c = strings.Compare(strings.ToLower(domain.SortValue(a, key)), strings.ToLower(domain.SortValue(b, key)))
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	return c
+}
+
+// cursorUser stands in for the user a cursor points after
+func cursorUser(key string, c *domain.UserCursor) (*domain.User, error) {
+	u := &domain.User{ID: c.ID}
+	switch key {
+	case domain.SortName:
+		u.Name = c.Value
+	case domain.SortEmail:
+		u.Email = c.Value
+	case domain.SortDepartment:
+		u.Department = c.Value
+	case domain.SortStaffNumber:
+		u.StaffNumber = c.Value
+	default:
+		createdAt, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, domain.ErrInvalidCursor
+		}
+		u.CreatedAt = createdAt
+	}
+	return u, nil
+}
+
 func (s *JSONStore) FindByID(id string) (*domain.User, error) {
 	db, err := s.load()
 	if err != nil {
@@ -140,6 +500,23 @@ func (s *JSONStore) FindByEmail(email string) (*domain.User, error) {
 	return nil, nil
 }
 
+func (s *JSONStore) FindByOIDCSubject(subject string) (*domain.User, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range db.Users {
+		if subject != "" && u.OIDCSubject == subject {
+			user := u
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
 func (s *JSONStore) Create(user *domain.User) error {
 	// Ensure cache is loaded
 	if _, err := s.load(); err != nil {
@@ -149,8 +526,7 @@ func (s *JSONStore) Create(user *domain.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.cache.Users = append(s.cache.Users, *user)
-	return s.save()
+	return s.put(collUsers, user.ID, user)
 }
 
 func (s *JSONStore) Update(user *domain.User) error {
@@ -161,13 +537,12 @@ func (s *JSONStore) Update(user *domain.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, u := range s.cache.Users {
+	for _, u := range s.cache.Users {
 		if u.ID == user.ID {
-			s.cache.Users[i] = *user
-			return s.save()
+			return s.put(collUsers, user.ID, user)
 		}
 	}
-	return errors.New("user not found")
+	return domain.ErrUserNotFound
 }
 
 func (s *JSONStore) Delete(id string) error {
@@ -178,13 +553,12 @@ func (s *JSONStore) Delete(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, u := range s.cache.Users {
+	for _, u := range s.cache.Users {
 		if u.ID == id {
-			s.cache.Users = append(s.cache.Users[:i], s.cache.Users[i+1:]...)
-			return s.save()
+			return s.commit(deleteEntry(collUsers, id))
 		}
 	}
-	return errors.New("user not found")
+	return domain.ErrUserNotFound
 }
 
 // Session Management Implementation
@@ -197,11 +571,30 @@ func (s *JSONStore) CreateSession(session *domain.UserSession) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.cache.Sessions = append(s.cache.Sessions, *session)
-	return s.save()
+	return s.put(collSessions, session.ID, session)
+}
+
+func (s *JSONStore) FindSessionByID(id string) (*domain.UserSession, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, sess := range db.Sessions {
+		if sess.ID == id {
+			session := sess
+			return &session, nil
+		}
+	}
+	return nil, nil
 }
 
-func (s *JSONStore) FindSessionByToken(token string) (*domain.UserSession, error) {
+func (s *JSONStore) FindSessionBySelector(selector string) (*domain.UserSession, error) {
+	if selector == "" {
+		return nil, nil // Legacy sessions share the empty selector
+	}
 	db, err := s.load()
 	if err != nil {
 		return nil, err
@@ -210,7 +603,7 @@ func (s *JSONStore) FindSessionByToken(token string) (*domain.UserSession, error
 	defer s.mu.RUnlock()
 
 	for _, sess := range db.Sessions {
-		if sess.RefreshToken == token {
+		if sess.Selector == selector {
 			session := sess
 			return &session, nil
 		}
@@ -218,6 +611,42 @@ func (s *JSONStore) FindSessionByToken(token string) (*domain.UserSession, error
 	return nil, nil
 }
 
+func (s *JSONStore) UpdateSession(session *domain.UserSession) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.cache.Sessions {
+		if sess.ID == session.ID {
+			return s.put(collSessions, session.ID, session)
+		}
+	}
+	return domain.ErrSessionNotFound
+}
+
+func (s *JSONStore) RotateSession(id string, at time.Time) (bool, error) {
+	if _, err := s.load(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.cache.Sessions {
+		if sess.ID == id {
+			if sess.RotatedAt != nil {
+				return false, nil
+			}
+			sess.RotatedAt = &at
+			return true, s.put(collSessions, id, &sess)
+		}
+	}
+	return false, nil
+}
+
 func (s *JSONStore) DeleteSession(id string) error {
 	if _, err := s.load(); err != nil {
 		return err
@@ -226,15 +655,25 @@ func (s *JSONStore) DeleteSession(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, sess := range s.cache.Sessions {
+	for _, sess := range s.cache.Sessions {
 		if sess.ID == id {
-			s.cache.Sessions = append(s.cache.Sessions[:i], s.cache.Sessions[i+1:]...)
-			return s.save()
+			return s.commit(deleteEntry(collSessions, id))
 		}
 	}
 	return nil // Already deleted or not found
 }
 
+func (s *JSONStore) DeleteSessionFamily(familyID string) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commit(journalEntry{Op: opDeleteSessionFamily, Collection: collSessions, ID: familyID})
+}
+
 func (s *JSONStore) DeleteUserSessions(userID string) error {
 	if _, err := s.load(); err != nil {
 		return err
@@ -243,14 +682,48 @@ func (s *JSONStore) DeleteUserSessions(userID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var remainingSessions []domain.UserSession
+	return s.commit(journalEntry{Op: opDeleteUserSessions, Collection: collSessions, ID: userID})
+}
+
+func (s *JSONStore) DeleteStaleSessions(familyID string, rotatedBefore time.Time) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var stale []string
 	for _, sess := range s.cache.Sessions {
-		if sess.UserID != userID {
-			remainingSessions = append(remainingSessions, sess)
+		rotatedLongAgo := sess.FamilyID == familyID && sess.RotatedAt != nil && sess.RotatedAt.Before(rotatedBefore)
+		if rotatedLongAgo || !now.Before(sess.ExpiresAt) {
+			stale = append(stale, sess.ID)
+		}
+	}
+	for _, id := range stale {
+		if err := s.commit(deleteEntry(collSessions, id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *JSONStore) FindUserSessions(userID string) ([]domain.UserSession, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := []domain.UserSession{}
+	for _, sess := range db.Sessions {
+		if sess.UserID == userID {
+			sessions = append(sessions, sess)
 		}
 	}
-	s.cache.Sessions = remainingSessions
-	return s.save()
+	return sessions, nil
 }
 
 func (s *JSONStore) FindAllSessions() ([]domain.UserSession, error) {
@@ -266,3 +739,628 @@ func (s *JSONStore) FindAllSessions() ([]domain.UserSession, error) {
 	copy(sessions, db.Sessions)
 	return sessions, nil
 }
+
+// Auth Event Implementation
+
+func (s *JSONStore) CreateAuthEvent(event *domain.AuthEvent) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(collEvents, event.ID, event)
+}
+
+func (s *JSONStore) FindAuthEventsByUser(userID string) ([]domain.AuthEvent, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []domain.AuthEvent{}
+	for _, ev := range db.AuthEvents {
+		if ev.UserID == userID {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// Revoked Token Repository Implementation
+
+func (s *JSONStore) RevokeToken(token *domain.RevokedToken) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var expired []string
+	for _, t := range s.cache.RevokedTokens {
+		if !t.ExpiresAt.After(now) {
+			expired = append(expired, t.JTI)
+		}
+	}
+	for _, jti := range expired {
+		if err := s.commit(deleteEntry(collRevoked, jti)); err != nil {
+			return err
+		}
+	}
+
+	return s.put(collRevoked, token.JTI, token)
+}
+
+func (s *JSONStore) IsTokenRevoked(jti string) (bool, error) {
+	db, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range db.RevokedTokens {
+		if t.JTI == jti {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Passkey Repository Implementation
+
+func (s *JSONStore) CreatePasskey(passkey *domain.Passkey) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(collPasskeys, passkey.ID, passkey)
+}
+
+func (s *JSONStore) FindPasskey(id string) (*domain.Passkey, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, pk := range db.Passkeys {
+		if pk.ID == id {
+			passkey := pk
+			return &passkey, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *JSONStore) FindUserPasskeys(userID string) ([]domain.Passkey, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	passkeys := []domain.Passkey{}
+	for _, pk := range db.Passkeys {
+		if pk.UserID == userID {
+			passkeys = append(passkeys, pk)
+		}
+	}
+	return passkeys, nil
+}
+
+func (s *JSONStore) UpdatePasskey(passkey *domain.Passkey) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pk := range s.cache.Passkeys {
+		if pk.ID == passkey.ID {
+			return s.put(collPasskeys, passkey.ID, passkey)
+		}
+	}
+	return domain.ErrPasskeyNotFound
+}
+
+func (s *JSONStore) DeletePasskey(id string) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pk := range s.cache.Passkeys {
+		if pk.ID == id {
+			return s.commit(deleteEntry(collPasskeys, id))
+		}
+	}
+	return domain.ErrPasskeyNotFound
+}
+
+// API Token Repository Implementation
+
+func (s *JSONStore) CreateAPIToken(token *domain.APIToken) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(collAPITokens, token.ID, token)
+}
+
+func (s *JSONStore) FindAPITokenBySelector(selector string) (*domain.APIToken, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range db.APITokens {
+		if t.Selector == selector {
+			token := t
+			return &token, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *JSONStore) FindUserAPITokens(userID string) ([]domain.APIToken, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := []domain.APIToken{}
+	for _, t := range db.APITokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *JSONStore) UpdateAPIToken(token *domain.APIToken) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.cache.APITokens {
+		if t.ID == token.ID {
+			return s.put(collAPITokens, token.ID, token)
+		}
+	}
+	return domain.ErrAPITokenNotFound
+}
+
+func (s *JSONStore) DeleteAPIToken(id string) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.cache.APITokens {
+		if t.ID == id {
+			return s.commit(deleteEntry(collAPITokens, id))
+		}
+	}
+	return domain.ErrAPITokenNotFound
+}
+
+// Invitation Repository Implementation
+
+func (s *JSONStore) CreateInvitation(invitation *domain.Invitation) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(collInvitations, invitation.ID, invitation)
+}
+
+func (s *JSONStore) FindInvitationByID(id string) (*domain.Invitation, error) {
+	return s.findInvitation(func(inv domain.Invitation) bool { return inv.ID == id })
+}
+
+func (s *JSONStore) FindInvitationBySelector(selector string) (*domain.Invitation, error) {
+	return s.findInvitation(func(inv domain.Invitation) bool { return inv.Selector == selector })
+}
+
+func (s *JSONStore) findInvitation(match func(domain.Invitation) bool) (*domain.Invitation, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, inv := range db.Invitations {
+		if match(inv) {
+			invitation := inv
+			return &invitation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *JSONStore) FindAllInvitations() ([]domain.Invitation, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]domain.Invitation{}, db.Invitations...), nil
+}
+
+func (s *JSONStore) UpdateInvitation(invitation *domain.Invitation) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, inv := range s.cache.Invitations {
+		if inv.ID == invitation.ID {
+			return s.put(collInvitations, invitation.ID, invitation)
+		}
+	}
+	return domain.ErrInvitationNotFound
+}
+
+func (s *JSONStore) DeleteInvitation(id string) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, inv := range s.cache.Invitations {
+		if inv.ID == id {
+			return s.commit(deleteEntry(collInvitations, id))
+		}
+	}
+	return domain.ErrInvitationNotFound
+}
+
+// Sequence Repository Implementation
+
+func (s *JSONStore) NextSequenceValue(name string, start int64) (int64, error) {
+	if _, err := s.load(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := domain.Sequence{Name: name, Value: start}
+	for _, existing := range s.cache.Sequences {
+		if existing.Name == name {
+			seq.Value = existing.Value + 1
+			break
+		}
+	}
+	if err := s.put(collSequences, name, &seq); err != nil {
+		return 0, err
+	}
+	return seq.Value, nil
+}
+
+func (s *JSONStore) SeedSequence(name string, value int64) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.cache.Sequences {
+		if existing.Name == name && existing.Value >= value {
+			return nil
+		}
+	}
+	return s.put(collSequences, name, &domain.Sequence{Name: name, Value: value})
+}
+
+// Channel Repository Implementation
+
+func (s *JSONStore) FindAllChannels() ([]domain.Channel, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make([]domain.Channel, len(db.Channels))
+	copy(channels, db.Channels)
+	return channels, nil
+}
+
+func (s *JSONStore) FindChannelByID(id string) (*domain.Channel, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, ch := range db.Channels {
+		if ch.ID == id {
+			channel := ch
+			return &channel, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *JSONStore) CreateChannel(channel *domain.Channel) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(collChannels, channel.ID, channel)
+}
+
+func (s *JSONStore) UpdateChannel(channel *domain.Channel) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ch := range s.cache.Channels {
+		if ch.ID == channel.ID {
+			return s.put(collChannels, channel.ID, channel)
+		}
+	}
+	return domain.ErrChannelNotFound
+}
+
+func (s *JSONStore) DeleteChannel(id string) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ch := range s.cache.Channels {
+		if ch.ID == id {
+			return s.commit(deleteEntry(collChannels, id))
+		}
+	}
+	return domain.ErrChannelNotFound
+}
+
+// Message Repository Implementation
+
+func (s *JSONStore) FindAllMessages() ([]domain.Message, error) {
+	return s.filterMessages(func(m *domain.Message) bool { return true })
+}
+
+func (s *JSONStore) FindMessageByID(id string) (*domain.Message, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range db.Messages {
+		if m.ID == id {
+			msg := m
+			return &msg, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *JSONStore) FindMessagesByChannel(channelID string) ([]domain.Message, error) {
+	return s.filterMessages(func(m *domain.Message) bool {
+		return m.ChannelID == channelID
+	})
+}
+
+func (s *JSONStore) FindDirectMessages(userID, otherUserID string) ([]domain.Message, error) {
+	// Same convention as the websocket-server: dmId holds the recipient's user ID
+	return s.filterMessages(func(m *domain.Message) bool {
+		return (m.SenderID == userID && m.DMID == otherUserID) ||
+			(m.SenderID == otherUserID && m.DMID == userID)
+	})
+}
+
+func (s *JSONStore) FindThreadReplies(parentID string) ([]domain.Message, error) {
+	return s.filterMessages(func(m *domain.Message) bool {
+		return m.ParentID == parentID
+	})
+}
+
+func (s *JSONStore) CreateMessage(message *domain.Message) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(collMessages, message.ID, message)
+}
+
+func (s *JSONStore) UpdateMessage(message *domain.Message) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.cache.Messages {
+		if m.ID == message.ID {
+			return s.put(collMessages, message.ID, message)
+		}
+	}
+	return domain.ErrMessageNotFound
+}
+
+func (s *JSONStore) DeleteMessage(id string) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.cache.Messages {
+		if m.ID == id {
+			return s.commit(deleteEntry(collMessages, id))
+		}
+	}
+	return domain.ErrMessageNotFound
+}
+
+// filterMessages returns copies of the cached messages matching keep, in
+// stored order
+func (s *JSONStore) filterMessages(keep func(m *domain.Message) bool) ([]domain.Message, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []domain.Message{}
+	for i := range db.Messages {
+		if keep(&db.Messages[i]) {
+			messages = append(messages, db.Messages[i])
+		}
+	}
+	return messages, nil
+}
+
+// Task Repository Implementation
+
+func (s *JSONStore) FindAllTasks() ([]domain.Task, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tasks := make([]domain.Task, len(db.Tasks))
+	copy(tasks, db.Tasks)
+	return tasks, nil
+}
+
+func (s *JSONStore) FindTaskByID(id string) (*domain.Task, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range db.Tasks {
+		if t.ID == id {
+			task := t
+			return &task, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *JSONStore) FindTasksByAssignee(userID string) ([]domain.Task, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tasks := []domain.Task{}
+	for _, t := range db.Tasks {
+		for _, assignee := range t.AssigneeIDs {
+			if assignee == userID {
+				tasks = append(tasks, t)
+				break
+			}
+		}
+	}
+	return tasks, nil
+}
+
+func (s *JSONStore) CreateTask(task *domain.Task) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(collTasks, task.ID, task)
+}
+
+func (s *JSONStore) UpdateTask(task *domain.Task) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.cache.Tasks {
+		if t.ID == task.ID {
+			return s.put(collTasks, task.ID, task)
+		}
+	}
+	return domain.ErrTaskNotFound
+}
+
+func (s *JSONStore) DeleteTask(id string) error {
+	if _, err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.cache.Tasks {
+		if t.ID == id {
+			return s.commit(deleteEntry(collTasks, id))
+		}
+	}
+	return domain.ErrTaskNotFound
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+// websocketDB is a db.json as the websocket-server writes it, with members
+// no Go type has a field for at every level
+const websocketDB = `{
+  "users": [
+    {"id": "u1", "name": "Ada", "email": "ada@example.com", "password": "$2a$10$hash", "needsOnboarding": false,
+     "role": "ADMIN", "department": "Eng", "jobTitle": "CTO", "reportingManager": "", "staffNumber": "SLV-0001",
+     "status": "busy", "avatar": "", "createdAt": "2026-01-02T03:04:05Z", "theme": "dark"}
+  ],
+  "channels": [
+    {"id": "c1", "name": "general", "description": "All hands", "type": "public", "unreadCount": 0, "archived": false}
+  ],
+  "messages": [
+    {"id": "m1", "content": "hi", "senderId": "u1", "channelId": "c1", "timestamp": "2026-01-02T03:04:05Z",
+     "user": {"id": "u1", "name": "Ada", "avatar": ""}, "reactions": [{"emoji": "👍", "userIds": ["u1"]}], "pinned": true},
+    {"id": "m2", "content": "reply", "senderId": "u1", "dmId": "u1-u2", "parentId": "m1", "timestamp": "2026-01-02T03:05:00Z",
+     "editedAt": "2026-01-02T03:06:00Z"}
+  ],
+  "tasks": [
+    {"id": "t1", "title": "Ship", "status": "done", "assigneeIds": ["u1"], "creatorId": "u1", "dueDate": "2026-02-01",
+     "priority": "high", "completedAt": "2026-01-03T00:00:00Z",
+     "comments": [{"id": "k1", "taskId": "t1", "userId": "u1", "content": "done", "createdAt": "2026-01-03T00:00:00Z"}],
+     "labels": ["release"]}
+  ],
+  "workspaces": [{"id": "w1", "name": "StackleVest"}]
+}`
+
+func decodeDoc(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// findRecord returns the record of collection in doc with the given id
+func findRecord(doc map[string]any, collection, id string) map[string]any {
+	records, _ := doc[collection].([]any)
+	for _, r := range records {
+		if m, _ := r.(map[string]any); m["id"] == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// TestWebsocketFieldsSurviveAWrite loads a db.json written by the
+// websocket-server, changes some records through the store and checks that
+// the records left alone come back unchanged, and the changed ones keep the
+// members the store does not model
+func TestWebsocketFieldsSurviveAWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	if err := os.WriteFile(path, []byte(websocketDB), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := decodeDoc(t, []byte(websocketDB))
+
+	s := NewJSONStore(path)
+	task, err := s.FindTaskByID("t1")
+	if err != nil || task == nil {
+		t.Fatalf("FindTaskByID: %v, %v", task, err)
+	}
+	task.Status, task.CompletedAt = domain.TaskStatusInProgress, nil
+	if err := s.UpdateTask(task); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := s.FindMessageByID("m2")
+	if err != nil || msg == nil {
+		t.Fatalf("FindMessageByID: %v, %v", msg, err)
+	}
+	msg.Content = "reply, edited"
+	if err := s.UpdateMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := decodeDoc(t, data)
+
+	for _, r := range []struct{ collection, id string }{{"users", "u1"}, {"channels", "c1"}, {"messages", "m1"}} {
+		if got, want := findRecord(after, r.collection, r.id), findRecord(before, r.collection, r.id); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s %s after a write = %v, want %v", r.collection, r.id, got, want)
+		}
+	}
+	if !reflect.DeepEqual(after["workspaces"], before["workspaces"]) {
+		t.Errorf("workspaces after a write = %v, want %v", after["workspaces"], before["workspaces"])
+	}
+
+	m2 := findRecord(after, "messages", "m2")
+	if m2["content"] != "reply, edited" || m2["dmId"] != "u1-u2" || m2["parentId"] != "m1" ||
+		m2["editedAt"] != "2026-01-02T03:06:00Z" {
+		t.Errorf("message m2 after editing = %v, want the new content and its other members kept", m2)
+	}
+	t1 := findRecord(after, "tasks", "t1")
+	if _, ok := t1["completedAt"]; ok || t1["status"] != domain.TaskStatusInProgress {
+		t.Errorf("task t1 after reopening = %v, want it in progress without completedAt", t1)
+	}
+	if t1["creatorId"] != "u1" || !reflect.DeepEqual(t1["labels"], []any{"release"}) ||
+		!reflect.DeepEqual(t1["comments"], findRecord(before, "tasks", "t1")["comments"]) {
+		t.Errorf("task t1 after reopening = %v, want its creator, comments and labels kept", t1)
+	}
+}
@@ -0,0 +1,1248 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+	"modernc.org/sqlite" // Pure-Go driver, registers "sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// migrations are applied in order and recorded in schema_migrations.
+// Never edit an entry that has shipped; append a new one instead.
+var migrations = []string{
+	// 1: users and sessions
+	`CREATE TABLE users (
+		id                TEXT PRIMARY KEY,
+		name              TEXT NOT NULL DEFAULT '',
+		email             TEXT NOT NULL,
+		password          TEXT NOT NULL DEFAULT '',
+		needs_onboarding  INTEGER NOT NULL DEFAULT 0,
+		role              TEXT NOT NULL DEFAULT '',
+		department        TEXT NOT NULL DEFAULT '',
+		job_title         TEXT NOT NULL DEFAULT '',
+		reporting_manager TEXT NOT NULL DEFAULT '',
+		staff_number      TEXT NOT NULL DEFAULT '',
+		status            TEXT NOT NULL DEFAULT '',
+		avatar            TEXT NOT NULL DEFAULT '',
+		created_at        DATETIME NOT NULL
+	);
+	CREATE INDEX idx_users_email ON users (email COLLATE NOCASE);
+
+	CREATE TABLE sessions (
+		id            TEXT PRIMARY KEY,
+		user_id       TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		refresh_token TEXT NOT NULL,
+		expires_at    DATETIME NOT NULL,
+		created_at    DATETIME NOT NULL
+	);
+	CREATE INDEX idx_sessions_user_id ON sessions (user_id);`,
+
+	// 2: channels, messages and tasks. Nested collections are stored as JSON
+	// documents in the same shape the websocket-server writes to db.json.
+	`CREATE TABLE channels (
+		id           TEXT PRIMARY KEY,
+		name         TEXT NOT NULL DEFAULT '',
+		description  TEXT NOT NULL DEFAULT '',
+		type         TEXT NOT NULL DEFAULT '',
+		unread_count INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE messages (
+		id          TEXT PRIMARY KEY,
+		content     TEXT NOT NULL DEFAULT '',
+		sender_id   TEXT NOT NULL DEFAULT '',
+		channel_id  TEXT NOT NULL DEFAULT '',
+		dm_id       TEXT NOT NULL DEFAULT '',
+		parent_id   TEXT NOT NULL DEFAULT '',
+		timestamp   DATETIME NOT NULL,
+		attachments TEXT NOT NULL DEFAULT 'null',
+		reactions   TEXT NOT NULL DEFAULT 'null',
+		sender      TEXT NOT NULL DEFAULT 'null'
+	);
+	CREATE INDEX idx_messages_channel_id ON messages (channel_id, timestamp);
+	CREATE INDEX idx_messages_dm ON messages (sender_id, dm_id, timestamp);
+	CREATE INDEX idx_messages_parent_id ON messages (parent_id);
+
+	CREATE TABLE tasks (
+		id           TEXT PRIMARY KEY,
+		title        TEXT NOT NULL DEFAULT '',
+		description  TEXT NOT NULL DEFAULT '',
+		status       TEXT NOT NULL DEFAULT '',
+		priority     TEXT NOT NULL DEFAULT '',
+		assignee_ids TEXT NOT NULL DEFAULT '[]',
+		creator_id   TEXT NOT NULL DEFAULT '', -- Empty on legacy tasks
+		due_date     TEXT NOT NULL DEFAULT '',
+		channel_id   TEXT NOT NULL DEFAULT '',
+		dm_id        TEXT NOT NULL DEFAULT '',
+		progress     INTEGER,
+		completed_at DATETIME,
+		created_at   DATETIME,
+		comments     TEXT NOT NULL DEFAULT 'null'
+	);`,
+
+	// 3: selector/verifier refresh tokens. Pre-existing bcrypt sessions keep
+	// an empty selector and can no longer be used.
+	`ALTER TABLE sessions ADD COLUMN selector TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX idx_sessions_selector ON sessions (selector) WHERE selector <> '';`,
+
+	// 4: refresh-token families for reuse detection, and the auth event log
+	`ALTER TABLE sessions ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN rotated_at DATETIME;
+	CREATE INDEX idx_sessions_family_id ON sessions (family_id);
+
+	CREATE TABLE auth_events (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		type       TEXT NOT NULL,
+		family_id  TEXT NOT NULL DEFAULT '',
+		detail     TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_auth_events_user_id ON auth_events (user_id, created_at);`,
+
+	// 5: device details for session management
+	`ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN last_used_at DATETIME;`,
+
+	// 6: account lockout
+	`ALTER TABLE users ADD COLUMN failed_login_attempts INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN locked_until DATETIME;`,
+
+	// 7: access-token revocation
+	`ALTER TABLE users ADD COLUMN tokens_invalid_before DATETIME;
+
+	CREATE TABLE revoked_tokens (
+		jti        TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);`,
+
+	// 8: admin-forced password reset
+	`ALTER TABLE users ADD COLUMN must_reset_password INTEGER NOT NULL DEFAULT 0;`,
+
+	// 9: previous password hashes, as a JSON array
+	`ALTER TABLE users ADD COLUMN password_history TEXT NOT NULL DEFAULT '[]';`,
+
+	// 10: TOTP second factor
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN recovery_codes TEXT NOT NULL DEFAULT '[]';`,
+
+	// 11: WebAuthn passkeys
+	`CREATE TABLE passkeys (
+		id               TEXT PRIMARY KEY,
+		user_id          TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		name             TEXT NOT NULL DEFAULT '',
+		public_key       BLOB NOT NULL,
+		attestation_type TEXT NOT NULL DEFAULT '',
+		transports       TEXT NOT NULL DEFAULT '[]',
+		aaguid           BLOB,
+		sign_count       INTEGER NOT NULL DEFAULT 0,
+		backup_eligible  INTEGER NOT NULL DEFAULT 0,
+		backup_state     INTEGER NOT NULL DEFAULT 0,
+		last_used_at     DATETIME,
+		created_at       DATETIME NOT NULL
+	);
+	CREATE INDEX idx_passkeys_user_id ON passkeys (user_id);`,
+
+	// 12: single sign-on account link
+	`ALTER TABLE users ADD COLUMN oidc_subject TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX idx_users_oidc_subject ON users (oidc_subject) WHERE oidc_subject <> '';`,
+
+	// 13: personal access tokens and service API keys
+	`CREATE TABLE api_tokens (
+		id           TEXT PRIMARY KEY,
+		user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		name         TEXT NOT NULL DEFAULT '',
+		scopes       TEXT NOT NULL DEFAULT '[]',
+		selector     TEXT NOT NULL,
+		token_hash   TEXT NOT NULL,
+		created_by   TEXT NOT NULL DEFAULT '',
+		expires_at   DATETIME NOT NULL,
+		last_used_at DATETIME,
+		last_used_ip TEXT NOT NULL DEFAULT '',
+		created_at   DATETIME NOT NULL
+	);
+	CREATE UNIQUE INDEX idx_api_tokens_selector ON api_tokens (selector);
+	CREATE INDEX idx_api_tokens_user_id ON api_tokens (user_id);`,
+
+	// 14: onboarding invitations
+	`CREATE TABLE invitations (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		email      TEXT NOT NULL,
+		selector   TEXT NOT NULL,
+		token_hash TEXT NOT NULL,
+		invited_by TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME NOT NULL,
+		sent_at    DATETIME NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE UNIQUE INDEX idx_invitations_selector ON invitations (selector);
+	CREATE UNIQUE INDEX idx_invitations_user_id ON invitations (user_id);`,
+
+	// 15: counters for generated values such as staff numbers
+	`CREATE TABLE sequences (
+		name  TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	);`,
+
+	// 16: user directory filters and sort orders
+	`CREATE INDEX idx_users_created_at ON users (created_at, id);
+	CREATE INDEX idx_users_name ON users (name COLLATE NOCASE, id);
+	CREATE INDEX idx_users_department ON users (department COLLATE NOCASE, id);
+	CREATE INDEX idx_users_reporting_manager ON users (reporting_manager);`,
+
+	// 17: one account per email, ignoring case. Fails on a database that
+	// already holds such duplicates; they must be resolved by hand first.
+	`DROP INDEX idx_users_email;
+	CREATE UNIQUE INDEX idx_users_email ON users (email COLLATE NOCASE);`,
+}
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (creating if needed) the database at path and
+// brings its schema up to date
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialising through one connection
+	// avoids SQLITE_BUSY under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	store := &SQLiteStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// NewReadOnlySQLiteStore opens the existing database at path without ever
+// writing to it: the file is not created, every write fails, and the schema
+// must already be up to date, since updating it would be a write
+func NewReadOnlySQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("read schema version of %s: %w", path, err)
+	}
+	if current != len(migrations) {
+		db.Close()
+		return nil, fmt.Errorf("%s is at schema version %d of %d; open it read-write to update it", path, current, len(migrations))
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at DATETIME NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Implement UserRepository
+
+const userColumns = `id, name, email, password, needs_onboarding, role, department,
+	job_title, reporting_manager, staff_number, status, avatar, created_at, failed_login_attempts, locked_until,
+	tokens_invalid_before, must_reset_password, password_history, totp_secret, totp_enabled, totp_last_step,
+	recovery_codes, oidc_subject`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*domain.User, error) {
+	var u domain.User
+	var lockedUntil, tokensInvalidBefore sql.NullTime
+	var passwordHistory, recoveryCodes string
+	if err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Password, &u.NeedsOnboarding, &u.Role, &u.Department,
+		&u.JobTitle, &u.ReportingManager, &u.StaffNumber, &u.Status, &u.Avatar, &u.CreatedAt,
+		&u.FailedLoginAttempts, &lockedUntil, &tokensInvalidBefore, &u.MustResetPassword, &passwordHistory,
+		&u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep, &recoveryCodes, &u.OIDCSubject); err != nil {
+		return nil, err
+	}
+	if err := unmarshalColumns(passwordHistory, &u.PasswordHistory, recoveryCodes, &u.RecoveryCodes); err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
+	if tokensInvalidBefore.Valid {
+		u.TokensInvalidBefore = &tokensInvalidBefore.Time
+	}
+	return &u, nil
+}
+
+func (s *SQLiteStore) FindAll() ([]domain.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// userSortColumns maps the user sort keys to their columns
+var userSortColumns = map[string]string{
+	domain.SortCreatedAt:   "created_at",
+	domain.SortName:        "name",
+	domain.SortEmail:       "email",
+	domain.SortDepartment:  "department",
+	domain.SortStaffNumber: "staff_number",
+}
+
+// likeEscaper escapes the LIKE wildcards, with \ as the escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *SQLiteStore) FindUsers(q domain.UserQuery) (*domain.UserPage, error) {
+	key, desc := q.SortKey()
+	column, ok := userSortColumns[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown user sort key %q", key)
+	}
+	cursor, err := domain.DecodeUserCursor(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var where []string
+	var args []interface{}
+	for _, f := range []struct{ column, value string }{
+		{"role", q.Role}, {"department", q.Department}, {"status", q.Status},
+	} {
+		if f.value != "" {
+			where = append(where, f.column+` = ? COLLATE NOCASE`)
+			args = append(args, f.value)
+		}
+	}
+	if q.ReportingManager != "" {
+		where = append(where, `reporting_manager = ?`)
+		args = append(args, q.ReportingManager)
+	}
+	if q.Search != "" {
+		pattern := "%" + likeEscaper.Replace(q.Search) + "%"
+		where = append(where, `(name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\' OR job_title LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	// Text columns sort case-insensitively. created_at never changes, so the
+	// cursor's position is read from its row, in the stored format; the
+	// cursor's copy is only for a user deleted since.
+	op, dir, collate := ">", "ASC", " COLLATE NOCASE"
+	if desc {
+		op, dir = "<", "DESC"
+	}
+	if key == domain.SortCreatedAt {
+		collate = ""
+	}
+	if cursor != nil {
+		bound := `?` + collate
+		if key == domain.SortCreatedAt {
+			bound = `COALESCE((SELECT created_at FROM users WHERE id = ?), ?)`
+			createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, domain.ErrInvalidCursor
+			}
+			args = append(args, cursor.ID, createdAt, cursor.ID, createdAt, cursor.ID)
+		} else {
+			args = append(args, cursor.Value, cursor.Value, cursor.ID)
+		}
+		where = append(where, fmt.Sprintf(`(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[2]s ?))`, column, op, bound))
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	limit := q.PageSize()
+	query += fmt.Sprintf(` ORDER BY %s%s %s, id %s LIMIT ?`, column, collate, dir, dir)
+	args = append(args, limit+1) // One more tells whether there is a next page
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &domain.UserPage{Users: []domain.User{}}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Users) > limit {
+		page.Users = page.Users[:limit]
+		page.NextCursor = domain.NextUserCursor(q, &page.Users[limit-1])
+	}
+	return page, nil
+}
+
+func (s *SQLiteStore) FindByID(id string) (*domain.User, error) {
+	u, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil // Not found
+	}
+	return u, err
+}
+
+func (s *SQLiteStore) FindByEmail(email string) (*domain.User, error) {
+	u, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ? COLLATE NOCASE`, email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (s *SQLiteStore) FindByOIDCSubject(subject string) (*domain.User, error) {
+	u, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE oidc_subject = ? AND oidc_subject <> ''`, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (s *SQLiteStore) Create(user *domain.User) error {
+	cols, err := marshalColumns(user.PasswordHistory, user.RecoveryCodes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO users (`+userColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Name, user.Email, user.Password, user.NeedsOnboarding, user.Role, user.Department,
+		user.JobTitle, user.ReportingManager, user.StaffNumber, user.Status, user.Avatar, user.CreatedAt,
+		user.FailedLoginAttempts, user.LockedUntil, user.TokensInvalidBefore, user.MustResetPassword, cols[0],
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, cols[1], user.OIDCSubject)
+	return userWriteError(err)
+}
+
+func (s *SQLiteStore) Update(user *domain.User) error {
+	cols, err := marshalColumns(user.PasswordHistory, user.RecoveryCodes)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE users SET name = ?, email = ?, password = ?, needs_onboarding = ?, role = ?,
+		department = ?, job_title = ?, reporting_manager = ?, staff_number = ?, status = ?, avatar = ?, created_at = ?,
+		failed_login_attempts = ?, locked_until = ?, tokens_invalid_before = ?, must_reset_password = ?,
+		password_history = ?, totp_secret = ?, totp_enabled = ?, totp_last_step = ?, recovery_codes = ?,
+		oidc_subject = ?
+		WHERE id = ?`,
+		user.Name, user.Email, user.Password, user.NeedsOnboarding, user.Role,
+		user.Department, user.JobTitle, user.ReportingManager, user.StaffNumber, user.Status, user.Avatar, user.CreatedAt,
+		user.FailedLoginAttempts, user.LockedUntil, user.TokensInvalidBefore, user.MustResetPassword, cols[0],
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, cols[1],
+		user.OIDCSubject,
+		user.ID)
+	if err != nil {
+		return userWriteError(err)
+	}
+	return expectAffected(res, domain.ErrUserNotFound)
+}
+
+// userWriteError turns a clash on the unique email index into
+// domain.ErrEmailTaken, for writes that race past the service's check
+func userWriteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), "users.email") {
+		return domain.ErrEmailTaken
+	}
+	return err
+}
+
+func (s *SQLiteStore) Delete(id string) error {
+	res, err := s.db.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrUserNotFound)
+}
+
+// expectAffected turns an UPDATE/DELETE that matched no rows into notFound
+func expectAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// Session Management Implementation
+
+const sessionColumns = `id, user_id, selector, refresh_token, family_id, rotated_at, ip, user_agent,
+	last_used_at, expires_at, created_at`
+
+func scanSession(row rowScanner) (*domain.UserSession, error) {
+	var sess domain.UserSession
+	var rotatedAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&sess.ID, &sess.UserID, &sess.Selector, &sess.RefreshToken, &sess.FamilyID, &rotatedAt,
+		&sess.IP, &sess.UserAgent, &lastUsedAt, &sess.ExpiresAt, &sess.CreatedAt); err != nil {
+		return nil, err
+	}
+	if rotatedAt.Valid {
+		sess.RotatedAt = &rotatedAt.Time
+	}
+	if lastUsedAt.Valid {
+		sess.LastUsedAt = lastUsedAt.Time
+	}
+	return &sess, nil
+}
+
+// Rotation and expiry times are stored in UTC so DeleteStaleSessions can
+// compare them as text
+func (s *SQLiteStore) CreateSession(session *domain.UserSession) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.Selector, session.RefreshToken, session.FamilyID, utc(session.RotatedAt),
+		session.IP, session.UserAgent, session.LastUsedAt, session.ExpiresAt.UTC(), session.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) UpdateSession(session *domain.UserSession) error {
+	res, err := s.db.Exec(`UPDATE sessions SET user_id = ?, selector = ?, refresh_token = ?, family_id = ?,
+		rotated_at = ?, ip = ?, user_agent = ?, last_used_at = ?, expires_at = ?, created_at = ? WHERE id = ?`,
+		session.UserID, session.Selector, session.RefreshToken, session.FamilyID, utc(session.RotatedAt),
+		session.IP, session.UserAgent, session.LastUsedAt, session.ExpiresAt.UTC(), session.CreatedAt, session.ID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrSessionNotFound)
+}
+
+func (s *SQLiteStore) RotateSession(id string, at time.Time) (bool, error) {
+	res, err := s.db.Exec(`UPDATE sessions SET rotated_at = ? WHERE id = ? AND rotated_at IS NULL`, at.UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// utc returns t in UTC, or nil for no time
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func (s *SQLiteStore) FindSessionByID(id string) (*domain.UserSession, error) {
+	sess, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return sess, err
+}
+
+func (s *SQLiteStore) FindSessionBySelector(selector string) (*domain.UserSession, error) {
+	if selector == "" {
+		return nil, nil // Legacy sessions share the empty selector
+	}
+	sess, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE selector = ?`, selector))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return sess, err
+}
+
+func (s *SQLiteStore) DeleteSession(id string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err // Already deleted or not found is not an error
+}
+
+func (s *SQLiteStore) DeleteSessionFamily(familyID string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE family_id = ?`, familyID)
+	return err
+}
+
+func (s *SQLiteStore) DeleteUserSessions(userID string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
+
+func (s *SQLiteStore) DeleteStaleSessions(familyID string, rotatedBefore time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE (family_id = ? AND rotated_at < ?) OR expires_at <= ?`,
+		familyID, rotatedBefore.UTC(), time.Now().UTC())
+	return err
+}
+
+func (s *SQLiteStore) FindUserSessions(userID string) ([]domain.UserSession, error) {
+	return s.querySessions(`WHERE user_id = ? ORDER BY created_at`, userID)
+}
+
+func (s *SQLiteStore) FindAllSessions() ([]domain.UserSession, error) {
+	return s.querySessions(``)
+}
+
+func (s *SQLiteStore) querySessions(where string, args ...interface{}) ([]domain.UserSession, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []domain.UserSession{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	return sessions, rows.Err()
+}
+
+// Auth Event Implementation
+
+func (s *SQLiteStore) CreateAuthEvent(event *domain.AuthEvent) error {
+	_, err := s.db.Exec(`INSERT INTO auth_events (id, user_id, type, family_id, detail, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		event.ID, event.UserID, event.Type, event.FamilyID, event.Detail, event.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) FindAuthEventsByUser(userID string) ([]domain.AuthEvent, error) {
+	rows, err := s.db.Query(`SELECT id, user_id, type, family_id, detail, created_at FROM auth_events
+		WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []domain.AuthEvent{}
+	for rows.Next() {
+		var ev domain.AuthEvent
+		if err := rows.Scan(&ev.ID, &ev.UserID, &ev.Type, &ev.FamilyID, &ev.Detail, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// Revoked Token Implementation
+
+// Expiry times are stored in UTC so they compare correctly as text
+func (s *SQLiteStore) RevokeToken(token *domain.RevokedToken) error {
+	if _, err := s.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= ?`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO revoked_tokens (jti, user_id, expires_at) VALUES (?, ?, ?)`,
+		token.JTI, token.UserID, token.ExpiresAt.UTC())
+	return err
+}
+
+func (s *SQLiteStore) IsTokenRevoked(jti string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).Scan(&n)
+	return n > 0, err
+}
+
+// Passkey Repository Implementation
+
+const passkeyColumns = `id, user_id, name, public_key, attestation_type, transports, aaguid, sign_count,
+	backup_eligible, backup_state, last_used_at, created_at`
+
+func scanPasskey(row rowScanner) (*domain.Passkey, error) {
+	var p domain.Passkey
+	var transports string
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.PublicKey, &p.AttestationType, &transports, &p.AAGUID,
+		&p.SignCount, &p.BackupEligible, &p.BackupState, &lastUsedAt, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := unmarshalColumns(transports, &p.Transports); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		p.LastUsedAt = &lastUsedAt.Time
+	}
+	return &p, nil
+}
+
+func (s *SQLiteStore) CreatePasskey(passkey *domain.Passkey) error {
+	cols, err := marshalColumns(passkey.Transports)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO passkeys (`+passkeyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		passkey.ID, passkey.UserID, passkey.Name, passkey.PublicKey, passkey.AttestationType, cols[0], passkey.AAGUID,
+		passkey.SignCount, passkey.BackupEligible, passkey.BackupState, passkey.LastUsedAt, passkey.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) FindPasskey(id string) (*domain.Passkey, error) {
+	p, err := scanPasskey(s.db.QueryRow(`SELECT `+passkeyColumns+` FROM passkeys WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (s *SQLiteStore) FindUserPasskeys(userID string) ([]domain.Passkey, error) {
+	rows, err := s.db.Query(`SELECT `+passkeyColumns+` FROM passkeys WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []domain.Passkey{}
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, *p)
+	}
+	return passkeys, rows.Err()
+}
+
+func (s *SQLiteStore) UpdatePasskey(passkey *domain.Passkey) error {
+	cols, err := marshalColumns(passkey.Transports)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE passkeys SET name = ?, transports = ?, sign_count = ?, backup_eligible = ?,
+		backup_state = ?, last_used_at = ? WHERE id = ?`,
+		passkey.Name, cols[0], passkey.SignCount, passkey.BackupEligible, passkey.BackupState, passkey.LastUsedAt,
+		passkey.ID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrPasskeyNotFound)
+}
+
+func (s *SQLiteStore) DeletePasskey(id string) error {
+	res, err := s.db.Exec(`DELETE FROM passkeys WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrPasskeyNotFound)
+}
+
+// API Token Repository Implementation
+
+const apiTokenColumns = `id, user_id, name, scopes, selector, token_hash, created_by, expires_at, last_used_at,
+	last_used_ip, created_at`
+
+func scanAPIToken(row rowScanner) (*domain.APIToken, error) {
+	var t domain.APIToken
+	var scopes string
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Selector, &t.TokenHash, &t.CreatedBy, &t.ExpiresAt,
+		&lastUsedAt, &t.LastUsedIP, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := unmarshalColumns(scopes, &t.Scopes); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
+
+func (s *SQLiteStore) CreateAPIToken(token *domain.APIToken) error {
+	cols, err := marshalColumns(token.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO api_tokens (`+apiTokenColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.ID, token.UserID, token.Name, cols[0], token.Selector, token.TokenHash, token.CreatedBy, token.ExpiresAt,
+		token.LastUsedAt, token.LastUsedIP, token.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) FindAPITokenBySelector(selector string) (*domain.APIToken, error) {
+	t, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE selector = ?`, selector))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *SQLiteStore) FindUserAPITokens(userID string) ([]domain.APIToken, error) {
+	rows, err := s.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []domain.APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateAPIToken(token *domain.APIToken) error {
+	cols, err := marshalColumns(token.Scopes)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE api_tokens SET name = ?, scopes = ?, expires_at = ?, last_used_at = ?,
+		last_used_ip = ? WHERE id = ?`,
+		token.Name, cols[0], token.ExpiresAt, token.LastUsedAt, token.LastUsedIP, token.ID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrAPITokenNotFound)
+}
+
+func (s *SQLiteStore) DeleteAPIToken(id string) error {
+	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrAPITokenNotFound)
+}
+
+// Invitation Repository Implementation
+
+const invitationColumns = `id, user_id, email, selector, token_hash, invited_by, expires_at, sent_at, created_at`
+
+func scanInvitation(row rowScanner) (*domain.Invitation, error) {
+	var i domain.Invitation
+	if err := row.Scan(&i.ID, &i.UserID, &i.Email, &i.Selector, &i.TokenHash, &i.InvitedBy, &i.ExpiresAt, &i.SentAt,
+		&i.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (s *SQLiteStore) CreateInvitation(invitation *domain.Invitation) error {
+	_, err := s.db.Exec(`INSERT INTO invitations (`+invitationColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invitation.ID, invitation.UserID, invitation.Email, invitation.Selector, invitation.TokenHash,
+		invitation.InvitedBy, invitation.ExpiresAt, invitation.SentAt, invitation.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) FindInvitationByID(id string) (*domain.Invitation, error) {
+	i, err := scanInvitation(s.db.QueryRow(`SELECT `+invitationColumns+` FROM invitations WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return i, err
+}
+
+func (s *SQLiteStore) FindInvitationBySelector(selector string) (*domain.Invitation, error) {
+	i, err := scanInvitation(s.db.QueryRow(`SELECT `+invitationColumns+` FROM invitations WHERE selector = ?`, selector))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return i, err
+}
+
+func (s *SQLiteStore) FindAllInvitations() ([]domain.Invitation, error) {
+	rows, err := s.db.Query(`SELECT ` + invitationColumns + ` FROM invitations ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []domain.Invitation{}
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *i)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateInvitation(invitation *domain.Invitation) error {
+	res, err := s.db.Exec(`UPDATE invitations SET email = ?, selector = ?, token_hash = ?, invited_by = ?,
+		expires_at = ?, sent_at = ? WHERE id = ?`,
+		invitation.Email, invitation.Selector, invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt,
+		invitation.SentAt, invitation.ID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrInvitationNotFound)
+}
+
+func (s *SQLiteStore) DeleteInvitation(id string) error {
+	res, err := s.db.Exec(`DELETE FROM invitations WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrInvitationNotFound)
+}
+
+// Sequence Repository Implementation
+
+func (s *SQLiteStore) NextSequenceValue(name string, start int64) (int64, error) {
+	var value int64
+	err := s.db.QueryRow(`INSERT INTO sequences (name, value) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = value + 1 RETURNING value`, name, start).Scan(&value)
+	return value, err
+}
+
+func (s *SQLiteStore) SeedSequence(name string, value int64) error {
+	_, err := s.db.Exec(`INSERT INTO sequences (name, value) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = MAX(value, excluded.value)`, name, value)
+	return err
+}
+
+// Channel Repository Implementation
+
+const channelColumns = `id, name, description, type, unread_count`
+
+func scanChannel(row rowScanner) (*domain.Channel, error) {
+	var ch domain.Channel
+	if err := row.Scan(&ch.ID, &ch.Name, &ch.Description, &ch.Type, &ch.UnreadCount); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (s *SQLiteStore) FindAllChannels() ([]domain.Channel, error) {
+	rows, err := s.db.Query(`SELECT ` + channelColumns + ` FROM channels ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []domain.Channel{}
+	for rows.Next() {
+		ch, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, *ch)
+	}
+	return channels, rows.Err()
+}
+
+func (s *SQLiteStore) FindChannelByID(id string) (*domain.Channel, error) {
+	ch, err := scanChannel(s.db.QueryRow(`SELECT `+channelColumns+` FROM channels WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return ch, err
+}
+
+func (s *SQLiteStore) CreateChannel(channel *domain.Channel) error {
+	_, err := s.db.Exec(`INSERT INTO channels (`+channelColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		channel.ID, channel.Name, channel.Description, channel.Type, channel.UnreadCount)
+	return err
+}
+
+func (s *SQLiteStore) UpdateChannel(channel *domain.Channel) error {
+	res, err := s.db.Exec(`UPDATE channels SET name = ?, description = ?, type = ?, unread_count = ? WHERE id = ?`,
+		channel.Name, channel.Description, channel.Type, channel.UnreadCount, channel.ID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrChannelNotFound)
+}
+
+func (s *SQLiteStore) DeleteChannel(id string) error {
+	res, err := s.db.Exec(`DELETE FROM channels WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrChannelNotFound)
+}
+
+// Message Repository Implementation
+
+const messageColumns = `id, content, sender_id, channel_id, dm_id, parent_id, timestamp, attachments, reactions, sender`
+
+func scanMessage(row rowScanner) (*domain.Message, error) {
+	var m domain.Message
+	var attachments, reactions, sender string
+	if err := row.Scan(&m.ID, &m.Content, &m.SenderID, &m.ChannelID, &m.DMID, &m.ParentID, &m.Timestamp,
+		&attachments, &reactions, &sender); err != nil {
+		return nil, err
+	}
+	if err := unmarshalColumns(
+		attachments, &m.Attachments,
+		reactions, &m.Reactions,
+		sender, &m.User,
+	); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *SQLiteStore) queryMessages(where string, args ...interface{}) ([]domain.Message, error) {
+	rows, err := s.db.Query(`SELECT `+messageColumns+` FROM messages `+where+` ORDER BY timestamp, rowid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []domain.Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *m)
+	}
+	return messages, rows.Err()
+}
+
+func (s *SQLiteStore) FindAllMessages() ([]domain.Message, error) {
+	return s.queryMessages(``)
+}
+
+func (s *SQLiteStore) FindMessageByID(id string) (*domain.Message, error) {
+	m, err := scanMessage(s.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return m, err
+}
+
+func (s *SQLiteStore) FindMessagesByChannel(channelID string) ([]domain.Message, error) {
+	return s.queryMessages(`WHERE channel_id = ?`, channelID)
+}
+
+func (s *SQLiteStore) FindDirectMessages(userID, otherUserID string) ([]domain.Message, error) {
+	return s.queryMessages(`WHERE (sender_id = ? AND dm_id = ?) OR (sender_id = ? AND dm_id = ?)`,
+		userID, otherUserID, otherUserID, userID)
+}
+
+func (s *SQLiteStore) FindThreadReplies(parentID string) ([]domain.Message, error) {
+	return s.queryMessages(`WHERE parent_id = ?`, parentID)
+}
+
+func (s *SQLiteStore) CreateMessage(message *domain.Message) error {
+	cols, err := marshalColumns(message.Attachments, message.Reactions, message.User)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO messages (`+messageColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		message.ID, message.Content, message.SenderID, message.ChannelID, message.DMID, message.ParentID,
+		message.Timestamp, cols[0], cols[1], cols[2])
+	return err
+}
+
+func (s *SQLiteStore) UpdateMessage(message *domain.Message) error {
+	cols, err := marshalColumns(message.Attachments, message.Reactions, message.User)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE messages SET content = ?, sender_id = ?, channel_id = ?, dm_id = ?, parent_id = ?,
+		timestamp = ?, attachments = ?, reactions = ?, sender = ? WHERE id = ?`,
+		message.Content, message.SenderID, message.ChannelID, message.DMID, message.ParentID,
+		message.Timestamp, cols[0], cols[1], cols[2], message.ID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrMessageNotFound)
+}
+
+func (s *SQLiteStore) DeleteMessage(id string) error {
+	res, err := s.db.Exec(`DELETE FROM messages WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrMessageNotFound)
+}
+
+// Task Repository Implementation
+
+const taskColumns = `id, title, description, status, priority, assignee_ids, creator_id, due_date,
+	channel_id, dm_id, progress, completed_at, created_at, comments`
+
+func scanTask(row rowScanner) (*domain.Task, error) {
+	var t domain.Task
+	var assignees, comments string
+	var progress sql.NullInt64
+	var completedAt, createdAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Title, &t.Description, &t.Status, &t.Priority, &assignees, &t.CreatorID, &t.DueDate,
+		&t.ChannelID, &t.DMID, &progress, &completedAt, &createdAt, &comments); err != nil {
+		return nil, err
+	}
+	if err := unmarshalColumns(assignees, &t.AssigneeIDs, comments, &t.Comments); err != nil {
+		return nil, err
+	}
+	if progress.Valid {
+		p := int(progress.Int64)
+		t.Progress = &p
+	}
+	if completedAt.Valid {
+		t.CompletedAt = &completedAt.Time
+	}
+	if createdAt.Valid {
+		t.CreatedAt = &createdAt.Time
+	}
+	return &t, nil
+}
+
+func (s *SQLiteStore) queryTasks(where string, args ...interface{}) ([]domain.Task, error) {
+	rows, err := s.db.Query(`SELECT `+taskColumns+` FROM tasks `+where+` ORDER BY rowid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []domain.Task{}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *t)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *SQLiteStore) FindAllTasks() ([]domain.Task, error) {
+	return s.queryTasks(``)
+}
+
+func (s *SQLiteStore) FindTaskByID(id string) (*domain.Task, error) {
+	t, err := scanTask(s.db.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *SQLiteStore) FindTasksByAssignee(userID string) ([]domain.Task, error) {
+	return s.queryTasks(`WHERE EXISTS (SELECT 1 FROM json_each(tasks.assignee_ids) WHERE value = ?)`, userID)
+}
+
+func taskArgs(task *domain.Task) ([]interface{}, error) {
+	assignees := task.AssigneeIDs
+	if assignees == nil {
+		assignees = []string{}
+	}
+	cols, err := marshalColumns(assignees, task.Comments)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{task.Title, task.Description, task.Status, task.Priority, cols[0], task.CreatorID, task.DueDate,
+		task.ChannelID, task.DMID, task.Progress, task.CompletedAt, task.CreatedAt, cols[1]}, nil
+}
+
+func (s *SQLiteStore) CreateTask(task *domain.Task) error {
+	args, err := taskArgs(task)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO tasks (`+taskColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append([]interface{}{task.ID}, args...)...)
+	return err
+}
+
+func (s *SQLiteStore) UpdateTask(task *domain.Task) error {
+	args, err := taskArgs(task)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE tasks SET title = ?, description = ?, status = ?, priority = ?, assignee_ids = ?,
+		creator_id = ?, due_date = ?, channel_id = ?, dm_id = ?, progress = ?, completed_at = ?, created_at = ?,
+		comments = ? WHERE id = ?`, append(args, task.ID)...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrTaskNotFound)
+}
+
+func (s *SQLiteStore) DeleteTask(id string) error {
+	res, err := s.db.Exec(`DELETE FROM tasks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, domain.ErrTaskNotFound)
+}
+
+// marshalColumns encodes each value as a JSON document column
+func marshalColumns(values ...interface{}) ([]string, error) {
+	cols := make([]string, len(values))
+	for i, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		cols[i] = string(data)
+	}
+	return cols, nil
+}
+
+// unmarshalColumns decodes pairs of (JSON column, destination pointer)
+func unmarshalColumns(pairs ...interface{}) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if err := json.Unmarshal([]byte(pairs[i].(string)), pairs[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stacklevest/backend/internal/domain"
+)
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// TestEmailIsUniqueIgnoringCase checks that the database itself refuses a
+// second account for an email, should two writes race past the service
+func TestEmailIsUniqueIgnoringCase(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	now := time.Now()
+	if err := store.Create(&domain.User{ID: "u1", Name: "Ada", Email: "ada@example.com", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	bob := &domain.User{ID: "u2", Name: "Bob", Email: "bob@example.com", CreatedAt: now}
+	if err := store.Create(bob); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Create(&domain.User{ID: "u3", Name: "Ada", Email: "ADA@example.com", CreatedAt: now}); !errors.Is(err, domain.ErrEmailTaken) {
+		t.Fatalf("Create with a taken email: got %v, want ErrEmailTaken", err)
+	}
+	bob.Email = "Ada@Example.com"
+	if err := store.Update(bob); !errors.Is(err, domain.ErrEmailTaken) {
+		t.Fatalf("Update to a taken email: got %v, want ErrEmailTaken", err)
+	}
+}
+
+// TestMigrationsOnEmptyDatabase runs every migration on a new file, checks
+// that each is recorded once and that every table answers queries, then
+// reopens the file to check that nothing is applied twice
+func TestMigrationsOnEmptyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := func() []int {
+		t.Helper()
+		rows, err := store.db.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var versions []int
+		for rows.Next() {
+			var v int
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			versions = append(versions, v)
+		}
+		return versions
+	}
+	applied := versions()
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	for i, v := range applied {
+		if v != i+1 {
+			t.Fatalf("applied versions %v, want 1 to %d in order", applied, len(migrations))
+		}
+	}
+
+	if users, err := store.FindAll(); err != nil || len(users) != 0 {
+		t.Errorf("FindAll = %v, %v; want no users", users, err)
+	}
+	if page, err := store.FindUsers(domain.UserQuery{Sort: "-" + domain.SortName}); err != nil || len(page.Users) != 0 {
+		t.Errorf("FindUsers = %+v, %v; want an empty page", page, err)
+	}
+	for name, find := range map[string]func() (int, error){
+		"sessions":    func() (int, error) { s, err := store.FindAllSessions(); return len(s), err },
+		"invitations": func() (int, error) { s, err := store.FindAllInvitations(); return len(s), err },
+		"channels":    func() (int, error) { s, err := store.FindAllChannels(); return len(s), err },
+		"messages":    func() (int, error) { s, err := store.FindAllMessages(); return len(s), err },
+		"tasks":       func() (int, error) { s, err := store.FindAllTasks(); return len(s), err },
+		"auth events": func() (int, error) { s, err := store.FindAuthEventsByUser("u1"); return len(s), err },
+		"passkeys":    func() (int, error) { s, err := store.FindUserPasskeys("u1"); return len(s), err },
+		"api tokens":  func() (int, error) { s, err := store.FindUserAPITokens("u1"); return len(s), err },
+	} {
+		if n, err := find(); err != nil || n != 0 {
+			t.Errorf("%s: found %d, %v; want none", name, n, err)
+		}
+	}
+	if n, err := store.NextSequenceValue(domain.StaffNumberSequence, 1); err != nil || n != 1 {
+		t.Errorf("first staff number = %d, %v; want 1", n, err)
+	}
+	if err := store.Create(&domain.User{ID: "u1", Name: "Ada", Email: "ada@example.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer store.Close()
+	if again := versions(); len(again) != len(migrations) {
+		t.Errorf("reopening applied migrations again: %v", again)
+	}
+	if u, err := store.FindByID("u1"); err != nil || u == nil {
+		t.Errorf("FindByID after reopening = %v, %v", u, err)
+	}
+}
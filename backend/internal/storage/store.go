@@ -0,0 +1,18 @@
+package storage
+
+import "github.com/stacklevest/backend/internal/domain"
+
+// Store is implemented by every storage backend
+type Store interface {
+	domain.UserRepository
+	domain.AuthEventRepository
+	domain.RevokedTokenRepository
+	domain.PasskeyRepository
+	domain.APITokenRepository
+	domain.InvitationRepository
+	domain.SequenceRepository
+	domain.ChannelRepository
+	domain.MessageRepository
+	domain.TaskRepository
+	Close() error
+}
@@ -1,20 +1,41 @@
 package user
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/dto"
 	"github.com/stacklevest/backend/internal/middleware"
+	"github.com/stacklevest/backend/internal/password"
 )
 
+// AccountManager lists and revokes a user's sessions, lifts login lockouts,
+// forces password resets, clears 2FA and manages API tokens (implemented by
+// auth.AuthService)
+type AccountManager interface {
+	ListSessions(userID string) ([]domain.UserSession, error)
+	RevokeSession(userID, sessionID string) error
+	RevokeAllSessions(userID string) error
+	UnlockAccount(userID string) error
+	ForcePasswordReset(userID string) error
+	ResetTwoFactor(userID string) error
+	ListAPITokens(userID string) ([]domain.APIToken, error)
+	CreateAPIToken(ownerID, creatorID, name string, scopes []string, ttl time.Duration) (*domain.APIToken, string, error)
+	RevokeAPIToken(userID, tokenID string) error
+}
+
 type UserHandler struct {
-	service *UserService
+	service  *UserService
+	accounts AccountManager
 }
 
-func NewUserHandler(service *UserService) *UserHandler {
+func NewUserHandler(service *UserService, accounts AccountManager) *UserHandler {
 	return &UserHandler{
-		service: service,
+		service:  service,
+		accounts: accounts,
 	}
 }
 
@@ -25,93 +46,256 @@ func (h *UserHandler) RegisterRoutes(app *fiber.App, authMiddleware fiber.Handle
 	// Apply Auth Middleware to all routes
 	users.Use(authMiddleware)
 
+	// Self-service
+	users.Patch("/me", h.PatchMe)
+
 	// Admin Only Routes
 	users.Get("/", middleware.AdminGuard, h.GetAll)
 	users.Post("/", middleware.AdminGuard, h.Create)
 	users.Put("/:id", middleware.AdminGuard, h.Update)
+	users.Patch("/:id", middleware.AdminGuard, h.Patch)
 	users.Delete("/:id", middleware.AdminGuard, h.Delete)
+	users.Get("/:id/sessions", middleware.AdminGuard, h.GetSessions)
+	users.Delete("/:id/sessions", middleware.AdminGuard, h.RevokeAllSessions)
+	users.Delete("/:id/sessions/:sessionId", middleware.AdminGuard, h.RevokeSession)
+	users.Post("/:id/unlock", middleware.AdminGuard, h.Unlock)
+	users.Post("/:id/force-password-reset", middleware.AdminGuard, h.ForcePasswordReset)
+	users.Delete("/:id/2fa", middleware.AdminGuard, h.ResetTwoFactor)
+	users.Get("/:id/tokens", middleware.AdminGuard, h.GetAPITokens)
+	users.Post("/:id/tokens", middleware.AdminGuard, h.CreateAPIToken)
+	users.Delete("/:id/tokens/:tokenId", middleware.AdminGuard, h.RevokeAPIToken)
 	
 	users.Get("/email/:email", h.GetByEmail)
 	users.Get("/:id", h.GetByID)
+
+	// Organisation chart, for every signed-in user
+	org := app.Group("/api/org", authMiddleware)
+	org.Get("/", h.GetOrgChart)
+	org.Get("/:id", h.GetOrgView)
 }
 
 func (h *UserHandler) Create(c *fiber.Ctx) error {
-	var u domain.User
-	if err := c.BodyParser(&u); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	var req dto.UserRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
 	}
 
-	if err := h.service.Create(&u); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	u := req.ToDomain()
+	if err := h.service.Create(u); err != nil {
+		return passwordPolicyError(err)
 	}
 
-	u.Sanitize()
-	return c.Status(fiber.StatusCreated).JSON(u)
+	return c.Status(fiber.StatusCreated).JSON(dto.NewUserResponse(u))
 }
 
+// Update replaces a user's profile. Setting a password signs the user out
+// everywhere, as when they change it themselves.
 func (h *UserHandler) Update(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var u domain.User
-	if err := c.BodyParser(&u); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	var req dto.UserRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
 	}
-	u.ID = id
+	u := req.ToDomain()
+	u.ID = c.Params("id")
 
-	if err := h.service.Update(&u); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	if err := h.service.Update(u); err != nil {
+		return passwordPolicyError(err)
+	}
+	if req.Password != "" {
+		if err := h.accounts.RevokeAllSessions(u.ID); err != nil {
+			return err
+		}
 	}
 
-	u.Sanitize()
-	return c.JSON(u)
+	return c.JSON(dto.NewUserResponse(u))
 }
 
-func (h *UserHandler) Delete(c *fiber.Ctx) error {
-	id := c.Params("id")
-	if err := h.service.Delete(id); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+// Patch updates a user with a JSON Merge Patch (application/merge-patch+json
+// or application/json); members left out are kept
+func (h *UserHandler) Patch(c *fiber.Ctx) error {
+	u, err := h.service.Patch(c.Params("id"), c.Body())
+	if err != nil {
+		return passwordPolicyError(err)
 	}
-	return c.SendStatus(fiber.StatusNoContent)
+	if setsPassword(c.Body()) {
+		if err := h.accounts.RevokeAllSessions(u.ID); err != nil {
+			return err
+		}
+	}
+	return c.JSON(dto.NewUserResponse(u))
 }
 
-func (h *UserHandler) GetAll(c *fiber.Ctx) error {
-	log.Println("Handling GET /api/users")
-	users, err := h.service.GetAll()
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+// setsPassword reports whether a merge patch gives the user a new password,
+// after which, as after any password change, they are signed out everywhere
+func setsPassword(patch []byte) bool {
+	var members struct {
+		Password *string `json:"password"`
 	}
-	// Return empty array instead of null if users is nil
-	if users == nil {
-		users = []domain.User{}
+	return json.Unmarshal(patch, &members) == nil && members.Password != nil && *members.Password != ""
+}
+
+// PatchMe lets the caller edit their own name, avatar, job title and
+// department with a JSON Merge Patch
+func (h *UserHandler) PatchMe(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	u, err := h.service.PatchProfile(userID, c.Body())
+	if err != nil {
+		return err
 	}
+	return c.JSON(dto.NewUserResponse(u))
+}
 
-	for i := range users {
-		users[i].Sanitize()
+func (h *UserHandler) Delete(c *fiber.Ctx) error {
+	if err := h.service.Delete(c.Params("id")); err != nil {
+		return err
 	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
 
-	return c.JSON(users)
+// GetAll lists users a page at a time, filtered, searched and sorted as
+// the query string asks
+func (h *UserHandler) GetAll(c *fiber.Ctx) error {
+	var q dto.UserListQuery
+	if err := dto.BindQuery(c, &q); err != nil {
+		return err
+	}
+	page, err := h.service.List(q.ToDomain())
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.UserPage{Users: dto.UsersFor(page.Users, viewer(c)), NextCursor: page.NextCursor})
 }
 
 func (h *UserHandler) GetByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	user, err := h.service.GetByID(id)
+	user, err := h.service.GetByID(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 	if user == nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+		return domain.ErrUserNotFound
 	}
-	user.Sanitize()
-	return c.JSON(user)
+	return c.JSON(dto.UserFor(user, viewer(c)))
 }
 
 func (h *UserHandler) GetByEmail(c *fiber.Ctx) error {
-	email := c.Params("email")
-	user, err := h.service.GetByEmail(email)
+	user, err := h.service.GetByEmail(c.Params("email"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 	if user == nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+		return domain.ErrUserNotFound
+	}
+	return c.JSON(dto.UserFor(user, viewer(c)))
+}
+
+// GetOrgChart returns the whole reporting tree
+func (h *UserHandler) GetOrgChart(c *fiber.Ctx) error {
+	chart, err := h.service.OrgChart()
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewOrgNodes(chart.Roots))
+}
+
+// GetOrgView returns a user's managers and the tree of their reports
+func (h *UserHandler) GetOrgView(c *fiber.Ctx) error {
+	chart, err := h.service.OrgChart()
+	if err != nil {
+		return err
+	}
+	node := chart.Node(c.Params("id"))
+	if node == nil {
+		return domain.ErrUserNotFound
+	}
+	return c.JSON(dto.NewOrgView(chart, node))
+}
+
+func (h *UserHandler) GetSessions(c *fiber.Ctx) error {
+	sessions, err := h.accounts.ListSessions(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewSessionResponses(sessions, ""))
+}
+
+func (h *UserHandler) RevokeSession(c *fiber.Ctx) error {
+	if err := h.accounts.RevokeSession(c.Params("id"), c.Params("sessionId")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *UserHandler) RevokeAllSessions(c *fiber.Ctx) error {
+	if err := h.accounts.RevokeAllSessions(c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *UserHandler) Unlock(c *fiber.Ctx) error {
+	if err := h.accounts.UnlockAccount(c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *UserHandler) ForcePasswordReset(c *fiber.Ctx) error {
+	if err := h.accounts.ForcePasswordReset(c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *UserHandler) ResetTwoFactor(c *fiber.Ctx) error {
+	if err := h.accounts.ResetTwoFactor(c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *UserHandler) GetAPITokens(c *fiber.Ctx) error {
+	tokens, err := h.accounts.ListAPITokens(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(dto.NewAPITokenResponses(tokens))
+}
+
+// CreateAPIToken issues a service API key acting as the user, typically a
+// service account. The secret is in the response only.
+func (h *UserHandler) CreateAPIToken(c *fiber.Ctx) error {
+	var req dto.APITokenRequest
+	if err := dto.Bind(c, &req); err != nil {
+		return err
+	}
+
+	adminID, _ := c.Locals("user_id").(string)
+	token, secret, err := h.accounts.CreateAPIToken(c.Params("id"), adminID, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(dto.CreatedAPIToken{Token: secret, APIToken: dto.NewAPITokenResponse(token)})
+}
+
+func (h *UserHandler) RevokeAPIToken(c *fiber.Ctx) error {
+	if err := h.accounts.RevokeAPIToken(c.Params("id"), c.Params("tokenId")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// viewer is the caller, for deciding how much of a user they may see
+func viewer(c *fiber.Ctx) dto.Viewer {
+	id, _ := c.Locals("user_id").(string)
+	return dto.Viewer{ID: id, IsAdmin: middleware.IsAdmin(c)}
+}
+
+// passwordPolicyError reports a password the policy rejected as field
+// errors; other errors pass through
+func passwordPolicyError(err error) error {
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return &domain.ValidationError{Fields: policyErr.Fields("password")}
 	}
-	return c.JSON(user)
+	return err
 }
@@ -0,0 +1,686 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/dto"
+	"github.com/stacklevest/backend/internal/middleware"
+	"github.com/stacklevest/backend/internal/password"
+	"github.com/stacklevest/backend/internal/storage"
+)
+
+// secretMembers are the JSON members of domain.User that must never appear
+// in a response
+var secretMembers = []string{`"password"`, `"passwordHistory"`, `"totpSecret"`, `"totpLastStep"`, `"recoveryCodes"`}
+
+// The hash is computed once; bcrypt is slow by design
+var (
+	testHashOnce sync.Once
+	testHash     string
+	testHashErr  error
+)
+
+// noAccounts stands in for auth.AuthService
+type noAccounts struct{}
+
+func (noAccounts) ListSessions(string) ([]domain.UserSession, error) { return nil, nil }
+func (noAccounts) RevokeSession(string, string) error                { return domain.ErrSessionNotFound }
+func (noAccounts) RevokeAllSessions(string) error                    { return nil }
+func (noAccounts) UnlockAccount(string) error                        { return nil }
+func (noAccounts) ForcePasswordReset(string) error                   { return nil }
+func (noAccounts) ResetTwoFactor(string) error                       { return nil }
+func (noAccounts) ListAPITokens(string) ([]domain.APIToken, error)   { return nil, nil }
+func (noAccounts) RevokeAPIToken(string, string) error               { return domain.ErrAPITokenNotFound }
+func (noAccounts) CreateAPIToken(string, string, string, []string, time.Duration) (*domain.APIToken, string, error) {
+	return &domain.APIToken{}, "", nil
+}
+
+// newTestApp serves the user routes from a fresh database holding an admin
+// and a staff member, both with a password hash and TOTP secrets. Requests
+// are made as the user with callerID.
+func newTestApp(t *testing.T, callerID string) (*fiber.App, []*domain.User) {
+	t.Helper()
+	return newTestAppWith(t, callerID, noAccounts{})
+}
+
+// newTestAppWith is newTestApp with accounts standing in for the auth service
+func newTestAppWith(t *testing.T, callerID string, accounts AccountManager) (*fiber.App, []*domain.User) {
+	t.Helper()
+	store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	testHashOnce.Do(func() { testHash, testHashErr = password.Hash("correct horse battery staple") })
+	if testHashErr != nil {
+		t.Fatal(testHashErr)
+	}
+	hash := testHash
+	users := []*domain.User{
+		{ID: "user-admin", Name: "Ada", Email: "ada@example.com", Role: domain.RoleAdmin},
+		// The websocket server stores presence in status once a user connects
+		{ID: "user-staff", Name: "Bob", Email: "bob@example.com", Role: domain.RoleStaff, Status: "online"},
+	}
+	for _, u := range users {
+		u.Password = hash
+		u.PasswordHistory = []string{hash}
+		u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = "JBSWY3DPEHPK3PXP", true, 1
+		u.RecoveryCodes = []string{"0123abcd"}
+		u.CreatedAt = time.Now()
+		if err := store.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	service := NewUserService(store, store, &password.Policy{}, &config.Config{StaffNumberPrefix: "T-", StaffNumberDigits: 3})
+	caller := users[0]
+	if callerID != caller.ID {
+		caller = users[1]
+	}
+	authMiddleware := func(c *fiber.Ctx) error {
+		c.Locals("user_id", caller.ID)
+		c.Locals("email", caller.Email)
+		c.Locals("role", caller.Role)
+		return c.Next()
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	app.Use(middleware.RequestID())
+	NewUserHandler(service, accounts).RegisterRoutes(app, authMiddleware)
+	return app, users
+}
+
+// send makes a request with a JSON body and returns the status and body
+func send(t *testing.T, app *fiber.App, method, path, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data)
+}
+
+// storedUser fetches the user with id as the app's caller sees them
+func storedUser(t *testing.T, app *fiber.App, id string) map[string]any {
+	t.Helper()
+	status, body := send(t, app, fiber.MethodGet, "/api/users/"+id, "")
+	if status != fiber.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", id, status, body)
+	}
+	var user map[string]any
+	if err := json.Unmarshal([]byte(body), &user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// TestEditKeepsPresenceStatus edits a user whose status holds a presence
+// value from the websocket server: edits leaving it alone go through and
+// keep it, while setting it through the API is still checked
+func TestEditKeepsPresenceStatus(t *testing.T) {
+	for _, tc := range []struct {
+		callerID, method, path, body string
+	}{
+		{"user-staff", fiber.MethodPatch, "/api/users/me", `{"name":"Bobby"}`},
+		{"user-admin", fiber.MethodPatch, "/api/users/user-staff", `{"name":"Bobby"}`},
+		{"user-admin", fiber.MethodPut, "/api/users/user-staff", `{"name":"Bobby","email":"bob@example.com","role":"staff","status":"online"}`},
+	} {
+		app, _ := newTestApp(t, tc.callerID)
+		if status, body := send(t, app, tc.method, tc.path, tc.body); status != fiber.StatusOK {
+			t.Fatalf("%s %s as %s: status %d: %s", tc.method, tc.path, tc.callerID, status, body)
+		}
+		if user := storedUser(t, app, "user-staff"); user["name"] != "Bobby" || user["status"] != "online" {
+			t.Errorf("%s %s as %s: stored name %v, status %v; want Bobby, online", tc.method, tc.path, tc.callerID, user["name"], user["status"])
+		}
+	}
+
+	app, _ := newTestApp(t, "user-admin")
+	for _, body := range []string{`{"status":"offline"}`, `{"status":"ASLEEP"}`} {
+		status, resp := send(t, app, fiber.MethodPatch, "/api/users/user-staff", body)
+		if status != fiber.StatusBadRequest || !strings.Contains(resp, `"field":"status","code":"not_allowed"`) {
+			t.Errorf("PATCH %s: status %d: %s", body, status, resp)
+		}
+	}
+	if status, resp := send(t, app, fiber.MethodPatch, "/api/users/user-staff", `{"status":"inactive"}`); status != fiber.StatusOK ||
+		storedUser(t, app, "user-staff")["status"] != domain.StatusInactive {
+		t.Errorf("PATCH status inactive: status %d: %s", status, resp)
+	}
+}
+
+// revokingAccounts records whose sessions were revoked
+type revokingAccounts struct {
+	noAccounts
+	revoked []string
+}
+
+func (a *revokingAccounts) RevokeAllSessions(userID string) error {
+	a.revoked = append(a.revoked, userID)
+	return nil
+}
+
+// TestPatchChangesOnlyWhatItNames patches one member at a time and checks
+// every other stored member is unchanged, and that a patch carrying a
+// password also signs the user out
+func TestPatchChangesOnlyWhatItNames(t *testing.T) {
+	accounts := &revokingAccounts{}
+	app, _ := newTestAppWith(t, "user-admin", accounts)
+	if status, body := send(t, app, fiber.MethodPut, "/api/users/user-staff",
+		`{"name":"Bob","email":"bob@example.com","role":"staff","department":"Ops","jobTitle":"Engineer","reportingManager":"user-admin","avatar":"https://example.com/b.png"}`); status != fiber.StatusOK {
+		t.Fatalf("PUT: status %d: %s", status, body)
+	}
+
+	for _, patch := range []string{
+		`{"department":"Sales"}`,
+		`{"jobTitle":null}`,
+		`{"reportingManager":""}`,
+		`{"name":"Robert","password":"a brand new passphrase"}`,
+	} {
+		before := storedUser(t, app, "user-staff")
+		accounts.revoked = nil
+		if status, body := send(t, app, fiber.MethodPatch, "/api/users/user-staff", patch); status != fiber.StatusOK {
+			t.Fatalf("PATCH %s: status %d: %s", patch, status, body)
+		}
+		after := storedUser(t, app, "user-staff")
+
+		var named map[string]any
+		if err := json.Unmarshal([]byte(patch), &named); err != nil {
+			t.Fatal(err)
+		}
+		for member, was := range before {
+			if _, ok := named[member]; ok {
+				continue
+			}
+			if fmt.Sprint(after[member]) != fmt.Sprint(was) {
+				t.Errorf("PATCH %s changed %s from %v to %v", patch, member, was, after[member])
+			}
+		}
+		_, setsPassword := named["password"]
+		if revoked := len(accounts.revoked) == 1 && accounts.revoked[0] == "user-staff"; revoked != setsPassword {
+			t.Errorf("PATCH %s: revoked %v, want revoked %v", patch, accounts.revoked, setsPassword)
+		}
+	}
+}
+
+// TestSelfPatchRefusesProtectedFields checks that users cannot change their
+// own role, email, status or staff number, not even alongside a field they
+// may edit, and that a refused patch leaves the stored user as it was
+func TestSelfPatchRefusesProtectedFields(t *testing.T) {
+	app, _ := newTestApp(t, "user-staff")
+	before := storedUser(t, app, "user-staff")
+
+	for _, patch := range []string{
+		`{"role":"admin"}`,
+		`{"email":"boss@example.com"}`,
+		`{"status":"ACTIVE"}`,
+		`{"staffNumber":"T-999"}`,
+		`{"name":"Bobby","role":"admin"}`,
+		`{"password":"a brand new passphrase"}`,
+	} {
+		status, body := send(t, app, fiber.MethodPatch, "/api/users/me", patch)
+		if status != fiber.StatusForbidden || !strings.Contains(body, `"code":"field_not_editable"`) {
+			t.Errorf("PATCH /me %s: status %d: %s", patch, status, body)
+		}
+	}
+
+	after := storedUser(t, app, "user-staff")
+	for member, was := range before {
+		if fmt.Sprint(after[member]) != fmt.Sprint(was) {
+			t.Errorf("a refused patch changed %s from %v to %v", member, was, after[member])
+		}
+	}
+}
+
+// TestAdminPasswordChangeSignsUserOut checks that a password set by an admin
+// through PUT or PATCH revokes the user's sessions, and that other edits
+// leave them alone
+func TestAdminPasswordChangeSignsUserOut(t *testing.T) {
+	accounts := &revokingAccounts{}
+	app, _ := newTestAppWith(t, "user-admin", accounts)
+
+	for _, tc := range []struct {
+		method, body string
+		revokes      bool
+	}{
+		{fiber.MethodPut, `{"name":"Bo","email":"bob@example.com","role":"staff"}`, false},
+		{fiber.MethodPatch, `{"name":"Bob"}`, false},
+		{fiber.MethodPut, `{"name":"Bo","email":"bob@example.com","role":"staff","password":"a brand new passphrase"}`, true},
+		{fiber.MethodPatch, `{"password":"yet another passphrase"}`, true},
+	} {
+		accounts.revoked = nil
+		req := httptest.NewRequest(tc.method, "/api/users/user-staff", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusOK {
+			body, _ := io.ReadAll(res.Body)
+			t.Fatalf("%s %s: status %d: %s", tc.method, tc.body, res.StatusCode, body)
+		}
+		if revoked := len(accounts.revoked) == 1 && accounts.revoked[0] == "user-staff"; revoked != tc.revokes {
+			t.Errorf("%s %s: revoked %v, want revoked %v", tc.method, tc.body, accounts.revoked, tc.revokes)
+		}
+	}
+}
+
+// populatedAccounts returns sessions and API tokens with their selectors
+// and hashes filled in, which the handlers must leave out
+type populatedAccounts struct {
+	noAccounts
+}
+
+// accountSecrets are the selectors and hashes populatedAccounts hands out
+var accountSecrets = []string{"session-selector", "session-hash", "token-selector", "token-hash"}
+
+func (populatedAccounts) ListSessions(userID string) ([]domain.UserSession, error) {
+	return []domain.UserSession{{ID: "s1", UserID: userID, Selector: "session-selector", RefreshToken: "session-hash",
+		FamilyID: "f1", ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}}, nil
+}
+
+func (a populatedAccounts) ListAPITokens(userID string) ([]domain.APIToken, error) {
+	token, _, err := a.CreateAPIToken(userID, userID, "CI", []string{"users:read"}, time.Hour)
+	return []domain.APIToken{*token}, err
+}
+
+func (populatedAccounts) CreateAPIToken(userID, _, name string, scopes []string, ttl time.Duration) (*domain.APIToken, string, error) {
+	return &domain.APIToken{ID: "t1", UserID: userID, Name: name, Scopes: scopes, Selector: "token-selector",
+		TokenHash: "token-hash", ExpiresAt: time.Now().Add(ttl), CreatedAt: time.Now()}, "slv_raw-secret", nil
+}
+
+// TestResponsesNeverIncludeSecrets calls every user route, as an admin and
+// as staff, and fails if a response carries a password hash, TOTP secret,
+// or session or API token hash
+func TestResponsesNeverIncludeSecrets(t *testing.T) {
+	bodies := map[string]string{
+		fiber.MethodPost:  `{"name":"Cy","email":"cy@example.com","role":"staff","password":"another long passphrase","scopes":["users:read"]}`,
+		fiber.MethodPut:   `{"name":"Bo","email":"bob@example.com","role":"staff"}`,
+		fiber.MethodPatch: `{"name":"Bo"}`,
+	}
+
+	for _, callerID := range []string{"user-admin", "user-staff"} {
+		routes, _ := newTestAppWith(t, callerID, populatedAccounts{})
+		for _, route := range routes.GetRoutes(true) {
+			if route.Method == fiber.MethodHead ||
+				!strings.HasPrefix(route.Path, "/api/users") && !strings.HasPrefix(route.Path, "/api/org") {
+				continue
+			}
+			// A fresh app per call, so a DELETE cannot hide the user from
+			// the calls after it
+			app, users := newTestAppWith(t, callerID, populatedAccounts{})
+			target := users[1]
+			path := strings.NewReplacer(":email", target.Email, ":id", target.ID,
+				":sessionId", "s1", ":tokenId", "t1").Replace(route.Path)
+
+			req := httptest.NewRequest(route.Method, path, strings.NewReader(bodies[route.Method]))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			secrets := append([]string{target.Password, "$2a$", `"selector"`, `"tokenHash"`, `"refreshToken"`}, accountSecrets...)
+			for _, member := range append(secretMembers, secrets...) {
+				if strings.Contains(string(body), member) {
+					t.Errorf("%s %s as %s: response contains %s: %s", route.Method, path, callerID, member, body)
+				}
+			}
+		}
+	}
+}
+
+// TestStaffSeeDirectoryFields checks that staff get only directory fields
+// for a colleague, while admins and the user themselves get the full profile
+func TestStaffSeeDirectoryFields(t *testing.T) {
+	cases := []struct {
+		callerID, targetID string
+		full               bool
+	}{
+		{"user-staff", "user-admin", false},
+		{"user-staff", "user-staff", true},
+		{"user-admin", "user-staff", true},
+	}
+	for _, tc := range cases {
+		app, _ := newTestApp(t, tc.callerID)
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/users/"+tc.targetID, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("%s viewing %s: status %d: %s", tc.callerID, tc.targetID, resp.StatusCode, body)
+		}
+		if full := strings.Contains(string(body), `"totpEnabled"`); full != tc.full {
+			t.Errorf("%s viewing %s: full profile = %v, want %v: %s", tc.callerID, tc.targetID, full, tc.full, body)
+		}
+	}
+}
+
+// TestCreateReportsFieldErrors checks that every bad input of a new user is
+// reported at once, by field and code
+func TestCreateReportsFieldErrors(t *testing.T) {
+	app, _ := newTestApp(t, "user-admin")
+	body := `{"name":"","email":"not-an-email","role":"boss","status":"ASLEEP","reportingManager":"user-nobody"}`
+	req := httptest.NewRequest(fiber.MethodPost, "/api/users", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("status %d, want 400", resp.StatusCode)
+	}
+
+	var got struct {
+		Fields []domain.FieldError `json:"fields"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []domain.FieldError{
+		{Field: "name", Code: domain.CodeRequired},
+		{Field: "email", Code: domain.CodeInvalid},
+		{Field: "role", Code: domain.CodeNotAllowed},
+		{Field: "status", Code: domain.CodeNotAllowed},
+		{Field: "reportingManager", Code: domain.CodeNotFound},
+	}
+	if len(got.Fields) != len(want) {
+		t.Fatalf("got %d field errors, want %d: %+v", len(got.Fields), len(want), got.Fields)
+	}
+	for i, w := range want {
+		if got.Fields[i].Field != w.Field || got.Fields[i].Code != w.Code || got.Fields[i].Message == "" {
+			t.Errorf("field error %d = %+v, want %s/%s with a message", i, got.Fields[i], w.Field, w.Code)
+		}
+	}
+}
+
+// TestWrongJSONTypeIsAFieldError checks that a member of the wrong type is
+// reported against that member rather than as an unparseable body
+func TestWrongJSONTypeIsAFieldError(t *testing.T) {
+	app, _ := newTestApp(t, "user-admin")
+	req := httptest.NewRequest(fiber.MethodPost, "/api/users", strings.NewReader(`{"name":"Cy","role":7}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusBadRequest || !strings.Contains(string(body), `"field":"role","code":"invalid_type"`) {
+		t.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+}
+
+// TestErrorEnvelope checks that errors are answered with their status, a
+// stable code and the request ID, and that internal errors keep their text
+// to the server log
+func TestErrorEnvelope(t *testing.T) {
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{fiber.MethodPut, "/api/users/user-missing", `{"name":"Cy","email":"cy@example.com","role":"staff"}`, fiber.StatusNotFound, "user_not_found"},
+		{fiber.MethodDelete, "/api/users/user-missing", "", fiber.StatusNotFound, "user_not_found"},
+		{fiber.MethodGet, "/api/users/user-missing", "", fiber.StatusNotFound, "user_not_found"},
+		{fiber.MethodPost, "/api/users", `{"name":"Bo","email":"bob@example.com","role":"staff"}`, fiber.StatusConflict, "email_taken"},
+		{fiber.MethodPost, "/api/users", `{`, fiber.StatusBadRequest, "invalid_fields"},
+		{fiber.MethodDelete, "/api/users/user-staff/sessions/missing", "", fiber.StatusNotFound, "session_not_found"},
+	}
+	for _, tc := range cases {
+		app, _ := newTestApp(t, "user-admin")
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderXRequestID, "req-123")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Error, Code, RequestID string
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.status || got.Code != tc.code || got.Error == "" || got.RequestID != "req-123" {
+			t.Errorf("%s %s: status %d, body %+v; want %d with code %s", tc.method, tc.path, resp.StatusCode, got, tc.status, tc.code)
+		}
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	app.Get("/", func(c *fiber.Ctx) error { return errors.New("sql: database is locked") })
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusInternalServerError || strings.Contains(string(body), "database") ||
+		!strings.Contains(string(body), `"code":"internal_error"`) {
+		t.Errorf("internal error: status %d: %s", resp.StatusCode, body)
+	}
+}
+
+// TestListPages walks the directory a page at a time in every sort order,
+// against both stores, and checks each user comes once and in order
+func TestListPages(t *testing.T) {
+	sqlite, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	stores := map[string]domain.UserRepository{
+		"sqlite": sqlite,
+		"json":   storage.NewJSONStore(filepath.Join(t.TempDir(), "db.json")),
+	}
+
+	// Equal names, departments and creation times make the ID tie-break count
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	seed := []domain.User{
+		{ID: "u1", Name: "carol", Email: "carol@example.com", Role: domain.RoleStaff, Department: "Sales", CreatedAt: base},
+		{ID: "u2", Name: "Alice", Email: "alice@example.com", Role: domain.RoleAdmin, Department: "ops", CreatedAt: base},
+		{ID: "u3", Name: "bob", Email: "bob@example.com", Role: domain.RoleStaff, Department: "Ops", JobTitle: "Sales lead", CreatedAt: base.Add(time.Second)},
+		{ID: "u4", Name: "Bob", Email: "bob2@example.com", Role: domain.RoleManager, Department: "Sales", CreatedAt: base.Add(time.Millisecond)},
+		{ID: "u5", Name: "dave", Email: "dave@example.com", Role: domain.RoleStaff, ReportingManager: "u4", CreatedAt: base.Add(time.Hour)},
+	}
+	cases := []struct {
+		query domain.UserQuery
+		want  []string
+	}{
+		{domain.UserQuery{}, []string{"u1", "u2", "u4", "u3", "u5"}},
+		{domain.UserQuery{Sort: "-createdAt"}, []string{"u5", "u3", "u4", "u2", "u1"}},
+		{domain.UserQuery{Sort: "name"}, []string{"u2", "u3", "u4", "u1", "u5"}},
+		{domain.UserQuery{Sort: "-name"}, []string{"u5", "u1", "u4", "u3", "u2"}},
+		{domain.UserQuery{Sort: "department"}, []string{"u5", "u2", "u3", "u1", "u4"}},
+		{domain.UserQuery{Role: "STAFF", Sort: "email"}, []string{"u3", "u1", "u5"}},
+		{domain.UserQuery{Department: "ops"}, []string{"u2", "u3"}},
+		{domain.UserQuery{ReportingManager: "u4"}, []string{"u5"}},
+		{domain.UserQuery{Search: "SALES"}, []string{"u3"}},
+		{domain.UserQuery{Search: "bob2@"}, []string{"u4"}},
+		{domain.UserQuery{Search: "%"}, []string{}},
+	}
+
+	for name, store := range stores {
+		for i := range seed {
+			u := seed[i]
+			if err := store.Create(&u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tc := range cases {
+			q := tc.query
+			q.Limit = 2
+			got := []string{}
+			for pages := 0; ; pages++ {
+				if pages > len(seed) {
+					t.Fatalf("%s %+v: the cursor does not advance", name, tc.query)
+				}
+				page, err := store.FindUsers(q)
+				if err != nil {
+					t.Fatalf("%s %+v: %v", name, tc.query, err)
+				}
+				for _, u := range page.Users {
+					got = append(got, u.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				q.After = page.NextCursor
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("%s %+v: got %v, want %v", name, tc.query, got, tc.want)
+			}
+		}
+
+		if _, err := store.FindUsers(domain.UserQuery{Sort: "name", After: domain.UserCursor{Sort: "email", ID: "u1"}.Encode()}); !errors.Is(err, domain.ErrInvalidCursor) {
+			t.Errorf("%s: cursor of another sort: got %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+// TestListRejectsBadQueries checks that the list parameters are reported
+// as field errors
+func TestListRejectsBadQueries(t *testing.T) {
+	app, _ := newTestApp(t, "user-admin")
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/users?limit=lots&sort=age", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusBadRequest || !strings.Contains(string(body), `"field":"limit","code":"invalid_type"`) {
+		t.Errorf("limit=lots: status %d: %s", resp.StatusCode, body)
+	}
+
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/api/users?sort=age&role=boss", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusBadRequest || !strings.Contains(string(body), `"field":"sort"`) ||
+		!strings.Contains(string(body), `"field":"role"`) {
+		t.Errorf("sort=age&role=boss: status %d: %s", resp.StatusCode, body)
+	}
+
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/api/users?limit=1&sort=-name", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page struct {
+		Users      []map[string]interface{} `json:"users"`
+		NextCursor string                   `json:"nextCursor"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Users) != 1 || page.Users[0]["name"] != "Bob" || page.NextCursor == "" {
+		t.Errorf("limit=1&sort=-name: got %+v", page)
+	}
+}
+
+// TestOrgChart builds a reporting line through the API and checks the
+// chart, a user's view of it, the refusal of loops and what deleting a
+// manager does to their reports
+func TestOrgChart(t *testing.T) {
+	app, _ := newTestApp(t, "user-admin")
+	call := func(method, path, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	// Ada manages Bob, who manages Cy
+	if status, body := call(fiber.MethodPatch, "/api/users/user-staff", `{"reportingManager":"user-admin"}`); status != fiber.StatusOK {
+		t.Fatalf("Bob reports to Ada: status %d: %s", status, body)
+	}
+	status, body := call(fiber.MethodPost, "/api/users", `{"name":"Cy","email":"cy@example.com","role":"staff","reportingManager":"user-staff"}`)
+	if status != fiber.StatusCreated {
+		t.Fatalf("create Cy: status %d: %s", status, body)
+	}
+	var cy struct{ ID string }
+	json.Unmarshal([]byte(body), &cy)
+
+	var chart []dto.OrgNode
+	_, body = call(fiber.MethodGet, "/api/org", "")
+	if err := json.Unmarshal([]byte(body), &chart); err != nil {
+		t.Fatal(err)
+	}
+	if len(chart) != 1 || chart[0].ID != "user-admin" || len(chart[0].Reports) != 1 ||
+		chart[0].Reports[0].ID != "user-staff" || len(chart[0].Reports[0].Reports) != 1 ||
+		chart[0].Reports[0].Reports[0].ID != cy.ID {
+		t.Errorf("chart: %s", body)
+	}
+
+	var view dto.OrgView
+	_, body = call(fiber.MethodGet, "/api/org/"+cy.ID, "")
+	if err := json.Unmarshal([]byte(body), &view); err != nil {
+		t.Fatal(err)
+	}
+	if view.User.ID != cy.ID || len(view.Managers) != 2 || view.Managers[0].ID != "user-staff" ||
+		view.Managers[1].ID != "user-admin" || len(view.Reports) != 0 {
+		t.Errorf("Cy's view: %s", body)
+	}
+	if status, _ := call(fiber.MethodGet, "/api/org/user-missing", ""); status != fiber.StatusNotFound {
+		t.Errorf("missing user's view: status %d, want 404", status)
+	}
+
+	for _, managerID := range []string{"user-admin", cy.ID} {
+		status, body := call(fiber.MethodPatch, "/api/users/user-admin", `{"reportingManager":"`+managerID+`"}`)
+		if status != fiber.StatusBadRequest || !strings.Contains(body, `"field":"reportingManager","code":"cycle"`) {
+			t.Errorf("Ada reports to %s: status %d: %s", managerID, status, body)
+		}
+	}
+
+	// Bob leaves; Cy moves up to Ada
+	if status, body := call(fiber.MethodDelete, "/api/users/user-staff", ""); status != fiber.StatusNoContent {
+		t.Fatalf("delete Bob: status %d: %s", status, body)
+	}
+	_, body = call(fiber.MethodGet, "/api/org/"+cy.ID, "")
+	view = dto.OrgView{}
+	json.Unmarshal([]byte(body), &view)
+	if len(view.Managers) != 1 || view.Managers[0].ID != "user-admin" {
+		t.Errorf("Cy's view after Bob left: %s", body)
+	}
+}
+
+// TestOrgChartCutsLoops checks that records which already loop still make
+// a tree
+func TestOrgChartCutsLoops(t *testing.T) {
+	chart := domain.NewOrgChart([]domain.User{
+		{ID: "a", Name: "Ann", ReportingManager: "b"},
+		{ID: "b", Name: "Ben", ReportingManager: "a"},
+		{ID: "c", Name: "Col", ReportingManager: "b"},
+		{ID: "d", Name: "Dee", ReportingManager: "d"},
+	})
+	if len(chart.Roots) != 2 || chart.Roots[0].User.ID != "a" || chart.Roots[1].User.ID != "d" {
+		t.Fatalf("roots: %+v", chart.Roots)
+	}
+	if managers := chart.Managers("c"); len(managers) != 2 || managers[0].ID != "b" || managers[1].ID != "a" {
+		t.Errorf("Col's managers: %+v", managers)
+	}
+}
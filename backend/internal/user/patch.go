@@ -0,0 +1,23 @@
+package user
+
+// mergePatch applies a JSON Merge Patch (RFC 7386) to target, both decoded
+// with encoding/json: members of patch replace those of target, null
+// removes one, and objects are merged recursively
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+			continue
+		}
+		targetObj[name] = mergePatch(targetObj[name], value)
+	}
+	return targetObj
+}
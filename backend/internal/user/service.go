@@ -1,17 +1,48 @@
 package user
 
-import "github.com/stacklevest/backend/internal/domain"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/password"
+)
+
+// selfServiceFields are the JSON members users may change on their own
+// profile with PatchProfile
+var selfServiceFields = []string{"name", "avatar", "jobTitle", "department"}
+
+var (
+	ErrInvalidPatch     = domain.Invalid("invalid_patch", "the body must be a JSON merge patch object")
+	ErrFieldNotEditable = domain.Forbidden("field_not_editable", "fields cannot be changed here")
+)
 
 type UserService struct {
-	repo domain.UserRepository
+	repo      domain.UserRepository
+	sequences domain.SequenceRepository
+	policy    *password.Policy
+	config    *config.Config
+
+	// Serialises the email and reporting line checks with the writes that
+	// depend on them
+	writes sync.Mutex
 }
 
-func NewUserService(repo domain.UserRepository) *UserService {
-	return &UserService{repo: repo}
+func NewUserService(repo domain.UserRepository, sequences domain.SequenceRepository, policy *password.Policy,
+	cfg *config.Config) *UserService {
+	return &UserService{repo: repo, sequences: sequences, policy: policy, config: cfg}
 }
 
-func (s *UserService) GetAll() ([]domain.User, error) {
-	return s.repo.FindAll()
+// List returns a page of the directory matching q
+func (s *UserService) List(q domain.UserQuery) (*domain.UserPage, error) {
+	return s.repo.FindUsers(q)
 }
 
 func (s *UserService) GetByID(id string) (*domain.User, error) {
@@ -22,14 +53,298 @@ func (s *UserService) GetByEmail(email string) (*domain.User, error) {
 	return s.repo.FindByEmail(email)
 }
 
+// Create provisions a new user from the profile in user. The server picks
+// the ID, creation time and staff number; the email must be unused, ignoring
+// case. Invalid fields and an unknown reporting manager are reported
+// together as a *domain.ValidationError. A password, if given, is hashed
+// and must pass the policy (a *password.PolicyError otherwise).
 func (s *UserService) Create(user *domain.User) error {
+	user.OIDCSubject = ""
+	return s.create(user)
+}
+
+// Provision is Create for accounts another flow sets up, an invitation or
+// a single sign-on: they have no password, and keep the identity provider
+// subject the flow vouched for
+func (s *UserService) Provision(user *domain.User) error {
+	user.Password = ""
+	return s.create(user)
+}
+
+func (s *UserService) create(user *domain.User) error {
+	if err := s.validate(user, nil); err != nil {
+		return err
+	}
+
+	user.PasswordHistory = nil
+	user.FailedLoginAttempts, user.LockedUntil = 0, nil
+	user.MustResetPassword, user.TokensInvalidBefore = false, nil
+	user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, user.RecoveryCodes = "", false, 0, nil
+	if pw := user.Password; pw != "" {
+		user.Password = ""
+		if err := s.policy.Set(user, pw); err != nil {
+			return err
+		}
+	}
+
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	if err := s.checkEmailFree(user.Email, ""); err != nil {
+		return err
+	}
+	staffNumber, err := s.nextStaffNumber()
+	if err != nil {
+		return err
+	}
+	user.ID = domain.GenerateID("user")
+	user.StaffNumber = staffNumber
+	user.CreatedAt = time.Now()
 	return s.repo.Create(user)
 }
 
+// validate checks user's profile with domain.User.Validate, its status,
+// and that its reporting manager exists and is not one of the user's
+// reports. The status and manager are left alone when they are stored's,
+// nil for a new user, which may predate the checks. The email, role and a
+// changed status are normalised on success.
+func (s *UserService) validate(user, stored *domain.User) error {
+	var v domain.Validator
+	if err := v.Merge(user.Validate()); err != nil {
+		return err
+	}
+	statusChanged := stored == nil || user.Status != stored.Status
+	if statusChanged {
+		domain.CheckStatus(&v, user.Status)
+	}
+	if stored == nil || user.ReportingManager != stored.ReportingManager {
+		if err := domain.CheckReportingManager(&v, s.repo, user.ID, user.ReportingManager); err != nil {
+			return err
+		}
+	}
+	if err := v.Err(); err != nil {
+		return err
+	}
+
+	user.Email = strings.TrimSpace(user.Email)
+	user.Role, _ = domain.NormalizeRole(user.Role)
+	if statusChanged {
+		user.Status = strings.ToUpper(strings.TrimSpace(user.Status))
+	}
+	return nil
+}
+
+// nextStaffNumber formats the next value of the staff number sequence, e.g.
+// "SLV-0042". The sequence is first raised past the highest staff number in
+// use, since a db.json from before it was stored, or rewritten by the
+// websocket server, has none; a number still taken is refused with
+// domain.ErrStaffNumberTaken. Caller must hold s.writes.
+func (s *UserService) nextStaffNumber() (string, error) {
+	users, err := s.repo.FindAll()
+	if err != nil {
+		return "", err
+	}
+	floor := max(domain.HighestStaffNumber(users), int64(s.config.StaffNumberStart)-1)
+	if err := s.sequences.SeedSequence(domain.StaffNumberSequence, floor); err != nil {
+		return "", err
+	}
+	n, err := s.sequences.NextSequenceValue(domain.StaffNumberSequence, int64(s.config.StaffNumberStart))
+	if err != nil {
+		return "", err
+	}
+	number := fmt.Sprintf("%s%0*d", s.config.StaffNumberPrefix, s.config.StaffNumberDigits, n)
+	for _, u := range users {
+		if strings.EqualFold(u.StaffNumber, number) {
+			return "", domain.ErrStaffNumberTaken
+		}
+	}
+	return number, nil
+}
+
+// checkEmailFree returns domain.ErrEmailTaken if a user other than exceptID
+// has email, ignoring case
+func (s *UserService) checkEmailFree(email, exceptID string) error {
+	existing, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != exceptID {
+		return domain.ErrEmailTaken
+	}
+	return nil
+}
+
+// Update replaces the user's profile. Login-throttling, forced-reset,
+// token cut-off, 2FA and single sign-on state is kept from the stored
+// record, as is the creation time, and a role change invalidates the access
+// tokens issued under the old role. The profile is checked as in Create.
+// An empty password keeps the current one; a new one must pass the policy.
 func (s *UserService) Update(user *domain.User) error {
+	existing, err := s.repo.FindByID(user.ID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return domain.ErrUserNotFound
+	}
+
+	// Held from the manager check, so two concurrent changes cannot close a
+	// loop that neither would alone
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	if err := s.validate(user, existing); err != nil {
+		return err
+	}
+	if err := s.checkEmailFree(user.Email, user.ID); err != nil {
+		return err
+	}
+
+	user.CreatedAt = existing.CreatedAt
+
+	user.FailedLoginAttempts = existing.FailedLoginAttempts
+	user.LockedUntil = existing.LockedUntil
+	user.MustResetPassword = existing.MustResetPassword
+	user.TokensInvalidBefore = existing.TokensInvalidBefore
+	user.TOTPSecret = existing.TOTPSecret
+	user.TOTPEnabled = existing.TOTPEnabled
+	user.TOTPLastStep = existing.TOTPLastStep
+	user.RecoveryCodes = existing.RecoveryCodes
+	user.OIDCSubject = existing.OIDCSubject
+	pw := user.Password
+	user.Password = existing.Password
+	user.PasswordHistory = existing.PasswordHistory
+	if pw != "" {
+		if err := s.policy.Set(user, pw); err != nil {
+			return err
+		}
+	}
+	if !strings.EqualFold(existing.Role, user.Role) {
+		now := time.Now()
+		user.TokensInvalidBefore = &now
+	}
+
 	return s.repo.Update(user)
 }
 
+// Patch applies a JSON Merge Patch to the user's profile and saves it with
+// Update, so members the patch leaves out keep their stored values. A
+// "password" member sets a new password; the ID cannot be changed.
+func (s *UserService) Patch(id string, patch []byte) (*domain.User, error) {
+	return s.applyPatch(id, patch, nil)
+}
+
+// PatchProfile is Patch for users editing their own profile; a patch
+// touching anything but selfServiceFields is refused with
+// ErrFieldNotEditable
+func (s *UserService) PatchProfile(id string, patch []byte) (*domain.User, error) {
+	return s.applyPatch(id, patch, selfServiceFields)
+}
+
+func (s *UserService) applyPatch(id string, patch []byte, allowed []string) (*domain.User, error) {
+	var members map[string]interface{}
+	if err := json.Unmarshal(patch, &members); err != nil || members == nil {
+		return nil, ErrInvalidPatch
+	}
+	if allowed != nil {
+		var refused []string
+		for name := range members {
+			if !slices.Contains(allowed, name) {
+				refused = append(refused, name)
+			}
+		}
+		if len(refused) > 0 {
+			sort.Strings(refused)
+			return nil, &domain.Error{
+				Kind:    ErrFieldNotEditable.Kind,
+				Code:    ErrFieldNotEditable.Code,
+				Message: ErrFieldNotEditable.Message + ": " + strings.Join(refused, ", "),
+			}
+		}
+	}
+
+	existing, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, domain.ErrUserNotFound
+	}
+	// The stored hash must not read as a new password; Update keeps it and
+	// the other secrets unless the patch sets a password
+	existing.Sanitize()
+
+	doc, err := json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+	var current interface{}
+	if err := json.Unmarshal(doc, &current); err != nil {
+		return nil, err
+	}
+	if doc, err = json.Marshal(mergePatch(current, members)); err != nil {
+		return nil, err
+	}
+	var user domain.User
+	if err := json.Unmarshal(doc, &user); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			var v domain.Validator
+			v.Add(typeErr.Field, domain.CodeInvalidType, typeErr.Field+" has the wrong type")
+			return nil, v.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	user.ID = id
+
+	if err := s.Update(&user); err != nil {
+		return nil, err
+	}
+	user.Sanitize()
+	return &user, nil
+}
+
+// Delete removes the user. Their direct reports move up to report to the
+// user's own manager, or to nobody.
 func (s *UserService) Delete(id string) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	user, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return domain.ErrUserNotFound
+	}
+
+	var reports []domain.User
+	q := domain.UserQuery{ReportingManager: id, Limit: domain.MaxPageSize}
+	for {
+		page, err := s.repo.FindUsers(q)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, page.Users...)
+		if page.NextCursor == "" {
+			break
+		}
+		q.After = page.NextCursor
+	}
+	for i := range reports {
+		reports[i].ReportingManager = user.ReportingManager
+		if err := s.repo.Update(&reports[i]); err != nil {
+			return err
+		}
+	}
 	return s.repo.Delete(id)
 }
+
+// OrgChart returns the reporting tree of every user
+func (s *UserService) OrgChart() (*domain.OrgChart, error) {
+	users, err := s.repo.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	return domain.NewOrgChart(users), nil
+}
@@ -0,0 +1,83 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stacklevest/backend/internal/config"
+	"github.com/stacklevest/backend/internal/domain"
+	"github.com/stacklevest/backend/internal/password"
+	"github.com/stacklevest/backend/internal/storage"
+)
+
+var staffNumberConfig = &config.Config{StaffNumberPrefix: "SLV-", StaffNumberStart: 1, StaffNumberDigits: 4}
+
+// TestStaffNumbersContinueFromData creates users on a db.json holding staff
+// numbers but no sequence, as before sequences were stored and after the
+// websocket server rewrites the file without them
+func TestStaffNumbersContinueFromData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	if err := os.WriteFile(path, []byte(`{"users":[{"id":"u1","name":"Ada","email":"ada@example.com","staffNumber":"SLV-0041"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(name string) string {
+		t.Helper()
+		store := storage.NewJSONStore(path)
+		defer store.Close()
+		u := &domain.User{Name: name, Email: name + "@example.com", Role: domain.RoleStaff}
+		if err := NewUserService(store, store, &password.Policy{}, staffNumberConfig).Create(u); err != nil {
+			t.Fatal(err)
+		}
+		return u.StaffNumber
+	}
+	if got := create("bob"); got != "SLV-0042" {
+		t.Errorf("first staff number on existing data = %s, want SLV-0042", got)
+	}
+
+	// What the websocket server's saveState writes back
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var db map[string]json.RawMessage
+	if err := json.Unmarshal(data, &db); err != nil {
+		t.Fatal(err)
+	}
+	delete(db, "sequences")
+	if data, err = json.Marshal(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := create("cy"); got != "SLV-0043" {
+		t.Errorf("staff number after the sequence was dropped = %s, want SLV-0043", got)
+	}
+}
+
+// stuckSequence hands out the same value whatever it is seeded with
+type stuckSequence struct{ value int64 }
+
+func (s stuckSequence) NextSequenceValue(string, int64) (int64, error) { return s.value, nil }
+func (stuckSequence) SeedSequence(string, int64) error                 { return nil }
+
+func TestCreateRefusesATakenStaffNumber(t *testing.T) {
+	store := storage.NewJSONStore(filepath.Join(t.TempDir(), "db.json"))
+	defer store.Close()
+	if err := store.Create(&domain.User{ID: "u1", Name: "Ada", Email: "ada@example.com", StaffNumber: "SLV-0007"}); err != nil {
+		t.Fatal(err)
+	}
+
+	service := NewUserService(store, stuckSequence{value: 7}, &password.Policy{}, staffNumberConfig)
+	err := service.Create(&domain.User{Name: "Bob", Email: "bob@example.com", Role: domain.RoleStaff})
+	if !errors.Is(err, domain.ErrStaffNumberTaken) {
+		t.Fatalf("Create with a taken staff number: got %v, want %v", err, domain.ErrStaffNumberTaken)
+	}
+	if users, _ := store.FindAll(); len(users) != 1 {
+		t.Errorf("store holds %d users, want the refused one left out", len(users))
+	}
+}
@@ -0,0 +1,205 @@
+"use client";
+
+import { Suspense, useEffect, useState } from "react";
+import Link from "next/link";
+import { useRouter, useSearchParams } from "next/navigation";
+import { Briefcase, CheckCircle, Lock, MailOpen, User } from "lucide-react";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { getApiUrl } from "@/lib/utils";
+
+function AcceptInvitationForm() {
+  const router = useRouter();
+  const token = useSearchParams().get("token") || "";
+  const [email, setEmail] = useState("");
+  const [name, setName] = useState("");
+  const [jobTitle, setJobTitle] = useState("");
+  const [password, setPassword] = useState("");
+  const [confirmPassword, setConfirmPassword] = useState("");
+  const [isChecking, setIsChecking] = useState(true);
+  const [isInvalid, setIsInvalid] = useState(false);
+  const [isLoading, setIsLoading] = useState(false);
+  const [error, setError] = useState("");
+  const [isDone, setIsDone] = useState(false);
+
+  useEffect(() => {
+    if (!token) {
+      setIsInvalid(true);
+      setIsChecking(false);
+      return;
+    }
+    fetch(`${getApiUrl()}/api/invitations/accept?token=${encodeURIComponent(token)}`)
+      .then(async (res) => {
+        if (!res.ok) {
+          setIsInvalid(true);
+          return;
+        }
+        const user = await res.json();
+        setEmail(user.email || "");
+        setName(user.name || "");
+        setJobTitle(user.jobTitle || "");
+      })
+      .catch(() => setError("Network error. Please reload the page."))
+      .finally(() => setIsChecking(false));
+  }, [token]);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+
+    if (password.length < 8) {
+      setError("Password must be at least 8 characters long");
+      return;
+    }
+    if (password !== confirmPassword) {
+      setError("Passwords do not match");
+      return;
+    }
+
+    setIsLoading(true);
+    try {
+      const res = await fetch(`${getApiUrl()}/api/invitations/accept`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, name, jobTitle, password }),
+      });
+      const data = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setError(data.fields?.map((f: { message: string }) => f.message).join(" ") || data.error || "Failed to set up your account");
+        return;
+      }
+      setIsDone(true);
+    } catch (err) {
+      setError("Network error. Please try again.");
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  if (isChecking) {
+    return <p className="text-center text-slate-500 text-sm">Checking your invitation...</p>;
+  }
+
+  if (isDone) {
+    return (
+      <div className="flex flex-col items-center text-center space-y-6">
+        <div className="w-16 h-16 bg-green-100 rounded-full flex items-center justify-center text-green-600">
+          <CheckCircle className="w-8 h-8" />
+        </div>
+        <div className="space-y-2">
+          <h1 className="text-2xl font-bold text-slate-900 font-fredoka">You're all set</h1>
+          <p className="text-slate-500 text-sm px-4 leading-relaxed">
+            Your account is ready. Log in with your email and the password you just chose.
+          </p>
+        </div>
+        <Button className="w-full h-11 bg-blue-700 hover:bg-blue-800 font-semibold" size="lg" onClick={() => router.push("/login")}>
+          Go to Login
+        </Button>
+      </div>
+    );
+  }
+
+  if (isInvalid) {
+    return (
+      <div className="flex flex-col items-center text-center space-y-4">
+        <h1 className="text-2xl font-bold text-slate-900 font-fredoka">Invitation not valid</h1>
+        <p className="text-slate-500 text-sm px-4 leading-relaxed">
+          This invitation link has expired, was already used or was replaced by a newer one. Ask your administrator to resend it.
+        </p>
+        <Link href="/login" className="text-sm font-semibold text-blue-700 hover:text-blue-800">
+          Return to Login
+        </Link>
+      </div>
+    );
+  }
+
+  return (
+    <>
+      <div className="flex flex-col items-center text-center space-y-6">
+        <div className="w-16 h-16 bg-blue-50 rounded-full flex items-center justify-center text-blue-600">
+          <MailOpen className="w-8 h-8" />
+        </div>
+        <div className="space-y-2">
+          <h1 className="text-2xl font-bold text-slate-900 font-fredoka">Welcome to StackleVest</h1>
+          <p className="text-slate-500 text-sm px-4 leading-relaxed">
+            Finish setting up the account for <span className="font-semibold text-slate-700">{email}</span>.
+          </p>
+        </div>
+      </div>
+
+      <form onSubmit={handleSubmit} className="space-y-6">
+        <div className="space-y-2">
+          <Label htmlFor="name" className="font-semibold text-slate-700">Full Name</Label>
+          <Input
+            id="name"
+            placeholder="Enter your full name"
+            icon={<User className="w-4 h-4" />}
+            required
+            className="h-11"
+            value={name}
+            onChange={(e) => setName(e.target.value)}
+          />
+        </div>
+
+        <div className="space-y-2">
+          <Label htmlFor="jobTitle" className="font-semibold text-slate-700">Job Title</Label>
+          <Input
+            id="jobTitle"
+            placeholder="Enter your job title"
+            icon={<Briefcase className="w-4 h-4" />}
+            className="h-11"
+            value={jobTitle}
+            onChange={(e) => setJobTitle(e.target.value)}
+          />
+        </div>
+
+        <div className="space-y-2">
+          <Label htmlFor="password" className="font-semibold text-slate-700">Password</Label>
+          <Input
+            id="password"
+            type="password"
+            placeholder="Choose a password"
+            icon={<Lock className="w-4 h-4" />}
+            required
+            className="h-11"
+            value={password}
+            onChange={(e) => setPassword(e.target.value)}
+          />
+        </div>
+
+        <div className="space-y-2">
+          <Label htmlFor="confirmPassword" className="font-semibold text-slate-700">Confirm Password</Label>
+          <Input
+            id="confirmPassword"
+            type="password"
+            placeholder="Confirm your password"
+            icon={<Lock className="w-4 h-4" />}
+            required
+            className="h-11"
+            value={confirmPassword}
+            onChange={(e) => setConfirmPassword(e.target.value)}
+          />
+        </div>
+
+        {error && <p className="text-sm text-red-500 font-medium">{error}</p>}
+
+        <Button type="submit" className="w-full h-11 bg-blue-700 hover:bg-blue-800 font-semibold" size="lg" disabled={isLoading}>
+          {isLoading ? "Saving..." : "Set Up Account"}
+        </Button>
+      </form>
+    </>
+  );
+}
+
+export default function AcceptInvitationPage() {
+  return (
+    <div className="flex min-h-screen items-center justify-center p-4">
+      <div className="w-full max-w-[400px] space-y-8 bg-white p-8 rounded-2xl shadow-sm border border-slate-100">
+        <Suspense>
+          <AcceptInvitationForm />
+        </Suspense>
+      </div>
+    </div>
+  );
+}
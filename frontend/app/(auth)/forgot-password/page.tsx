@@ -8,16 +8,36 @@ import { motion } from "framer-motion";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 import { Label } from "@/components/ui/label";
+import { getApiUrl } from "@/lib/utils";
 
 export default function ForgotPasswordPage() {
   const router = useRouter();
   const [isSubmitted, setIsSubmitted] = useState(false);
   const [email, setEmail] = useState("");
+  const [isLoading, setIsLoading] = useState(false);
+  const [error, setError] = useState("");
 
-  const handleSubmit = (e: React.FormEvent) => {
+  const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
-    setIsSubmitted(true);
-    // Handle password reset logic here
+    setError("");
+    setIsLoading(true);
+    try {
+      const res = await fetch(`${getApiUrl()}/api/auth/forgot-password`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ email: email.trim() }),
+      });
+      if (!res.ok) {
+        const data = await res.json().catch(() => ({}));
+        setError(data.error || "Could not send the reset link. Please try again.");
+        return;
+      }
+      setIsSubmitted(true);
+    } catch (err) {
+      setError("Network error. Please try again.");
+    } finally {
+      setIsLoading(false);
+    }
   };
 
   if (isSubmitted) {
@@ -56,7 +76,7 @@ export default function ForgotPasswordPage() {
             >
               <h1 className="text-2xl font-bold text-slate-900 font-fredoka">Check your email</h1>
               <p className="text-slate-500 text-sm px-4 leading-relaxed">
-                We&apos;ve sent a password reset link to <span className="font-semibold text-slate-900">{email}</span>. Please check your inbox and follow the instructions.
+                If an account exists, we&apos;ve sent a password reset link to <span className="font-semibold text-slate-900">{email}</span>. Please check your inbox and follow the instructions.
               </p>
             </motion.div>
           </div>
@@ -138,8 +158,10 @@ export default function ForgotPasswordPage() {
             />
           </div>
 
-          <Button type="submit" className="w-full h-11 bg-blue-700 hover:bg-blue-800 font-semibold" size="lg">
-            Send Reset Link
+          {error && <p className="text-sm text-red-500 font-medium">{error}</p>}
+
+          <Button type="submit" className="w-full h-11 bg-blue-700 hover:bg-blue-800 font-semibold" size="lg" disabled={isLoading}>
+            {isLoading ? "Sending..." : "Send Reset Link"}
           </Button>
         </form>
 
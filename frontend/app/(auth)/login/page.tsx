@@ -11,12 +11,27 @@ import { Label } from "@/components/ui/label";
 import { AnimatedSplashCard } from "@/components/AnimatedSplashCard";
 import { Logo } from "@/components/logo";
 
+const SSO_ENABLED = process.env.NEXT_PUBLIC_SSO_ENABLED === "true";
+
+const SSO_ERRORS: Record<string, string> = {
+  expired: "Your single sign-on attempt expired. Please try again.",
+  email_unverified: "Your identity provider has not verified your email address.",
+  account_conflict: "Your email is already linked to a different company account. Contact an administrator.",
+  link_required: "Sign in with your password first, then link single sign-on from Settings > Security.",
+  sso_failed: "Single sign-on failed. Please try again.",
+};
+
 export default function LoginPage() {
   const router = useRouter();
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
   const [otp, setOtp] = useState("");
   const [requiresOtp, setRequiresOtp] = useState(false);
+  const [secondFactor, setSecondFactor] = useState("");
+  const [secondFactorToken, setSecondFactorToken] = useState("");
+  const [totpCode, setTotpCode] = useState("");
+  const [enrollment, setEnrollment] = useState<{ secret: string; uri: string } | null>(null);
+  const [recoveryCodes, setRecoveryCodes] = useState<string[]>([]);
   const [error, setError] = useState("");
   const [isLoading, setIsLoading] = useState(false);
 
@@ -29,9 +44,109 @@ export default function LoginPage() {
 
   const handleCredentialsSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
+    if (secondFactorToken) {
+      await submitSecondFactor();
+      return;
+    }
     await attemptLogin(email, password, otp);
   };
 
+  const completeLogin = async (credentials: Record<string, string | undefined>) => {
+    const result = await signIn("credentials", { ...credentials, redirect: false });
+    console.log("NextAuth signIn result", result);
+    if (result?.error) {
+      const invalid = credentials.secondFactorToken ? "Invalid authentication code" : "Invalid email or password";
+      setError(result.error === "CredentialsSignin" ? invalid : result.error);
+    } else {
+      router.push("/dashboard");
+    }
+  };
+
+  // Second step for accounts with TOTP: a code from the authenticator app or
+  // a recovery code. Accounts whose role requires TOTP but have none set it
+  // up here first and are shown their recovery codes before continuing.
+  const submitSecondFactor = async () => {
+    setError("");
+    setIsLoading(true);
+    try {
+      if (recoveryCodes.length > 0) {
+        await completeLogin({ secondFactorToken });
+        return;
+      }
+      if (secondFactor === "totp_enrollment") {
+        const res = await fetch(`${getApiUrl()}/api/auth/2fa/challenge/confirm`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ token: secondFactorToken, code: totpCode.trim() })
+        });
+        const data = await res.json().catch(() => ({}));
+        if (!res.ok) {
+          setError(data.error || "Invalid authentication code");
+          if (data.code === "challenge_expired") resetSecondFactor();
+          return;
+        }
+        setRecoveryCodes(data.recoveryCodes || []);
+        return;
+      }
+      await completeLogin({ secondFactorToken, code: totpCode.trim() });
+    } catch (err) {
+      setError("Network error. Is the server running?");
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  // A login waiting on a second factor, after the password or single
+  // sign-on. Users whose role requires TOTP but have none get a new secret
+  // to add to their app first.
+  const startSecondFactor = async (factor: string, token: string) => {
+    setSecondFactor(factor);
+    setSecondFactorToken(token);
+    if (factor !== "totp_enrollment") return;
+    const enrollRes = await fetch(`${getApiUrl()}/api/auth/2fa/challenge/enroll`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ token })
+    });
+    const enrollData = await enrollRes.json().catch(() => ({}));
+    if (!enrollRes.ok) {
+      setError(enrollData.error || "Could not start two-factor setup");
+      resetSecondFactor();
+      return;
+    }
+    setEnrollment(enrollData);
+  };
+
+  // The single sign-on callback sends the browser back here with a login
+  // token, and the second factor still owed on it, or with why it failed
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    const ssoToken = params.get("sso");
+    const ssoError = params.get("ssoError");
+    if (!ssoToken && !ssoError) return;
+    window.history.replaceState(null, "", window.location.pathname);
+
+    if (ssoError) {
+      setError(SSO_ERRORS[ssoError] || SSO_ERRORS.sso_failed);
+      return;
+    }
+    const factor = params.get("secondFactor");
+    if (factor) {
+      startSecondFactor(factor, ssoToken!);
+      return;
+    }
+    setIsLoading(true);
+    completeLogin({ secondFactorToken: ssoToken! }).finally(() => setIsLoading(false));
+  }, []);
+
+  const resetSecondFactor = () => {
+    setSecondFactor("");
+    setSecondFactorToken("");
+    setTotpCode("");
+    setEnrollment(null);
+    setRecoveryCodes([]);
+  };
+
   const attemptLogin = async (emailInput: string, passwordInput: string, otpInput?: string) => {
     setError("");
     setIsLoading(true);
@@ -50,7 +165,22 @@ export default function LoginPage() {
         const data = await res.json();
         console.log("Precheck response", { status: res.status, data });
         if (!res.ok) {
-          setError(data?.error || "Invalid email or password");
+          if (data?.code === "account_locked") {
+            const minutes = Math.max(1, Math.ceil((data.retryAfter || 0) / 60));
+            setError(`Too many failed attempts. Your account is locked, try again in ${minutes} minute${minutes === 1 ? "" : "s"}.`);
+          } else if (data?.code === "too_many_attempts") {
+            setError("Too many login attempts from this network. Please try again later.");
+          } else {
+            setError(data?.error || "Invalid email or password");
+          }
+          return;
+        }
+        if (data.requiresPasswordReset) {
+          router.push(`/reset-password?token=${encodeURIComponent(data.resetToken)}`);
+          return;
+        }
+        if (data.requiresSecondFactor) {
+          await startSecondFactor(data.secondFactor, data.secondFactorToken);
           return;
         }
         if (data.requiresOtp) {
@@ -60,19 +190,11 @@ export default function LoginPage() {
         }
       }
 
-      const result = await signIn("credentials", {
+      await completeLogin({
         email: emailTrimmed,
         password: passwordTrimmed,
         otp: requiresOtp ? otpInput : undefined,
-        redirect: false,
       });
-
-      console.log("NextAuth signIn result", result);
-      if (result?.error) {
-        setError(result.error === "CredentialsSignin" ? "Invalid email or password" : result.error);
-      } else {
-        router.push("/dashboard");
-      }
     } catch (err) {
       setError("Network error. Is the server running?");
     } finally {
@@ -148,13 +270,63 @@ export default function LoginPage() {
                 </div>
               )}
 
+              {enrollment && recoveryCodes.length === 0 && (
+                <div className="space-y-2 rounded-lg bg-slate-50 p-4 text-sm text-slate-600">
+                  <p className="font-medium text-slate-900">Set up two-factor authentication</p>
+                  <p>Your role requires an authenticator app. Add this key to your app, then enter the code it shows.</p>
+                  <p className="font-mono text-slate-900 break-all">{enrollment.secret}</p>
+                  <a href={enrollment.uri} className="text-blue-700 hover:underline">Open in authenticator app</a>
+                </div>
+              )}
+              {recoveryCodes.length > 0 && (
+                <div className="space-y-2 rounded-lg bg-slate-50 p-4 text-sm text-slate-600">
+                  <p className="font-medium text-slate-900">Save your recovery codes</p>
+                  <p>Each code signs you in once if you lose your authenticator. They will not be shown again.</p>
+                  <div className="grid grid-cols-2 gap-1 font-mono text-slate-900">
+                    {recoveryCodes.map((code) => <span key={code}>{code}</span>)}
+                  </div>
+                </div>
+              )}
+              {secondFactorToken && recoveryCodes.length === 0 && (
+                <div className="space-y-2">
+                  <Label htmlFor="totp">Authentication Code</Label>
+                  <Input
+                    id="totp"
+                    type="text"
+                    autoComplete="one-time-code"
+                    value={totpCode}
+                    onChange={(e) => setTotpCode(e.target.value)}
+                    placeholder={secondFactor === "totp" ? "6-digit code or recovery code" : "6-digit code"}
+                  />
+                </div>
+              )}
+
               {error && <p className="text-sm text-red-500 font-medium">{error}</p>}
 
               <Button className="w-full" size="lg" disabled={isLoading}>
-                {isLoading ? "Loading..." : requiresOtp ? "Verify & Login" : "Login"}
+                {isLoading
+                  ? "Loading..."
+                  : recoveryCodes.length > 0
+                    ? "I've saved them, continue"
+                    : secondFactorToken || requiresOtp
+                      ? "Verify & Login"
+                      : "Login"}
               </Button>
             </form>
 
+            {SSO_ENABLED && !secondFactorToken && (
+              <Button
+                type="button"
+                variant="outline"
+                className="w-full"
+                size="lg"
+                disabled={isLoading}
+                onClick={() => { window.location.href = `${getApiUrl()}/api/auth/oidc/login`; }}
+              >
+                Sign in with company SSO
+              </Button>
+            )}
+
             <div className="pt-6 border-t border-slate-100">
               <p className="text-xs text-slate-500 mb-3 font-medium uppercase tracking-wider">Demo Accounts</p>
               <div className="grid grid-cols-2 gap-2">
@@ -0,0 +1,160 @@
+"use client";
+
+import { Suspense, useState } from "react";
+import Link from "next/link";
+import { useRouter, useSearchParams } from "next/navigation";
+import { ArrowLeft, CheckCircle, KeyRound, Lock } from "lucide-react";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { getApiUrl } from "@/lib/utils";
+
+function ResetPasswordForm() {
+  const router = useRouter();
+  const token = useSearchParams().get("token") || "";
+  const [password, setPassword] = useState("");
+  const [confirmPassword, setConfirmPassword] = useState("");
+  const [isLoading, setIsLoading] = useState(false);
+  const [error, setError] = useState("");
+  const [isDone, setIsDone] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+
+    if (password.length < 8) {
+      setError("Password must be at least 8 characters long");
+      return;
+    }
+    if (password !== confirmPassword) {
+      setError("Passwords do not match");
+      return;
+    }
+
+    setIsLoading(true);
+    try {
+      const res = await fetch(`${getApiUrl()}/api/auth/reset-password`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, newPassword: password }),
+      });
+      const data = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setError(data.fields?.map((f: { message: string }) => f.message).join(" ") || data.error || "Failed to reset password");
+        return;
+      }
+      setIsDone(true);
+    } catch (err) {
+      setError("Network error. Please try again.");
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  if (isDone) {
+    return (
+      <div className="flex flex-col items-center text-center space-y-6">
+        <div className="w-16 h-16 bg-green-100 rounded-full flex items-center justify-center text-green-600">
+          <CheckCircle className="w-8 h-8" />
+        </div>
+        <div className="space-y-2">
+          <h1 className="text-2xl font-bold text-slate-900 font-fredoka">Password updated</h1>
+          <p className="text-slate-500 text-sm px-4 leading-relaxed">
+            You have been signed out of all devices. Log in with your new password.
+          </p>
+        </div>
+        <Button className="w-full h-11 bg-blue-700 hover:bg-blue-800 font-semibold" size="lg" onClick={() => router.push("/login")}>
+          Return to Login
+        </Button>
+      </div>
+    );
+  }
+
+  if (!token) {
+    return (
+      <div className="flex flex-col items-center text-center space-y-4">
+        <h1 className="text-2xl font-bold text-slate-900 font-fredoka">Invalid reset link</h1>
+        <p className="text-slate-500 text-sm px-4 leading-relaxed">
+          This link is missing its reset token. Request a new one and use the link from the email.
+        </p>
+        <Link href="/forgot-password" className="text-sm font-semibold text-blue-700 hover:text-blue-800">
+          Request a new link
+        </Link>
+      </div>
+    );
+  }
+
+  return (
+    <>
+      <div className="flex flex-col items-center text-center space-y-6">
+        <div className="w-16 h-16 bg-blue-50 rounded-full flex items-center justify-center text-blue-600">
+          <KeyRound className="w-8 h-8" />
+        </div>
+        <div className="space-y-2">
+          <h1 className="text-2xl font-bold text-slate-900 font-fredoka">Choose a new password</h1>
+          <p className="text-slate-500 text-sm px-4 leading-relaxed">
+            Your new password must be at least 8 characters long.
+          </p>
+        </div>
+      </div>
+
+      <form onSubmit={handleSubmit} className="space-y-6">
+        <div className="space-y-2">
+          <Label htmlFor="password" className="font-semibold text-slate-700">New Password</Label>
+          <Input
+            id="password"
+            type="password"
+            placeholder="Enter new password"
+            icon={<Lock className="w-4 h-4" />}
+            required
+            className="h-11"
+            value={password}
+            onChange={(e) => setPassword(e.target.value)}
+          />
+        </div>
+
+        <div className="space-y-2">
+          <Label htmlFor="confirmPassword" className="font-semibold text-slate-700">Confirm Password</Label>
+          <Input
+            id="confirmPassword"
+            type="password"
+            placeholder="Confirm new password"
+            icon={<Lock className="w-4 h-4" />}
+            required
+            className="h-11"
+            value={confirmPassword}
+            onChange={(e) => setConfirmPassword(e.target.value)}
+          />
+        </div>
+
+        {error && <p className="text-sm text-red-500 font-medium">{error}</p>}
+
+        <Button type="submit" className="w-full h-11 bg-blue-700 hover:bg-blue-800 font-semibold" size="lg" disabled={isLoading}>
+          {isLoading ? "Saving..." : "Reset Password"}
+        </Button>
+      </form>
+
+      <div className="flex justify-center pt-2">
+        <Link
+          href="/login"
+          className="flex items-center text-sm font-semibold text-blue-700 hover:text-blue-800 gap-2 transition-colors"
+        >
+          <ArrowLeft className="w-4 h-4" />
+          Return to Login
+        </Link>
+      </div>
+    </>
+  );
+}
+
+export default function ResetPasswordPage() {
+  return (
+    <div className="flex min-h-screen items-center justify-center p-4">
+      <div className="w-full max-w-[400px] space-y-8 bg-white p-8 rounded-2xl shadow-sm border border-slate-100">
+        <Suspense>
+          <ResetPasswordForm />
+        </Suspense>
+      </div>
+    </div>
+  );
+}
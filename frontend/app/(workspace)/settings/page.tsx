@@ -1,6 +1,6 @@
 "use client";
 
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { 
   User, 
   Mail, 
@@ -11,7 +11,8 @@ import {
   LogOut, 
   ChevronRight,
   Camera,
-  Check
+  Check,
+  KeyRound
 } from "lucide-react";
 import { useWorkspace } from "@/features/workspace/context";
 import { Button } from "@/components/ui/button";
@@ -20,14 +21,56 @@ import { Label } from "@/components/ui/label";
 import { Avatar, AvatarFallback, AvatarImage } from "@/components/ui/avatar";
 import { cn } from "@/lib/utils";
 import { useRouter } from "next/navigation";
-import { signOut } from "next-auth/react";
+import { signOut, useSession } from "next-auth/react";
+import { api } from "@/lib/api/client";
+
+const SSO_ENABLED = process.env.NEXT_PUBLIC_SSO_ENABLED === "true";
+
+const SSO_LINK_ERRORS: Record<string, string> = {
+  expired: "Linking single sign-on expired. Please try again.",
+  sso_failed: "Linking single sign-on failed. Please try again.",
+};
 
 export default function SettingsPage() {
   const { currentUser, updateProfile } = useWorkspace();
+  const { data: session } = useSession();
   const router = useRouter();
   const [activeTab, setActiveTab] = useState<"profile" | "notifications" | "appearance" | "security">("profile");
   const [isSaving, setIsSaving] = useState(false);
   const [showSuccess, setShowSuccess] = useState(false);
+  const [ssoMessage, setSsoMessage] = useState("");
+
+  const accessToken = (session as any)?.accessToken;
+  const authHeaders = { Authorization: `Bearer ${accessToken}` };
+
+  // The single sign-on callback of a link flow sends the browser back here
+  // with a token the signed-in user confirms, or with why it failed
+  useEffect(() => {
+    if (!accessToken) return;
+    const params = new URLSearchParams(window.location.search);
+    const linkToken = params.get("ssoLink");
+    const ssoError = params.get("ssoError");
+    if (!linkToken && !ssoError) return;
+    window.history.replaceState(null, "", window.location.pathname);
+    setActiveTab("security");
+
+    if (ssoError) {
+      setSsoMessage(SSO_LINK_ERRORS[ssoError] || SSO_LINK_ERRORS.sso_failed);
+      return;
+    }
+    api.post("/api/auth/oidc/link/confirm", { token: linkToken }, { headers: authHeaders })
+      .then(() => setSsoMessage("Single sign-on is linked to your account."))
+      .catch((error) => setSsoMessage(error.message || SSO_LINK_ERRORS.sso_failed));
+  }, [accessToken]);
+
+  const linkSSO = async () => {
+    try {
+      const { url } = await api.post<{ url: string }>("/api/auth/oidc/link", undefined, { headers: authHeaders });
+      window.location.href = url;
+    } catch (error: any) {
+      setSsoMessage(error.message || SSO_LINK_ERRORS.sso_failed);
+    }
+  };
 
   // Local state for form fields
   const [formData, setFormData] = useState({
@@ -40,7 +83,9 @@ export default function SettingsPage() {
   const handleSave = async () => {
     setIsSaving(true);
     try {
-      await updateProfile(formData);
+      // Email is managed by admins; the profile endpoint refuses it
+      const { email, ...profile } = formData;
+      await updateProfile(profile);
       setShowSuccess(true);
       setTimeout(() => setShowSuccess(false), 3000);
     } catch (error) {
@@ -284,6 +329,17 @@ export default function SettingsPage() {
                       <Button variant="outline" className="w-fit">Update Password</Button>
                     </div>
                   </div>
+                  {SSO_ENABLED && (
+                    <div className="p-6 rounded-xl border border-slate-100 space-y-4">
+                      <h3 className="font-bold text-slate-900">Single Sign-On</h3>
+                      <p className="text-sm text-slate-500">Link your account to the company identity provider to sign in with it.</p>
+                      {ssoMessage && <p className="text-sm text-slate-700">{ssoMessage}</p>}
+                      <Button variant="outline" className="w-fit" onClick={linkSSO}>
+                        <KeyRound className="w-4 h-4 mr-2" />
+                        Link Single Sign-On
+                      </Button>
+                    </div>
+                  )}
                   <div className="p-6 rounded-xl border border-red-100 bg-red-50/50 space-y-4">
                     <h3 className="font-bold text-red-900">Danger Zone</h3>
                     <p className="text-sm text-red-700">Once you delete your account, there is no going back. Please be certain.</p>
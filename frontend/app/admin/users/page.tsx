@@ -25,6 +25,7 @@ import { Avatar, AvatarFallback, AvatarImage } from "@/components/ui/avatar";
 import { AddUserDialog } from "../../../components/admin/add-user-dialog";
 import { EditUserDialog } from "../../../components/admin/edit-user-dialog";
 import { DeleteUserDialog } from "../../../components/admin/delete-user-dialog";
+import type { FieldError } from "@/types";
 
 export default function UserManagementPage() {
   const [isAddUserOpen, setIsAddUserOpen] = useState(false);
@@ -33,12 +34,14 @@ export default function UserManagementPage() {
   const [selectedUser, setSelectedUser] = useState<any>(null);
   const [users, setUsers] = useState<any[]>([]);
   const [searchQuery, setSearchQuery] = useState("");
+  const [nextCursor, setNextCursor] = useState<string | undefined>();
   const [isLoading, setIsLoading] = useState(true);
 
-  // Fetch users on mount
+  // Fetch the first page on mount and, once typing pauses, on every search
   useEffect(() => {
-    fetchUsers();
-  }, []);
+    const timer = setTimeout(() => fetchUsers(), searchQuery ? 300 : 0);
+    return () => clearTimeout(timer);
+  }, [searchQuery]);
 
   const getApiUrl = () => {
     if (typeof window !== 'undefined') {
@@ -47,12 +50,18 @@ export default function UserManagementPage() {
     return 'http://localhost:8080';
   };
 
-  const fetchUsers = async () => {
+  // fetchUsers loads the first page of users matching the search, or with a
+  // cursor the page after it, appended to those already shown
+  const fetchUsers = async (cursor?: string) => {
+    const params = new URLSearchParams({ sort: "name" });
+    if (searchQuery) params.set("search", searchQuery);
+    if (cursor) params.set("cursor", cursor);
     try {
-      const res = await fetch(`${getApiUrl()}/api/users`);
+      const res = await fetch(`${getApiUrl()}/api/users?${params}`);
       if (res.ok) {
         const data = await res.json();
-        setUsers(data);
+        setUsers(cursor ? [...users, ...data.users] : data.users);
+        setNextCursor(data.nextCursor);
       }
     } catch (error) {
       console.error("Failed to fetch users:", error);
@@ -61,12 +70,14 @@ export default function UserManagementPage() {
     }
   };
 
-  const filteredUsers = users.filter(user =>
-    user.name.toLowerCase().includes(searchQuery.toLowerCase()) ||
-    user.email.toLowerCase().includes(searchQuery.toLowerCase())
-  );
+  // rejectedFields reads the field errors of a failed save; any other
+  // failure is reported against the form as a whole
+  const rejectedFields = async (res: Response): Promise<FieldError[]> => {
+    const data = await res.json().catch(() => ({}));
+    return data.fields || [{ field: "", code: "error", message: data.error || "Failed to save user" }];
+  };
 
-  const handleAddUser = async (newUser: any) => {
+  const handleAddUser = async (newUser: any): Promise<FieldError[] | undefined> => {
     try {
       const res = await fetch(`${getApiUrl()}/api/users`, {
         method: "POST",
@@ -77,7 +88,9 @@ export default function UserManagementPage() {
       if (res.ok) {
         const createdUser = await res.json();
         setUsers([...users, createdUser]);
+        return;
       }
+      return rejectedFields(res);
     } catch (error) {
       console.error("Failed to create user:", error);
     }
@@ -88,7 +101,7 @@ export default function UserManagementPage() {
     setIsEditUserOpen(true);
   };
 
-  const handleUpdateUser = async (updatedUser: any) => {
+  const handleUpdateUser = async (updatedUser: any): Promise<FieldError[] | undefined> => {
     try {
       const res = await fetch(`${getApiUrl()}/api/users/${updatedUser.id}`, {
         method: "PUT",
@@ -99,7 +112,9 @@ export default function UserManagementPage() {
       if (res.ok) {
         const savedUser = await res.json();
         setUsers(users.map(u => u.id === savedUser.id ? savedUser : u));
+        return;
       }
+      return rejectedFields(res);
     } catch (error) {
       console.error("Failed to update user:", error);
     }
@@ -168,7 +183,7 @@ export default function UserManagementPage() {
             Role: All
             <Filter className="w-3 h-3" />
           </Button>
-          <span className="text-xs text-slate-400">Showing {filteredUsers.length} staff members</span>
+          <span className="text-xs text-slate-400">Showing {users.length} staff members</span>
         </div>
       </div>
 
@@ -186,7 +201,7 @@ export default function UserManagementPage() {
             </TableRow>
           </TableHeader>
           <TableBody>
-            {filteredUsers.map((user) => (
+            {users.map((user) => (
               <TableRow key={user.id} className="hover:bg-slate-50 dark:hover:bg-slate-800/50">
                 <TableCell className="font-medium">
                   <div className="flex items-center gap-3">
@@ -248,23 +263,22 @@ export default function UserManagementPage() {
           </TableBody>
         </Table>
 
-        {/* Pagination Placeholder */}
-        <div className="px-4 py-3 border-t border-slate-200 dark:border-slate-800 flex items-center justify-center bg-slate-50 dark:bg-slate-800/50">
-          <div className="flex gap-1">
-            <Button variant="outline" size="sm" className="h-8 w-8 p-0" disabled>&lt;</Button>
-            <Button variant="default" size="sm" className="h-8 w-8 p-0 bg-blue-600">1</Button>
-            <Button variant="outline" size="sm" className="h-8 w-8 p-0">2</Button>
-            <Button variant="outline" size="sm" className="h-8 w-8 p-0">3</Button>
-            <Button variant="outline" size="sm" className="h-8 w-8 p-0">&gt;</Button>
+        {/* Pagination */}
+        {nextCursor && (
+          <div className="px-4 py-3 border-t border-slate-200 dark:border-slate-800 flex items-center justify-center bg-slate-50 dark:bg-slate-800/50">
+            <Button variant="outline" size="sm" onClick={() => fetchUsers(nextCursor)}>
+              Load more
+            </Button>
           </div>
-        </div>
+        )}
       </div>
 
-      <AddUserDialog open={isAddUserOpen} onOpenChange={setIsAddUserOpen} onUserAdd={handleAddUser} />
+      <AddUserDialog open={isAddUserOpen} onOpenChange={setIsAddUserOpen} users={users} onUserAdd={handleAddUser} />
       <EditUserDialog
         open={isEditUserOpen}
         onOpenChange={setIsEditUserOpen}
         user={selectedUser}
+        users={users}
         onSave={handleUpdateUser}
       />
       <DeleteUserDialog
@@ -21,15 +21,28 @@ import {
   SelectTrigger,
   SelectValue,
 } from "@/components/ui/select";
+import type { FieldError } from "@/types";
 
 interface AddUserDialogProps {
   open: boolean;
   onOpenChange: (open: boolean) => void;
-  onUserAdd?: (user: any) => void;
+  users: { id: string; name: string }[];
+  // Resolves to the rejected fields, if any, so they can be shown here
+  onUserAdd?: (user: any) => Promise<FieldError[] | undefined>;
 }
 
-export function AddUserDialog({ open, onOpenChange, onUserAdd }: AddUserDialogProps) {
+export function AddUserDialog({ open, onOpenChange, users, onUserAdd }: AddUserDialogProps) {
   const [isLoading, setIsLoading] = useState(false);
+  const [errors, setErrors] = useState<FieldError[]>([]);
+
+  const fieldError = (field: string) =>
+    errors
+      .filter((e) => e.field === field)
+      .map((e) => (
+        <p key={e.code} className="text-xs text-red-500">
+          {e.message}
+        </p>
+      ));
 
   const handleSubmit = async (e: React.FormEvent<HTMLFormElement>) => {
     e.preventDefault();
@@ -42,18 +55,17 @@ export function AddUserDialog({ open, onOpenChange, onUserAdd }: AddUserDialogPr
       role: formData.get("role") || "STAFF",
       department: formData.get("department"),
       jobTitle: formData.get("jobTitle"),
-      reportingManager: formData.get("reportingManager"),
-      staffNumber: formData.get("staffNumber"),
+      reportingManager: formData.get("reportingManager") === "none" ? "" : formData.get("reportingManager") || "",
       status: "ACTIVE", // Default to active for now
       avatar: "",
     };
 
-    if (onUserAdd) {
-      await onUserAdd(newUser);
-    }
-    
+    const rejected = onUserAdd ? await onUserAdd(newUser) : undefined;
+    setErrors(rejected || []);
     setIsLoading(false);
-    onOpenChange(false);
+    if (!rejected?.length) {
+      onOpenChange(false);
+    }
   };
 
   return (
@@ -73,11 +85,13 @@ export function AddUserDialog({ open, onOpenChange, onUserAdd }: AddUserDialogPr
               <div className="space-y-2">
                 <Label htmlFor="fullName">Full Name</Label>
                 <Input id="fullName" name="fullName" placeholder="e.g. John Doe" required />
+                {fieldError("name")}
               </div>
 
               <div className="space-y-2">
                 <Label htmlFor="email">Email Address</Label>
                 <Input id="email" name="email" type="email" placeholder="name@company.com" required />
+                {fieldError("email")}
               </div>
 
               <div className="grid grid-cols-2 gap-4">
@@ -95,6 +109,7 @@ export function AddUserDialog({ open, onOpenChange, onUserAdd }: AddUserDialogPr
                       <SelectItem value="hr">Human Resources</SelectItem>
                     </SelectContent>
                   </Select>
+                  {fieldError("department")}
                 </div>
 
                 <div className="space-y-2">
@@ -109,24 +124,32 @@ export function AddUserDialog({ open, onOpenChange, onUserAdd }: AddUserDialogPr
                       <SelectItem value="STAFF">Staff</SelectItem>
                     </SelectContent>
                   </Select>
+                  {fieldError("role")}
                 </div>
               </div>
 
               <div className="space-y-4">
-                <div className="grid grid-cols-2 gap-4">
-                  <div className="space-y-2">
-                    <Label htmlFor="jobTitle">Official Job Title</Label>
-                    <Input id="jobTitle" name="jobTitle" placeholder="e.g. Senior Developer" />
-                  </div>
-                  <div className="space-y-2">
-                    <Label htmlFor="staffNumber">Staff Number</Label>
-                    <Input id="staffNumber" name="staffNumber" placeholder="e.g. 001" />
-                  </div>
+                <div className="space-y-2">
+                  <Label htmlFor="jobTitle">Official Job Title</Label>
+                  <Input id="jobTitle" name="jobTitle" placeholder="e.g. Senior Developer" />
+                  {fieldError("jobTitle")}
+                  <p className="text-xs text-slate-500">A staff number is assigned automatically.</p>
                 </div>
 
                 <div className="space-y-2">
-                  <Label htmlFor="reportingManager">Reporting Manager</Label>
-                  <Input id="reportingManager" name="reportingManager" placeholder="e.g. Sarah Jenkins" />
+                  <Label>Reporting Manager</Label>
+                  <Select name="reportingManager" defaultValue="none">
+                    <SelectTrigger>
+                      <SelectValue placeholder="Select manager" />
+                    </SelectTrigger>
+                    <SelectContent>
+                      <SelectItem value="none">No manager</SelectItem>
+                      {users.map((u) => (
+                        <SelectItem key={u.id} value={u.id}>{u.name}</SelectItem>
+                      ))}
+                    </SelectContent>
+                  </Select>
+                  {fieldError("reportingManager")}
                 </div>
               </div>
             </div>
@@ -156,6 +179,7 @@ export function AddUserDialog({ open, onOpenChange, onUserAdd }: AddUserDialogPr
               </div>
             </div>
 
+            {fieldError("")}
           </div>
 
           <DialogFooter className="p-6 pt-2 bg-slate-50/50">
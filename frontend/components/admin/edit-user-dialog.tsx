@@ -18,6 +18,7 @@ import {
   SelectTrigger,
   SelectValue,
 } from "@/components/ui/select";
+import type { FieldError } from "@/types";
 
 interface EditUserDialogProps {
   open: boolean;
@@ -33,10 +34,12 @@ interface EditUserDialogProps {
     staffNumber?: string;
     department?: string;
   } | null;
-  onSave: (updatedUser: any) => void;
+  users: { id: string; name: string }[];
+  // Resolves to the rejected fields, if any, so they can be shown here
+  onSave: (updatedUser: any) => Promise<FieldError[] | undefined>;
 }
 
-export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDialogProps) {
+export function EditUserDialog({ open, onOpenChange, user, users, onSave }: EditUserDialogProps) {
   const [name, setName] = useState("");
   const [email, setEmail] = useState("");
   const [role, setRole] = useState("STAFF");
@@ -45,6 +48,7 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
   const [reportingManager, setReportingManager] = useState("");
   const [staffNumber, setStaffNumber] = useState("");
   const [department, setDepartment] = useState("");
+  const [errors, setErrors] = useState<FieldError[]>([]);
 
   useEffect(() => {
     if (user) {
@@ -56,12 +60,13 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
       setReportingManager(user.reportingManager || "");
       setStaffNumber(user.staffNumber || "");
       setDepartment(user.department || "");
+      setErrors([]);
     }
   }, [user]);
 
-  const handleSave = () => {
+  const handleSave = async () => {
     if (user) {
-      onSave({
+      const rejected = await onSave({
         ...user,
         name,
         email,
@@ -72,10 +77,22 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
         staffNumber,
         department,
       });
-      onOpenChange(false);
+      setErrors(rejected || []);
+      if (!rejected?.length) {
+        onOpenChange(false);
+      }
     }
   };
 
+  const fieldError = (field: string) =>
+    errors
+      .filter((e) => e.field === field)
+      .map((e) => (
+        <p key={e.code} className="col-span-3 col-start-2 -mt-2 text-xs text-red-500">
+          {e.message}
+        </p>
+      ));
+
   return (
     <Dialog open={open} onOpenChange={onOpenChange}>
       <DialogContent className="sm:max-w-[425px]">
@@ -93,6 +110,7 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
               onChange={(e) => setName(e.target.value)}
               className="col-span-3"
             />
+            {fieldError("name")}
           </div>
           <div className="grid grid-cols-4 items-center gap-4">
             <Label htmlFor="email" className="text-right">
@@ -104,6 +122,7 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
               onChange={(e) => setEmail(e.target.value)}
               className="col-span-3"
             />
+            {fieldError("email")}
           </div>
           <div className="grid grid-cols-4 items-center gap-4">
             <Label htmlFor="role" className="text-right">
@@ -121,6 +140,7 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
                 </SelectContent>
               </Select>
             </div>
+            {fieldError("role")}
           </div>
           <div className="grid grid-cols-4 items-center gap-4">
             <Label htmlFor="status" className="text-right">
@@ -138,6 +158,7 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
                 </SelectContent>
               </Select>
             </div>
+            {fieldError("status")}
           </div>
 
           <div className="grid grid-cols-4 items-center gap-4">
@@ -158,6 +179,7 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
                 </SelectContent>
               </Select>
             </div>
+            {fieldError("department")}
           </div>
 
           <div className="grid grid-cols-4 items-center gap-4">
@@ -170,6 +192,7 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
               onChange={(e) => setJobTitle(e.target.value)}
               className="col-span-3"
             />
+            {fieldError("jobTitle")}
           </div>
 
           <div className="grid grid-cols-4 items-center gap-4">
@@ -182,20 +205,30 @@ export function EditUserDialog({ open, onOpenChange, user, onSave }: EditUserDia
               onChange={(e) => setStaffNumber(e.target.value)}
               className="col-span-3"
             />
+            {fieldError("staffNumber")}
           </div>
 
           <div className="grid grid-cols-4 items-center gap-4">
             <Label htmlFor="reportingManager" className="text-right">
               Manager
             </Label>
-            <Input
-              id="reportingManager"
-              value={reportingManager}
-              onChange={(e) => setReportingManager(e.target.value)}
-              className="col-span-3"
-            />
+            <div className="col-span-3">
+              <Select value={reportingManager || "none"} onValueChange={(v) => setReportingManager(v === "none" ? "" : v)}>
+                <SelectTrigger>
+                  <SelectValue placeholder="Select manager" />
+                </SelectTrigger>
+                <SelectContent>
+                  <SelectItem value="none">No manager</SelectItem>
+                  {users.filter((u) => u.id !== user?.id).map((u) => (
+                    <SelectItem key={u.id} value={u.id}>{u.name}</SelectItem>
+                  ))}
+                </SelectContent>
+              </Select>
+            </div>
+            {fieldError("reportingManager")}
           </div>
         </div>
+        {fieldError("")}
         <DialogFooter>
           <Button onClick={handleSave}>Save Changes</Button>
         </DialogFooter>
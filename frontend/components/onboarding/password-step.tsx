@@ -12,6 +12,7 @@ interface PasswordStepProps {
 
 export function PasswordStep({ onNext }: PasswordStepProps) {
   const { data: session } = useSession();
+  const [currentPassword, setCurrentPassword] = useState("");
   const [password, setPassword] = useState("");
   const [confirmPassword, setConfirmPassword] = useState("");
   const [isLoading, setIsLoading] = useState(false);
@@ -22,8 +23,8 @@ export function PasswordStep({ onNext }: PasswordStepProps) {
     e.preventDefault();
     setError("");
 
-    if (password.length < 6) {
-      setError("Password must be at least 6 characters long");
+    if (password.length < 8) {
+      setError("Password must be at least 8 characters long");
       return;
     }
 
@@ -37,10 +38,13 @@ export function PasswordStep({ onNext }: PasswordStepProps) {
     try {
       const res = await fetch(`${getApiUrl()}/api/auth/change-password`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ 
-          email: session?.user?.email, 
-          newPassword: password 
+        headers: {
+          "Content-Type": "application/json",
+          Authorization: `Bearer ${session?.accessToken}`,
+        },
+        body: JSON.stringify({
+          currentPassword,
+          newPassword: password,
         }),
       });
 
@@ -52,7 +56,7 @@ export function PasswordStep({ onNext }: PasswordStepProps) {
         }, 1500);
       } else {
         const data = await res.json();
-        setError(data.error || "Failed to update password");
+        setError(data.fields?.map((f: { message: string }) => f.message).join(" ") || data.error || "Failed to update password");
       }
     } catch (err) {
       setError("Network error. Please try again.");
@@ -88,6 +92,19 @@ export function PasswordStep({ onNext }: PasswordStepProps) {
 
         <div className="p-8">
           <form onSubmit={handleSubmit} className="space-y-6">
+            <div className="space-y-2">
+              <Label htmlFor="currentPassword">Current Password</Label>
+              <Input
+                id="currentPassword"
+                type="password"
+                value={currentPassword}
+                onChange={(e) => setCurrentPassword(e.target.value)}
+                placeholder="The password you just signed in with"
+                icon={<Lock className="w-4 h-4" />}
+                required
+              />
+            </div>
+
             <div className="space-y-2">
               <Label htmlFor="password">New Password</Label>
               <Input
@@ -576,9 +576,13 @@ export function WorkspaceProvider({ children }: { children: ReactNode }) {
 
     try {
       const baseUrl = typeof window !== 'undefined' ? `http://${window.location.hostname}:8080` : 'http://localhost:8080';
-      const response = await fetch(`${baseUrl}/api/users/${state.currentUser.id}`, {
+      const token = (session as any)?.accessToken;
+      const response = await fetch(`${baseUrl}/api/users/me`, {
         method: 'PATCH',
-        headers: { 'Content-Type': 'application/json' },
+        headers: {
+          'Content-Type': 'application/merge-patch+json',
+          ...(token ? { Authorization: `Bearer ${token}` } : {})
+        },
         body: JSON.stringify(updates)
       });
 
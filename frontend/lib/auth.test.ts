@@ -75,6 +75,22 @@ describe('Auth Configuration', () => {
       expect(result).toBeNull();
     });
 
+    it('should forward the browser address for per-IP throttling', async () => {
+      fetchMock.mockResolvedValueOnce({ ok: false, text: async () => '' });
+
+      await authorize(
+        { email: 'test@example.com', password: 'wrong' },
+        { headers: { 'x-forwarded-for': '198.51.100.9, 203.0.113.7' } }
+      );
+
+      expect(fetchMock).toHaveBeenCalledWith(
+        "http://localhost:8080/api/login",
+        expect.objectContaining({
+          headers: expect.objectContaining({ "X-Forwarded-For": '203.0.113.7' })
+        })
+      );
+    });
+
     it('should return null on network error', async () => {
       fetchMock.mockRejectedValueOnce(new Error('Network error'));
 
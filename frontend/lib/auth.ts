@@ -5,21 +5,38 @@ import GithubProvider from "next-auth/providers/github";
 // Update to Go backend port
 const API_URL = process.env.API_URL || "http://localhost:8080";
 
-export const authorize = async (credentials: any) => {
-  if (!credentials?.email) return null;
+export const authorize = async (credentials: any, req?: any) => {
+  if (!credentials?.email && !credentials?.secondFactorToken) return null;
+
+  // The backend throttles failed logins per IP. Pass on the browser's
+  // address, the last hop in front of Next.js, or every login would count
+  // against this server's.
+  const headers: Record<string, string> = { "Content-Type": "application/json" };
+  const clientIP = String(req?.headers?.["x-forwarded-for"] ?? "").split(",").pop()?.trim();
+  if (clientIP) headers["X-Forwarded-For"] = clientIP;
 
   try {
-    // Connect to our new Go Backend API
+    // Connect to our new Go Backend API. A login waiting on TOTP is
+    // finished with the challenge token instead of the password.
     console.log("Authorize: calling backend", { API_URL, email: credentials.email });
-    const res = await fetch(`${API_URL}/api/login`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({
-        email: credentials.email,
-        password: credentials.password,
-        // otp: credentials.otp // TODO: Re-enable OTP in Go backend
-      })
-    });
+    const res = credentials.secondFactorToken
+      ? await fetch(`${API_URL}/api/auth/2fa/verify`, {
+          method: "POST",
+          headers,
+          body: JSON.stringify({
+            token: credentials.secondFactorToken,
+            code: credentials.code,
+          })
+        })
+      : await fetch(`${API_URL}/api/login`, {
+          method: "POST",
+          headers,
+          body: JSON.stringify({
+            email: credentials.email,
+            password: credentials.password,
+            otp: credentials.otp,
+          })
+        });
 
     if (!res.ok) {
       const text = await res.text().catch(() => "");
@@ -35,6 +52,10 @@ export const authorize = async (credentials: any) => {
       console.warn("Authorize blocked: OTP required");
       return null; // TODO: handle OTP flow
     }
+    if (data.requiresSecondFactor) {
+      console.warn("Authorize blocked: second factor required");
+      return null;
+    }
 
     const { user, accessToken, refreshToken } = data;
 
@@ -73,7 +94,9 @@ export const authOptions: NextAuthOptions = {
       credentials: {
         email: { label: "Email", type: "email" },
         password: { label: "Password", type: "password" },
-        otp: { label: "OTP", type: "text" }
+        otp: { label: "OTP", type: "text" },
+        secondFactorToken: { label: "Second factor token", type: "text" },
+        code: { label: "Authentication code", type: "text" }
       },
       authorize
     })
@@ -143,7 +166,9 @@ export const authOptions: NextAuthOptions = {
       const oneHour = 60 * 60;
       if (token.email && (!token.lastRefreshed || now - (token.lastRefreshed as number) > oneHour)) {
         try {
-          const res = await fetch(`${API_URL}/api/users/email/${token.email}`);
+          const res = await fetch(`${API_URL}/api/users/email/${token.email}`, {
+            headers: { Authorization: `Bearer ${token.accessToken}` },
+          });
           if (res.ok) {
             const userData = await res.json();
             token.role = (userData.role || "staff").toLowerCase();
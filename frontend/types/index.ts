@@ -17,6 +17,13 @@ export interface User {
   department?: string;
 }
 
+// One rejected input in a 400 response's "fields"
+export interface FieldError {
+  field: string;
+  code: string;
+  message: string;
+}
+
 export interface Channel {
   id: string;
   name: string;
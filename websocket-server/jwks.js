@@ -0,0 +1,88 @@
+const crypto = require('crypto');
+const jwt = require('jsonwebtoken');
+const logger = require('./logger');
+
+// Access tokens are signed by the Go backend, which publishes its public
+// keys here. We only ever hold public keys, so this server cannot mint tokens.
+const JWKS_URL = process.env.JWKS_URL || (process.env.API_URL && `${process.env.API_URL}/.well-known/jwks.json`);
+if (!JWKS_URL) {
+  // There is no safe default: the backend's usual port is also this server's
+  throw new Error('Set JWKS_URL, or API_URL to the Go backend (e.g. API_URL=http://localhost:8080)');
+}
+const CACHE_TTL_MS = 5 * 60 * 1000;
+const MIN_REFETCH_MS = 30 * 1000; // Throttle refetches triggered by unknown kids
+
+let keys = new Map();
+let fetchedAt = 0;
+let pending = null;
+
+const refreshKeys = () => {
+  if (!pending) {
+    pending = fetch(JWKS_URL)
+      .then(res => {
+        if (!res.ok) throw new Error(`JWKS fetch failed with status ${res.status}`);
+        return res.json();
+      })
+      .then(set => {
+        const next = new Map();
+        for (const jwk of set.keys || []) {
+          try {
+            next.set(jwk.kid, { alg: jwk.alg, key: crypto.createPublicKey({ key: jwk, format: 'jwk' }) });
+          } catch (err) {
+            logger.warn(`Ignoring unusable JWK ${jwk.kid}: ${err.message}`);
+          }
+        }
+        keys = next;
+        fetchedAt = Date.now();
+      })
+      .catch(err => logger.error(`Failed to load JWKS from ${JWKS_URL}: ${err.message}`))
+      .finally(() => { pending = null; });
+  }
+  return pending;
+};
+
+const getKey = async (kid) => {
+  const age = Date.now() - fetchedAt;
+  if (age > CACHE_TTL_MS || (!keys.has(kid) && age > MIN_REFETCH_MS)) {
+    await refreshKeys();
+  }
+  return keys.get(kid);
+};
+
+const decodePart = (part) => JSON.parse(Buffer.from(part, 'base64url').toString('utf8'));
+
+// Resolves with the token's claims, or rejects if it is not a valid,
+// unexpired token signed by one of the published keys
+const verifyAccessToken = async (token) => {
+  const parts = (token || '').split('.');
+  if (parts.length !== 3) throw new Error('Malformed token');
+
+  const header = decodePart(parts[0]);
+  if (!header.kid) {
+    // Legacy HS256 tokens, only while a shared secret is still configured
+    if (!process.env.JWT_SECRET) throw new Error('Token has no kid');
+    return jwt.verify(token, process.env.JWT_SECRET, { algorithms: ['HS256'] });
+  }
+
+  const entry = await getKey(header.kid);
+  if (!entry) throw new Error(`Unknown signing key ${header.kid}`);
+  if (header.alg !== entry.alg) throw new Error(`Token alg ${header.alg} does not match key ${header.kid}`);
+
+  const data = Buffer.from(`${parts[0]}.${parts[1]}`);
+  const signature = Buffer.from(parts[2], 'base64url');
+  let valid = false;
+  if (header.alg === 'EdDSA') {
+    valid = crypto.verify(null, data, entry.key, signature);
+  } else if (header.alg === 'RS256') {
+    valid = crypto.verify('sha256', data, entry.key, signature);
+  }
+  if (!valid) throw new Error('Invalid signature');
+
+  const claims = decodePart(parts[1]);
+  if (typeof claims.exp !== 'number' || claims.exp * 1000 <= Date.now()) {
+    throw new Error('Token expired');
+  }
+  return claims;
+};
+
+module.exports = { verifyAccessToken };
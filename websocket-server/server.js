@@ -7,6 +7,7 @@ const logger = require('./logger');
 const { validateFields } = require('./validation');
 const { Resend } = require('resend');
 const { initDB, readDB, writeDB } = require("./persistence");
+const { verifyAccessToken } = require('./jwks');
 
 const app = express();
 const resend = new Resend(process.env.RESEND_API_KEY);
@@ -77,13 +78,12 @@ const authenticateJWT = (req, res, next) => {
 
   if (authHeader) {
     const token = authHeader.split(' ')[1];
-    jwt.verify(token, process.env.JWT_SECRET || 'stacklevest-secret-2025', (err, user) => {
-      if (err) {
-        return res.sendStatus(403);
-      }
-      req.user = user;
-      next();
-    });
+    verifyAccessToken(token)
+      .then(user => {
+        req.user = user;
+        next();
+      })
+      .catch(() => res.sendStatus(403));
   } else {
     res.sendStatus(401);
   }
@@ -411,8 +411,6 @@ app.post('/api/login', async (req, res) => {
 });
 // -----------------------------------
 
-const jwt = require('jsonwebtoken');
-
 // Middleware for Socket.IO Authentication & Role Assignment
 io.use((socket, next) => {
   const token = socket.handshake.auth.token;
@@ -421,12 +419,7 @@ io.use((socket, next) => {
 
   // 1. JWT Authentication (Mandatory)
   if (token) {
-    jwt.verify(token, process.env.JWT_SECRET || 'stacklevest-secret-2025', (err, decoded) => {
-      if (err) {
-        console.error("JWT Verification failed:", err.message);
-        return next(new Error("Authentication error: Invalid Token"));
-      }
-
+    verifyAccessToken(token).then(decoded => {
       // Refresh users from DB to ensure latest data
       db = readDB();
       users = db.users;
@@ -438,6 +431,9 @@ io.use((socket, next) => {
 
       socket.user = user;
       next();
+    }).catch(err => {
+      console.error("JWT Verification failed:", err.message);
+      next(new Error("Authentication error: Invalid Token"));
     });
   } else {
     return next(new Error("Authentication error: Token required"));